@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/workers"
+)
+
+func renderCmd(settings internal.Settings) *cobra.Command {
+	var output string
+	var diff bool
+
+	cmd := &cobra.Command{
+		Use:   "render [paths...]",
+		Short: "Show the nginx configs that would be generated",
+		Long: "Show the nginx configs that would be generated for the services in config files,\n" +
+			"without running nginx, getting certificates or checking the upstreams.\n" +
+			"Directories are walked like CONFIG_DIR, which is used if no paths are given",
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := args
+			if len(paths) == 0 {
+				for _, root := range filepath.SplitList(settings.CONFIG_DIR) {
+					if root != "" {
+						paths = append(paths, root)
+					}
+				}
+			}
+
+			templates, err := internal.GetTemplates()
+			if err != nil {
+				return fmt.Errorf("could not get templates: %w", err)
+			}
+
+			// The IDs of the services are in the names of the files.
+			// Without the state DB, they are guessed from CONFIG_OUTPUT_DIR
+			var db *sql.DB
+			if settings.STATE_DB_PATH != "" {
+				db, err = openStateDB(settings)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+			}
+
+			services, err := workers.RenderConfigs(cmd.Context(), db, settings, templates, paths)
+			if err != nil {
+				return err
+			}
+			warnGuessedIDs(cmd.ErrOrStderr(), services)
+
+			switch {
+			case diff:
+				return diffRendered(cmd.OutOrStdout(), services)
+			case output != "":
+				return writeRendered(settings.CONFIG_OUTPUT_DIR, output, services)
+			default:
+				printRendered(cmd.OutOrStdout(), services)
+				return nil
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the configs to this directory instead of printing them")
+	cmd.Flags().BoolVar(&diff, "diff", false, "show the changes from the configs in CONFIG_OUTPUT_DIR")
+	cmd.MarkFlagsMutuallyExclusive("output", "diff")
+
+	return cmd
+}
+
+// warnGuessedIDs notes the services whose ID was taken from the files in CONFIG_OUTPUT_DIR
+func warnGuessedIDs(w io.Writer, services []workers.RenderedService) {
+	for _, s := range services {
+		if s.IDGuessed {
+			fmt.Fprintf(w, "warning: the ID of %q in %s was guessed from the files in CONFIG_OUTPUT_DIR. Set STATE_DB_PATH to get it from the state DB\n", s.Name, s.File)
+		}
+	}
+}
+
+func printRendered(w io.Writer, services []workers.RenderedService) {
+	for _, s := range services {
+		for _, c := range s.Configs {
+			fmt.Fprintf(w, "# %s (%q in %s)\n", c.Path, s.Name, s.File)
+			w.Write(c.Content)
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// writeRendered writes the configs to dir in the same layout as CONFIG_OUTPUT_DIR
+func writeRendered(outputDir, dir string, services []workers.RenderedService) error {
+	for _, s := range services {
+		for _, c := range s.Configs {
+			rel, err := filepath.Rel(outputDir, c.Path)
+			if err != nil {
+				return err
+			}
+
+			path := filepath.Join(dir, rel)
+			err = os.MkdirAll(filepath.Dir(path), 0o755)
+			if err != nil {
+				return fmt.Errorf("could not create directory for %q: %w", path, err)
+			}
+
+			err = os.WriteFile(path, c.Content, 0o644)
+			if err != nil {
+				return fmt.Errorf("could not write %q: %w", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffRendered prints the changes to CONFIG_OUTPUT_DIR as a unified diff
+func diffRendered(w io.Writer, services []workers.RenderedService) error {
+	for _, s := range services {
+		for _, c := range s.Configs {
+			err := diffFile(w, c.Path, c.Content, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, path := range s.Stale {
+			err := diffFile(w, path, nil, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffFile prints the changes from the file at path to the content with diff.
+// Nothing is printed if they are the same
+func diffFile(w io.Writer, path string, content []byte, removed bool) error {
+	oldPath, oldLabel := path, path
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		oldPath, oldLabel = os.DevNull, os.DevNull
+	}
+
+	newLabel := path
+	if removed {
+		newLabel = os.DevNull
+	}
+
+	cmd := exec.Command("diff", "-u", "-L", oldLabel, "-L", newLabel, oldPath, "-")
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+
+	// diff exits with 1 if the files are different
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not diff %q: %w", path, err)
+	}
+
+	return nil
+}
@@ -2,64 +2,61 @@ package cmd
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/stephenafamo/orchestra"
 	"github.com/stephenafamo/warden/internal"
-	_ "modernc.org/sqlite"
+	"github.com/stephenafamo/warden/workers"
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute(ctx context.Context, settings internal.Settings) error {
+	logger, err := internal.NewLogger(os.Stderr, settings)
+	if err != nil {
+		return err
+	}
+	// Also used by the log package, so libraries log in the same format
+	slog.SetDefault(logger)
+
 	// rootCmd represents the base command when called without any subcommands
 	rootCmd := &cobra.Command{
 		Use:   "warden",
 		Short: "Setup and manage a reverse proxy",
 		Long:  "Setup and manage a reverse proxy",
+		// The args are the workers to start
+		Args: cobra.ArbitraryArgs,
+		// Printed by main, with the errors from before the command runs
+		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			log.Println("Cleaning up...")
-			c := exec.Command(
-				"/bin/sh",
-				"-c",
-				fmt.Sprintf(
-					"rm -rf %s %s",
-					filepath.Join(settings.CONFIG_OUTPUT_DIR, "/http/*"),
-					filepath.Join(settings.CONFIG_OUTPUT_DIR, "/streams/*"),
-				),
-			)
-
-			output, err := c.CombinedOutput()
-			if err != nil {
-				return fmt.Errorf(
-					"Error cleaning up: %s: %s",
-					err,
-					output,
-				)
+			if settings.EMAIL == "" {
+				return fmt.Errorf("EMAIL must be set")
 			}
 
-			log.Println("Connecting to DB...")
-			db, err := sql.Open("sqlite", "file::memory:?_fk=1&cache=shared&mode=memory")
+			slog.Info("connecting to DB", "path", settings.STATE_DB_PATH)
+			db, err := internal.OpenDB(settings.STATE_DB_PATH)
 			if err != nil {
 				return err
 			}
-			db.SetMaxOpenConns(1)
 			defer db.Close()
 
-			log.Println("Creating tables...")
-			err = createTables(db)
+			slog.Info("running migrations")
+			err = internal.Migrate(db)
 			if err != nil {
 				return err
 			}
 
+			slog.Info("cleaning up")
+			err = workers.ReconcileState(cmd.Context(), db, settings)
+			if err != nil {
+				return fmt.Errorf("Error cleaning up: %w", err)
+			}
+
 			conductor := &orchestra.Conductor{
 				Timeout: 15 * time.Second,
 				Players: make(map[string]orchestra.Player),
@@ -96,5 +93,9 @@ func Execute(ctx context.Context, settings internal.Settings) error {
 		},
 	}
 
+	rootCmd.AddCommand(statusCmd(settings))
+	rootCmd.AddCommand(validateCmd(settings))
+	rootCmd.AddCommand(renderCmd(settings))
+
 	return rootCmd.ExecuteContext(ctx)
 }
@@ -3,11 +3,13 @@ package cmd
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/stephenafamo/janus/monitor"
 	jSentry "github.com/stephenafamo/janus/monitor/sentry"
 	"github.com/stephenafamo/orchestra"
+	"github.com/stephenafamo/warden/docker"
 	"github.com/stephenafamo/warden/internal"
 	"github.com/stephenafamo/warden/workers"
 )
@@ -26,6 +28,20 @@ func setPlayers(db *sql.DB, settings internal.Settings, mon monitor.Monitor) (ma
 		Settings: settings,
 	}
 
+	if settings.DOCKER_DISCOVERY {
+		client, err := docker.NewClient(settings.DOCKER_HOST)
+		if err != nil {
+			return nil, fmt.Errorf("could not get docker client: %w", err)
+		}
+
+		players["docker-discoverer"] = workers.DockerDiscoverer{
+			DB:       db,
+			Monitor:  mon,
+			Settings: settings,
+			Client:   client,
+		}
+	}
+
 	players["service-configurer"] = workers.ServiceConfigurer{
 		DB:       db,
 		Monitor:  mon,
@@ -39,6 +55,36 @@ func setPlayers(db *sql.DB, settings internal.Settings, mon monitor.Monitor) (ma
 		Templates: templates,
 	}
 
+	players["webhook-sender"] = workers.WebhookSender{
+		DB:       db,
+		Monitor:  mon,
+		Settings: settings,
+	}
+
+	if settings.HEALTH_CHECK_INTERVAL > 0 {
+		players["upstream-monitor"] = workers.UpstreamMonitor{
+			DB:       db,
+			Monitor:  mon,
+			Settings: settings,
+		}
+	}
+
+	if settings.ADMIN_ADDR != "" {
+		players["admin-server"] = workers.AdminServer{
+			DB:       db,
+			Monitor:  mon,
+			Settings: settings,
+		}
+	}
+
+	if settings.METRICS_ADDR != "" {
+		players["metrics-server"] = workers.MetricsServer{
+			DB:       db,
+			Monitor:  mon,
+			Settings: settings,
+		}
+	}
+
 	players["nginx-server"] = workers.NginxServer{
 		Settings: settings,
 		Monitor:  mon,
@@ -53,13 +99,10 @@ func getMonitor(config internal.Settings) (monitor.Monitor, error) {
 		Debug: true,
 	}
 
-	// Add logging during testing
-	if config.TESTING || config.SENTRY_DSN == "" {
-		options.Integrations = func(in []sentry.Integration) []sentry.Integration {
-			return append(in, jSentry.LoggingIntegration{
-				Logger:        sentryLogger{},
-				SupressErrors: config.TESTING,
-			})
+	// Errors are logged by loggingMonitor, so they are only dropped here
+	if config.TESTING {
+		options.BeforeSend = func(*sentry.Event, *sentry.EventHint) *sentry.Event {
+			return nil
 		}
 	}
 
@@ -70,11 +113,21 @@ func getMonitor(config internal.Settings) (monitor.Monitor, error) {
 	}
 
 	hub := sentry.NewHub(client, sentry.NewScope())
-	return jSentry.Sentry{Hub: hub}, nil
+	return loggingMonitor{Monitor: jSentry.Sentry{Hub: hub}}, nil
 }
 
-type sentryLogger struct{}
+// loggingMonitor logs every error and message before it is sent to the monitor.
+// The tags are added to the log, so both have the same context
+type loggingMonitor struct {
+	monitor.Monitor
+}
+
+func (l loggingMonitor) CaptureMessage(msg string, tags map[string]string) {
+	slog.Warn(msg, internal.TagAttrs(tags)...)
+	l.Monitor.CaptureMessage(msg, tags)
+}
 
-func (sentryLogger) Printf(format string, a ...interface{}) (n int, err error) {
-	return fmt.Printf(format, a...)
+func (l loggingMonitor) CaptureException(err error, tags map[string]string) {
+	slog.Error(err.Error(), internal.TagAttrs(tags)...)
+	l.Monitor.CaptureException(err, tags)
 }
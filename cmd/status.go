@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+func statusCmd(settings internal.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the state of every service",
+		Long:  "Show the state of every service from the state DB of a running instance",
+		Args:  cobra.NoArgs,
+		// The errors are about the state DB, not how the command is used
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// An in-memory DB is not shared with the running instance
+			if settings.STATE_DB_PATH == "" {
+				return fmt.Errorf("STATE_DB_PATH must be set to get the status")
+			}
+
+			db, err := openStateDB(settings)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			services, err := models.Services(
+				qm.Load(models.ServiceRels.File),
+				qm.OrderBy(models.ServiceColumns.FileID+", "+models.ServiceColumns.Name),
+			).All(cmd.Context(), db)
+			if err != nil {
+				return fmt.Errorf("could not get services: %w", err)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SERVICE\tFILE\tSTATE\tCERT EXPIRES\tFAILURES\tNEXT RETRY\tLAST ERROR")
+
+			for _, s := range services {
+				file := "-"
+				if s.R != nil && s.R.File != nil {
+					file = s.R.File.Path
+				}
+
+				state := s.State
+				if s.CertFallback {
+					state += " (fallback certificate)"
+				}
+
+				failures := "-"
+				if s.CertFailures > 0 {
+					failures = strconv.FormatInt(s.CertFailures, 10)
+				}
+
+				lastError := "-"
+				if s.LastError.Valid {
+					lastError = s.LastError.String
+				}
+
+				fmt.Fprintf(
+					w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					s.Name, file, state, formatTime(s.CertNotAfter),
+					failures, formatTime(s.CertRetryAt), lastError,
+				)
+			}
+
+			err = w.Flush()
+			if err != nil {
+				return err
+			}
+
+			invalid, err := models.Files(
+				models.FileWhere.Error.IsNotNull(),
+				qm.OrderBy(models.FileColumns.Path),
+			).All(cmd.Context(), db)
+			if err != nil {
+				return fmt.Errorf("could not get files: %w", err)
+			}
+			if len(invalid) == 0 {
+				return nil
+			}
+
+			// The services of these files are from the last time they were valid
+			fmt.Fprintln(cmd.OutOrStdout())
+			w = tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "INVALID FILE\tERROR")
+			for _, f := range invalid {
+				fmt.Fprintf(w, "%s\t%s\n", f.Path, strings.ReplaceAll(f.Error.String, "\n", "; "))
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func formatTime(t null.Time) string {
+	if !t.Valid {
+		return "-"
+	}
+
+	return t.Time.Local().Format(time.RFC3339)
+}
+
+// openStateDB opens the state DB of a running instance to read it
+func openStateDB(settings internal.Settings) (*sql.DB, error) {
+	// Opening it would create it
+	if _, err := os.Stat(settings.STATE_DB_PATH); err != nil {
+		return nil, fmt.Errorf("could not find the state DB: %w", err)
+	}
+
+	db, err := internal.OpenDB(settings.STATE_DB_PATH)
+	if err != nil {
+		return nil, err
+	}
+
+	// Migrating would change the schema under the running instance
+	err = internal.CheckSchema(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
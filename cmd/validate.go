@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/workers"
+)
+
+func validateCmd(settings internal.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [paths...]",
+		Short: "Check config files for problems",
+		Long: "Check config files for problems without configuring anything.\n" +
+			"Directories are walked like CONFIG_DIR, which is used if no paths are given.\n" +
+			"Exits with an error if any problem is found. Warnings are printed but are not problems",
+		Args: cobra.ArbitraryArgs,
+		// The problems are already printed
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := args
+			if len(paths) == 0 {
+				for _, root := range filepath.SplitList(settings.CONFIG_DIR) {
+					if root != "" {
+						paths = append(paths, root)
+					}
+				}
+			}
+
+			diagnostics, err := workers.ValidateConfigFiles(settings, paths)
+			if err != nil {
+				return err
+			}
+
+			problems := 0
+			for _, d := range diagnostics {
+				fmt.Fprintln(cmd.OutOrStdout(), d)
+				if !d.Warning {
+					problems++
+				}
+			}
+
+			switch problems {
+			case 0:
+				return nil
+			case 1:
+				return fmt.Errorf("found 1 problem")
+			default:
+				return fmt.Errorf("found %d problems", problems)
+			}
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client is a minimal client for the Docker Engine API
+type Client struct {
+	HTTP    *http.Client
+	BaseURL string
+}
+
+type Container struct {
+	ID              string            `json:"Id"`
+	Names           []string          `json:"Names"`
+	Labels          map[string]string `json:"Labels"`
+	Created         int64             `json:"Created"`
+	State           string            `json:"State"`
+	NetworkSettings struct {
+		Networks map[string]Network `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+type Network struct {
+	IPAddress         string `json:"IPAddress"`
+	GlobalIPv6Address string `json:"GlobalIPv6Address"`
+}
+
+// NewClient creates a client for the docker host.
+// The host is in the same format as the DOCKER_HOST used by the docker CLI
+// e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375
+func NewClient(host string) (*Client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse docker host %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &Client{
+			HTTP:    &http.Client{Transport: transport, Timeout: 10 * time.Second},
+			BaseURL: "http://docker", // The host is ignored when dialing the socket
+		}, nil
+
+	case "tcp", "http":
+		return &Client{
+			HTTP:    &http.Client{Timeout: 10 * time.Second},
+			BaseURL: "http://" + u.Host,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q", u.Scheme)
+	}
+}
+
+// Containers lists the running containers that have the given label
+func (c *Client) Containers(ctx context.Context, label string) ([]Container, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"label":  {label},
+		"status": {"running"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode filters: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("filters", string(filters))
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet,
+		c.BaseURL+"/containers/json?"+query.Encode(),
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not list containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("could not list containers: %s: %s", resp.Status, body)
+	}
+
+	var containers []Container
+	err = json.NewDecoder(resp.Body).Decode(&containers)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode containers: %w", err)
+	}
+
+	return containers, nil
+}
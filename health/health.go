@@ -0,0 +1,192 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/kronika"
+	"github.com/stephenafamo/warden/internal"
+)
+
+const (
+	defaultTimeout    = 5 * time.Second
+	defaultRetryDelay = time.Second
+)
+
+// Checker checks if an upstream address is reachable
+type Checker interface {
+	Check(ctx context.Context, address string) error
+}
+
+// NewChecker returns the checker for the type in the config
+// It returns nil if the checks are disabled
+func NewChecker(config internal.HealthCheck) (Checker, error) {
+	switch strings.ToLower(config.Type) {
+	case "", "tcp":
+		return TCPChecker{}, nil
+	case "http":
+		return HTTPChecker{
+			Path:           config.Path,
+			Host:           config.Host,
+			ExpectedStatus: config.ExpectedStatus,
+		}, nil
+	case "https":
+		return HTTPChecker{
+			Path:               config.Path,
+			Host:               config.Host,
+			ExpectedStatus:     config.ExpectedStatus,
+			TLS:                true,
+			InsecureSkipVerify: config.InsecureSkipVerify,
+		}, nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown health check type %q", config.Type)
+	}
+}
+
+// Check checks the upstream server using its health check config
+// Each attempt is limited by the timeout, and failed attempts are retried
+func Check(ctx context.Context, upstream internal.UpstreamServer) error {
+	config := upstream.HealthCheck
+
+	checker, err := NewChecker(config)
+	if err != nil {
+		return err
+	}
+	if checker == nil {
+		return nil
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = checker.Check(attemptCtx, upstream.Address)
+		cancel()
+
+		if err == nil || attempt >= config.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		kronika.WaitFor(ctx, defaultRetryDelay)
+	}
+}
+
+// TCPChecker checks that a connection can be opened to the address
+type TCPChecker struct{}
+
+func (TCPChecker) Check(ctx context.Context, address string) error {
+	network, address := dialAddress(address, "80")
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("could not connect: %w", err)
+	}
+
+	return conn.Close()
+}
+
+// HTTPChecker sends a GET request to the address and checks the status
+type HTTPChecker struct {
+	Path           string // Default "/"
+	Host           string // Host header. Default is the address
+	ExpectedStatus int    // Default is any 2xx or 3xx status
+	TLS            bool
+
+	InsecureSkipVerify bool
+}
+
+func (h HTTPChecker) Check(ctx context.Context, address string) error {
+	scheme, defaultPort := "http", "80"
+	if h.TLS {
+		scheme, defaultPort = "https", "443"
+	}
+
+	network, dialAddr := dialAddress(address, defaultPort)
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, dialAddr)
+		},
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: h.InsecureSkipVerify,
+			ServerName:         h.Host,
+		},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{
+		Transport: transport,
+		// The status of the redirect is what we check
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	host := h.Host
+	if host == "" {
+		host = dialAddr
+		if network == "unix" {
+			host = "localhost"
+		}
+	}
+
+	path := h.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+host+path, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if h.ExpectedStatus != 0 {
+		if resp.StatusCode != h.ExpectedStatus {
+			return fmt.Errorf("expected status %d, got %d", h.ExpectedStatus, resp.StatusCode)
+		}
+		return nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// dialAddress converts an nginx upstream address to what is needed to dial it
+// e.g. "unix:/tmp/app.sock", "app:8080" or "app" which uses the default port
+func dialAddress(address, defaultPort string) (network string, addr string) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", path
+	}
+
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return "tcp", address
+	}
+
+	// IPv6 addresses are written as [::1] in nginx
+	host := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	return "tcp", net.JoinHostPort(host, defaultPort)
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The key types that certificates can be requested with
+const (
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeRSA2048   = "rsa2048"
+	KeyTypeRSA4096   = "rsa4096"
+)
+
+// CertificateInfo is what we keep track of about the certificate of a service
+type CertificateInfo struct {
+	NotAfter    time.Time
+	Issuer      string
+	SANs        []string
+	Fingerprint string // SHA-256 of the leaf certificate. Changes when the file is replaced
+	KeyType     string // e.g. ecdsa-p256 or rsa2048
+}
+
+// ReadCertificateInfo reads the first (leaf) certificate in the PEM file at path
+func ReadCertificateInfo(path string) (CertificateInfo, error) {
+	var info CertificateInfo
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return info, fmt.Errorf("could not read certificate: %w", err)
+	}
+
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return info, fmt.Errorf("no certificate found in %q", path)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return info, fmt.Errorf("could not parse certificate: %w", err)
+		}
+
+		sum := sha256.Sum256(cert.Raw)
+
+		info.NotAfter = cert.NotAfter
+		info.Issuer = cert.Issuer.String()
+		info.SANs = cert.DNSNames
+		info.Fingerprint = hex.EncodeToString(sum[:])
+		info.KeyType = PublicKeyType(cert.PublicKey)
+		for _, ip := range cert.IPAddresses {
+			info.SANs = append(info.SANs, ip.String())
+		}
+
+		return info, nil
+	}
+}
+
+// PublicKeyType names the type of the key in the same way as the KeyType constants
+func PublicKeyType(pub any) string {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ecdsa-p%d", key.Curve.Params().BitSize)
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa%d", key.N.BitLen())
+	case ed25519.PublicKey:
+		return "ed25519"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+// GenerateKey generates a private key of the given type for a certificate
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+}
+
+// ParsePrivateKey parses a PKCS8, EC or PKCS1 DER encoded private key
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("could not parse private key")
+}
+
+// EncodePrivateKey encodes the key as a PKCS8 PEM block
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// WriteFileAtomic makes sure nginx never reads a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+
+	err := os.WriteFile(tmp, data, perm)
+	if err != nil {
+		return fmt.Errorf("could not write %q: %w", tmp, err)
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("could not move %q to %q: %w", tmp, path, err)
+	}
+
+	return nil
+}
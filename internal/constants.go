@@ -5,4 +5,6 @@ const (
 	StateToConfigureHttps = "to configure https"
 	StateToDisableHttp    = "to disable http"
 	StateConfigured       = "configured"
+	StateRefreshUpstreams = "to refresh upstreams"
+	StateFailed           = "failed"
 )
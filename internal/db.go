@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenDB opens the state database. If no path is given, the state is only kept in memory
+func OpenDB(path string) (*sql.DB, error) {
+	dsn := "file::memory:?_pragma=foreign_keys(1)&cache=shared&mode=memory"
+
+	if path != "" {
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return nil, fmt.Errorf("could not create state db directory: %w", err)
+		}
+		dsn = fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// migrations are run in order, and each one is only run once.
+// The number of migrations that have been run is kept in the user_version of the DB.
+// Do not change a migration that has been released. Add a new one instead
+var migrations = [][]string{
+	// 1: initial schema
+	{
+		`CREATE TABLE files (
+			id INTEGER NOT NULL PRIMARY KEY,
+			path TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			content TEXT NOT NULL,
+			is_configured BOOLEAN NOT NULL DEFAULT FALSE,
+			last_modified DATETIME NOT NULL,
+			checksum TEXT NOT NULL,
+			source TEXT NOT NULL
+		);`,
+
+		// name is the name of the service in the config file
+		`CREATE TABLE services (
+			id INTEGER NOT NULL PRIMARY KEY,
+			file_id INTEGER REFERENCES files (id) ON DELETE CASCADE ON UPDATE CASCADE,
+			name TEXT NOT NULL,
+			content TEXT NOT NULL,
+			state TEXT NOT NULL,
+			is_ssl BOOLEAN NOT NULL,
+			https_configured DATETIME,
+			last_modified DATETIME NOT NULL,
+			last_error TEXT
+		);`,
+
+		`CREATE TABLE nginx_configs (
+			id INTEGER NOT NULL PRIMARY KEY,
+			service_id INTEGER REFERENCES services (id) ON DELETE SET NULL ON UPDATE CASCADE,
+			type TEXT NOT NULL,
+			path TEXT NOT NULL UNIQUE,
+			last_modified DATETIME NOT NULL
+		);`,
+
+		// Every probe of an upstream server of a configured service.
+		// The latest one for an address decides if the server is in rotation
+		`CREATE TABLE upstream_checks (
+			id INTEGER NOT NULL PRIMARY KEY,
+			service_id INTEGER REFERENCES services (id) ON DELETE CASCADE ON UPDATE CASCADE,
+			address TEXT NOT NULL,
+			is_healthy BOOLEAN NOT NULL,
+			error TEXT,
+			checked_at DATETIME NOT NULL
+		);`,
+
+		`CREATE INDEX upstream_checks_service_address
+			ON upstream_checks (service_id, address, checked_at);`,
+	},
+
+	// 2: details of the certificate of https services
+	{
+		`ALTER TABLE services ADD COLUMN cert_not_after DATETIME;`,
+		`ALTER TABLE services ADD COLUMN cert_issuer TEXT;`,
+		`ALTER TABLE services ADD COLUMN cert_sans TEXT;`,
+		`ALTER TABLE services ADD COLUMN cert_fingerprint TEXT;`,
+	},
+
+	// 3: where the certificate of https services is, so it can be shared
+	{
+		`ALTER TABLE services ADD COLUMN cert_path TEXT;`,
+		`ALTER TABLE services ADD COLUMN key_path TEXT;`,
+	},
+
+	// 4: how the certificate of https services was requested
+	{
+		`ALTER TABLE services ADD COLUMN acme_directory TEXT;`,
+		`ALTER TABLE services ADD COLUMN key_type TEXT;`,
+	},
+
+	// 5: https services using a certificate from the local CA until they get theirs
+	{
+		`ALTER TABLE services ADD COLUMN cert_fallback BOOLEAN NOT NULL DEFAULT FALSE;`,
+	},
+
+	// 6: backing off after failing to get a certificate
+	{
+		`ALTER TABLE services ADD COLUMN cert_failures INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE services ADD COLUMN cert_retry_at DATETIME;`,
+	},
+
+	// 7: certificates to renew even if they are not due, e.g. from the admin API
+	{
+		`ALTER TABLE services ADD COLUMN renew_requested BOOLEAN NOT NULL DEFAULT FALSE;`,
+	},
+
+	// 8: events sent to the webhooks of services. They are retried until delivered.
+	// Deliveries are kept after the service is removed, so there is no foreign key
+	{
+		`CREATE TABLE webhook_deliveries (
+			id INTEGER NOT NULL PRIMARY KEY,
+			service_id INTEGER,
+			service_name TEXT NOT NULL,
+			url TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			signature TEXT,
+			state TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER,
+			last_error TEXT,
+			next_attempt_at DATETIME,
+			created_at DATETIME NOT NULL,
+			delivered_at DATETIME
+		);`,
+
+		`CREATE INDEX webhook_deliveries_pending
+			ON webhook_deliveries (state, next_attempt_at);`,
+	},
+
+	// 9: why a config file could not be used. Its services are kept as they were
+	{
+		`ALTER TABLE files ADD COLUMN error TEXT;`,
+	},
+
+	// 10: the format a config file is written in. toml, yaml or json
+	{
+		`ALTER TABLE files ADD COLUMN format TEXT NOT NULL DEFAULT 'toml';`,
+	},
+}
+
+// Migrate brings the schema of the DB up to date
+func Migrate(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("could not get schema version: %w", err)
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf(
+			"schema version %d is newer than the latest known version %d",
+			version, len(migrations),
+		)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		err = runMigration(db, i+1, migrations[i])
+		if err != nil {
+			return fmt.Errorf("could not run migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckSchema makes sure the schema is the one this binary uses, without migrating it.
+// It is for commands that only read the state DB of a running instance,
+// which may be another version
+func CheckSchema(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("could not get schema version: %w", err)
+	}
+
+	if version != len(migrations) {
+		return fmt.Errorf(
+			"schema version %d does not match version %d of this binary. Use the same version as the running instance",
+			version, len(migrations),
+		)
+	}
+
+	return nil
+}
+
+func runMigration(db *sql.DB, version int, statements []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	// PRAGMA does not support placeholders
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// NewLogger creates a logger with the LOG_LEVEL and LOG_FORMAT in the settings
+func NewLogger(w io.Writer, settings Settings) (*slog.Logger, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(settings.LOG_LEVEL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", settings.LOG_LEVEL, err)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(settings.LOG_FORMAT) {
+	case "", "text", "logfmt":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q. Use text or json", settings.LOG_FORMAT)
+	}
+}
+
+// TagAttrs turns the tags sent to the monitor into log attributes,
+// so that the logs and the monitor have the same context
+func TagAttrs(tags map[string]string) []any {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]any, len(keys))
+	for i, key := range keys {
+		attrs[i] = slog.String(key, tags[key])
+	}
+
+	return attrs
+}
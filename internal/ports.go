@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Ports used by the proxy itself. See config/nginx.conf
+var ReservedPorts = []PortRange{
+	{From: 80, To: 80, Protocol: "tcp"},
+	{From: 443, To: 443, Protocol: "tcp"},
+	{From: 4343, To: 4343, Protocol: "tcp"},
+}
+
+// PortRange is a range of ports a stream service listens on
+type PortRange struct {
+	From     uint
+	To       uint
+	Protocol string // tcp or udp
+}
+
+func (p PortRange) String() string {
+	if p.From == p.To {
+		return fmt.Sprintf("%d/%s", p.From, p.Protocol)
+	}
+
+	return fmt.Sprintf("%d-%d/%s", p.From, p.To, p.Protocol)
+}
+
+// Overlaps checks if both ranges share a port with the same protocol
+func (p PortRange) Overlaps(o PortRange) bool {
+	return p.Protocol == o.Protocol && p.From <= o.To && o.From <= p.To
+}
+
+// listen is the port in the format expected by the nginx listen directive
+func (p PortRange) listen() string {
+	if p.From == p.To {
+		return strconv.FormatUint(uint64(p.From), 10)
+	}
+
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+// StreamProtocol is the protocol stream services of this type listen with
+func (s Service) StreamProtocol() string {
+	if strings.ToLower(s.Type) == "udp" {
+		return "udp"
+	}
+
+	return "tcp"
+}
+
+// PortRanges returns all the ports a stream service listens on
+func (s Service) PortRanges() ([]PortRange, error) {
+	protocol := s.StreamProtocol()
+
+	var ranges []PortRange
+	if s.Port != 0 {
+		if s.Port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", s.Port)
+		}
+		ranges = append(ranges, PortRange{From: s.Port, To: s.Port, Protocol: protocol})
+	}
+
+	for _, port := range s.Ports {
+		r, err := parsePortRange(port, protocol)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no port set")
+	}
+
+	return ranges, nil
+}
+
+// StreamListen returns the parameters of each listen directive for a stream service
+// Errors in the ports are ignored since they are checked when the service is added
+func (s Service) StreamListen() []string {
+	ranges, _ := s.PortRanges()
+
+	suffix := ""
+	if s.StreamProtocol() == "udp" {
+		suffix += " udp"
+	}
+	if s.AcceptProxyProtocol {
+		suffix += " proxy_protocol"
+	}
+
+	var listen []string
+	for _, r := range ranges {
+		listen = append(listen, r.listen()+suffix)
+		if !s.DisableIPv6 {
+			listen = append(listen, "[::]:"+r.listen()+suffix)
+		}
+	}
+
+	return listen
+}
+
+func parsePortRange(port, protocol string) (PortRange, error) {
+	from, to, isRange := strings.Cut(strings.TrimSpace(port), "-")
+	if !isRange {
+		to = from
+	}
+
+	start, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+	if err != nil || start == 0 {
+		return PortRange{}, fmt.Errorf("invalid port %q", port)
+	}
+
+	end, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+	if err != nil || end < start {
+		return PortRange{}, fmt.Errorf("invalid port range %q", port)
+	}
+
+	return PortRange{From: uint(start), To: uint(end), Protocol: protocol}, nil
+}
@@ -17,6 +17,11 @@ func GetTemplates() (*template.Template, error) {
 		panic(err)
 	}
 
+	err = parseSni(t)
+	if err != nil {
+		panic(err)
+	}
+
 	err = parseHttps(t)
 	if err != nil {
 		panic(err)
@@ -36,7 +41,7 @@ func parseHttp(t *template.Template) error {
         {{- if .Location -}}
         upstream {{.Unique}} {
             {{range .Upstream }}
-            server {{.Address}}{{range .Parameters}} {{.}}{{end}};
+            server {{.Address}}{{range .Parameters}} {{.}}{{end}}{{if .Down}} down{{end}};
             {{- end}}
             {{range $i, $x := $.UpstreamOptions }}
             {{ $i }} {{ $x }};
@@ -47,7 +52,7 @@ func parseHttp(t *template.Template) error {
         {{range $i, $x := $.Locations }}
         upstream {{$.Unique}}-{{$i}} {
             {{range $x.Upstream }}
-            server {{.Address}}{{range .Parameters}} {{.}}{{end}};
+            server {{.Address}}{{range .Parameters}} {{.}}{{end}}{{if .Down}} down{{end}};
             {{- end}}
             {{range $j, $y := $x.UpstreamOptions }}
             {{ $j }} {{ $y }};
@@ -112,7 +117,7 @@ func parseStream(t *template.Template) error {
 	_, err := nt.Parse(`
         upstream {{.Unique}}  {
             {{range .Upstream }}
-            server {{.Address}}{{range .Parameters}} {{.}}{{end}};
+            server {{.Address}}{{range .Parameters}} {{.}}{{end}}{{if .Down}} down{{end}};
             {{- end}}
             {{range $i, $x := $.UpstreamOptions }}
             {{ $i }} {{ $x }};
@@ -120,10 +125,14 @@ func parseStream(t *template.Template) error {
         }
 
         server {
-            listen 80;
-            listen [::]:80;
+            {{- range .StreamListen}}
+            listen {{.}};
+            {{- end}}
 
             proxy_pass {{.Unique}};
+            {{- if .ProxyProtocol}}
+            proxy_protocol on;
+            {{- end}}
             {{range $i, $x := $.ServerOptions }}
             {{ $i }} {{ $x }};
             {{- end}}
@@ -136,6 +145,35 @@ func parseStream(t *template.Template) error {
 	return nil
 }
 
+func parseSni(t *template.Template) error {
+	nt := t.New("sniUpstream")
+	_, err := nt.Parse(`
+        upstream {{.Unique}} {
+            {{range .Upstream }}
+            server {{.Address}}{{range .Parameters}} {{.}}{{end}}{{if .Down}} down{{end}};
+            {{- end}}
+            {{range $i, $x := $.UpstreamOptions }}
+            {{ $i }} {{ $x }};
+            {{- end}}
+        }
+    `)
+	if err != nil {
+		return err
+	}
+
+	nt = t.New("sniMap")
+	_, err = nt.Parse(`
+        {{- range .Domains}}
+        {{.}} {{$.Unique}};
+        {{- end}}
+    `)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func parseHttps(t *template.Template) error {
 	nt := t.New("https")
 	_, err := nt.Parse(`
@@ -210,7 +248,7 @@ func parseHttptoHttps(t *template.Template) error {
         {{if .Location -}}
         upstream {{.Unique}} {
             {{range .Upstream }}
-            server {{.Address}}{{range .Parameters}} {{.}}{{end}};
+            server {{.Address}}{{range .Parameters}} {{.}}{{end}}{{if .Down}} down{{end}};
             {{- end}}
             {{range $i, $x := $.UpstreamOptions }}
             {{ $i }} {{ $x }};
@@ -221,7 +259,7 @@ func parseHttptoHttps(t *template.Template) error {
         {{range $i, $x := $.Locations }}
         upstream {{$.Unique}}-{{$i}} {
             {{range $x.Upstream }}
-            server {{.Address}}{{range .Parameters}} {{.}}{{end}};
+            server {{.Address}}{{range .Parameters}} {{.}}{{end}}{{if .Down}} down{{end}};
             {{- end}}
             {{range $j, $y := $x.UpstreamOptions }}
             {{ $j }} {{ $y }};
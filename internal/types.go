@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"database/sql/driver"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -12,16 +13,88 @@ import (
 type Settings struct {
 	TESTING bool `env:"TESTING"`
 
-	EMAIL string `env:"EMAIL,required"` // for Let's Encrypt
+	// for Let's Encrypt. Only required to run the proxy, so that the
+	// other commands (e.g. validate in CI) can run without it
+	EMAIL string `env:"EMAIL"`
 
 	CONFIG_DIR         string        `env:"CONFIG_DIR,default=./config"`
 	CONFIG_RELOAD_TIME time.Duration `env:"CONFIG_RELOAD_TIME,default=5s"`
-	HTTPS_VALIDITY     time.Duration `env:"HTTPS_VALIDITY,default=168h"` // 7 days
+	HTTPS_VALIDITY     time.Duration `env:"HTTPS_VALIDITY,default=168h"` // 7 days. Only used if the certificate cannot be read
+
+	CERT_RENEWAL_WINDOW time.Duration `env:"CERT_RENEWAL_WINDOW,default=720h"` // renew this long before expiry. 30 days
+
+	// How long to wait before trying to get a certificate again after a failure
+	// It doubles with every failure, up to CERT_RETRY_MAX
+	CERT_RETRY_MIN time.Duration `env:"CERT_RETRY_MIN,default=1m"`
+	CERT_RETRY_MAX time.Duration `env:"CERT_RETRY_MAX,default=6h"`
+
+	CONFIG_WATCH_DEBOUNCE  time.Duration `env:"CONFIG_WATCH_DEBOUNCE,default=500ms"`
+	CONFIG_WATCH_MAX_DELAY time.Duration `env:"CONFIG_WATCH_MAX_DELAY,default=5s"` // even if the changes do not stop
+	CONFIG_RESYNC_TIME     time.Duration `env:"CONFIG_RESYNC_TIME,default=1m"`     // full walk in case events are missed
+
+	// What to do with keys in config files that are not used, e.g. a typo. error or warn
+	// With error, the file is not used until it is fixed and its services are kept as they were
+	CONFIG_UNKNOWN_KEYS string `env:"CONFIG_UNKNOWN_KEYS,default=error"`
+
+	// Where to keep the state between restarts. If empty, it is only kept in memory
+	STATE_DB_PATH string `env:"STATE_DB_PATH"`
 
 	CONFIG_OUTPUT_DIR           string `env:"CONFIG_OUTPUT_DIR,default=/etc/nginx/conf.d"`
 	LETSENCRYPT_CREDS_DIR       string `env:"LETSENCRYPT_CREDS_DIR,default=./letsencrypt-credentials"`
 	LETSENCRYPT_DNS_PROPAGATION int    `env:"LETSENCRYPT_DNS_PROPAGATION,default=120"`
 
+	// The ACME server to get certificates from. Default is Let's Encrypt
+	// or the Let's Encrypt staging server when TESTING
+	ACME_DIRECTORY string `env:"ACME_DIRECTORY"`
+	// External Account Binding, if required by ACME_DIRECTORY. e.g. ZeroSSL
+	ACME_EAB_KID      string `env:"ACME_EAB_KID"`
+	ACME_EAB_HMAC_KEY string `env:"ACME_EAB_HMAC_KEY"` // base64url encoded
+	ACME_KEY_TYPE     string `env:"ACME_KEY_TYPE,default=ecdsa-p256"`
+
+	LETSENCRYPT_CERTS_DIR    string `env:"LETSENCRYPT_CERTS_DIR,default=/etc/letsencrypt/live"`
+	LETSENCRYPT_ACCOUNTS_DIR string `env:"LETSENCRYPT_ACCOUNTS_DIR,default=/etc/letsencrypt/warden-accounts"`
+
+	// For the selfsigned and internal-ca SSL sources
+	LOCAL_CA_DIR        string        `env:"LOCAL_CA_DIR,default=/etc/warden/ca"`
+	LOCAL_CERTS_DIR     string        `env:"LOCAL_CERTS_DIR,default=/etc/warden/certs"`
+	LOCAL_CERT_VALIDITY time.Duration `env:"LOCAL_CERT_VALIDITY,default=2160h"` // 90 days
+
+	// Serve a short-lived certificate from the local CA while a certificate
+	// cannot be obtained, so that the https config is always there
+	SSL_FALLBACK           bool          `env:"SSL_FALLBACK,default=true"`
+	FALLBACK_CERT_VALIDITY time.Duration `env:"FALLBACK_CERT_VALIDITY,default=24h"`
+
+	// How often the upstreams of configured services are checked. 0 disables it
+	HEALTH_CHECK_INTERVAL time.Duration `env:"HEALTH_CHECK_INTERVAL,default=10s"`
+	HEALTH_CHECK_HISTORY  time.Duration `env:"HEALTH_CHECK_HISTORY,default=24h"` // how long to keep check results
+
+	// Discover services from the labels of running docker containers
+	DOCKER_DISCOVERY    bool   `env:"DOCKER_DISCOVERY"`
+	DOCKER_HOST         string `env:"DOCKER_HOST,default=unix:///var/run/docker.sock"`
+	DOCKER_LABEL_PREFIX string `env:"DOCKER_LABEL_PREFIX,default=warden"`
+	DOCKER_NETWORK      string `env:"DOCKER_NETWORK"` // network to get container IPs from. Default is the first one
+
+	// Address for the admin API. e.g. 127.0.0.1:8080. Disabled if empty
+	ADMIN_ADDR string `env:"ADMIN_ADDR"`
+	// If set, requests to the admin API must have it as a bearer token
+	ADMIN_TOKEN string `env:"ADMIN_TOKEN"`
+
+	// Address to serve Prometheus metrics on. e.g. :9100. Disabled if empty
+	METRICS_ADDR string `env:"METRICS_ADDR"`
+
+	// debug, info, warn or error
+	LOG_LEVEL string `env:"LOG_LEVEL,default=info"`
+	// text (logfmt) or json
+	LOG_FORMAT string `env:"LOG_FORMAT,default=text"`
+
+	// Events are retried until they are delivered or WEBHOOK_MAX_ATTEMPTS is reached.
+	// The wait doubles with every attempt, from WEBHOOK_RETRY_MIN up to WEBHOOK_RETRY_MAX
+	WEBHOOK_TIMEOUT      time.Duration `env:"WEBHOOK_TIMEOUT,default=5s"`
+	WEBHOOK_MAX_ATTEMPTS int64         `env:"WEBHOOK_MAX_ATTEMPTS,default=8"`
+	WEBHOOK_RETRY_MIN    time.Duration `env:"WEBHOOK_RETRY_MIN,default=10s"`
+	WEBHOOK_RETRY_MAX    time.Duration `env:"WEBHOOK_RETRY_MAX,default=1h"`
+	WEBHOOK_HISTORY      time.Duration `env:"WEBHOOK_HISTORY,default=168h"` // how long to keep finished deliveries
+
 	SENTRY_DSN string `env:"SENTRY_DSN"`
 }
 
@@ -33,12 +106,14 @@ type Config struct {
 type ServiceMap map[string]Service
 
 type Service struct {
-	Type            string // HTTP, TCP default HTTP
+	Type            string // HTTP, TCP, UDP, TLS-PASSTHROUGH, CERTIFICATE default HTTP
 	Upstream        []UpstreamServer
 	UpstreamOptions Options
 
 	// Parameters for HTTP proxy type
 	// Required for this type. Domains to proxy
+	// Also required for the TLS-PASSTHROUGH type, where connections on port 443
+	// are sent to the upstream without being decrypted, based on the SNI
 	Domains []string
 
 	// Default "/". will be used as "match" for default "Locations"
@@ -48,28 +123,54 @@ type Service struct {
 
 	Ssl       bool   // Whether to generate HTTPS configutation
 	HttpsOnly bool   // Wether to automatically redirect http to https. Default false
-	SslSource string // Required if Ssl = true. Options: manual, letsencrypt
+	SslSource string // Required if Ssl = true. Options: manual, letsencrypt, selfsigned, internal-ca
 	CertPath  string // If using manual sslSource
 	KeyPath   string // If using manual sslSource
-	// If this is provided, the appropriate letsencrypt dns plugin is used
-	// NOTE: if using --dns-digitalocean, this should be "digitalocean" only
-	//
-	// Aside from the plugins from certbot, some other plugins have been implemented
-	// in this program (e.g. vultr)
+	// The name of a service with Type CERTIFICATE to use instead of
+	// getting a certificate for this service. SslSource is not needed
+	Certificate string
+	// If this is provided, the DNS-01 challenge is used with this DNS provider
+	// instead of the HTTP-01 challenge.
+	// Options: vultr, cloudflare, digitalocean, rfc2136, route53
 	LetsEncryptDNSPlugin string
-	// If these are provided, the manual certbot plugin will be used
-	// instead of the webroot or dns plugin which is automatic completed
+	// If these are provided, they are used to solve the DNS-01 challenge
 	// These should be paths to executables which will be the "hooks"
+	// They get the same CERTBOT_DOMAIN and CERTBOT_VALIDATION environment variables as certbot's hooks
 	// See https://certbot.eff.org/docs/using.html#pre-and-post-validation-hooks
 	LetsEncryptAuthenticator string
 	LetsEncryptCleaner       string
+	// Optional: the ACME server to get the certificate from instead of ACME_DIRECTORY
+	// e.g. ZeroSSL, an internal step-ca or the Let's Encrypt staging server
+	AcmeDirectory string
+	// Optional: External Account Binding for AcmeDirectory, if it requires one
+	// The ACME_EAB_* settings are only used when AcmeDirectory is not set
+	AcmeEabKid     string
+	AcmeEabHmacKey string // base64url encoded
+	// Optional: ecdsa-p256, ecdsa-p384, rsa2048 or rsa4096. Default is ACME_KEY_TYPE
+	// Also used for the selfsigned and internal-ca sources
+	KeyType string
 
 	// parameters for TCP/UDP proxy type
-	Port          uint    // REQUIRED for this type
+	Port  uint     // REQUIRED for this type, unless Ports is set
+	Ports []string // Optional: more ports or ranges to listen on. e.g. ["5000", "6000-6010"]
+	// Optional: accept the PROXY protocol from clients. Not supported for UDP
+	AcceptProxyProtocol bool
+	// Optional: send the PROXY protocol to the upstream
+	ProxyProtocol bool
+	// Optional: only listen on IPv4
+	DisableIPv6   bool
 	ServerOptions Options // Optional
 
 	// A http endpoint to send notifications about the configuration stauts
 	Webhook string
+	// Optional: if set, every notification is signed with it. See the X-Warden-Signature header
+	WebhookSecret string
+}
+
+// IsCertificate reports if the service only declares a certificate
+// that other services can use. It is not proxied
+func (s Service) IsCertificate() bool {
+	return strings.ToLower(s.Type) == "certificate"
 }
 
 type Location struct {
@@ -91,6 +192,29 @@ type UpstreamServer struct {
 
 	// Optional: other parameters for the upstream. See http://nginx.org/en/docs/http/ngx_http_upstream_module.html#server
 	Parameters []string
+
+	// Optional: how to check that the upstream is reachable before configuring the service
+	// and while it is in rotation. Default is to open a TCP connection
+	HealthCheck HealthCheck
+
+	// Set when the last health check failed so the server is marked as down
+	Down bool `toml:"-" json:"-"`
+}
+
+type HealthCheck struct {
+	// tcp, http, https or none. Default tcp
+	Type string
+	// For http and https. The path to request. Default "/"
+	Path string
+	// For http and https. The Host header (and TLS server name). Default is the address
+	Host string
+	// For http and https. Default is any 2xx or 3xx status
+	ExpectedStatus int
+	// For https. Do not verify the certificate of the upstream
+	InsecureSkipVerify bool
+
+	Timeout time.Duration // For each attempt. Default 5s
+	Retries int           // Number of times to retry a failed check. Default 0
 }
 
 type Options = map[string]string
@@ -99,7 +223,8 @@ type Options = map[string]string
 func (u ServiceMap) Value() (driver.Value, error) {
 	buf := &bytes.Buffer{}
 	err := toml.NewEncoder(buf).Encode(u)
-	return buf.Bytes(), err
+	// An empty map is encoded as nothing, which must not be saved as NULL
+	return append([]byte{}, buf.Bytes()...), err
 }
 
 // Scan implements the Scanner interface.
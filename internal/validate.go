@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The options for LetsEncryptDNSPlugin. See letsencrypt.getDNSProvider
+var dnsPlugins = []string{"vultr", "cloudflare", "digitalocean", "rfc2136", "route53"}
+
+// The certbot DNS plugins that are not built in
+var removedDNSPlugins = []string{"dnsimple", "google", "linode", "ovh"}
+
+// Validate checks that the service has what is needed to configure it.
+// Every problem found is returned
+func (s Service) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	for _, domain := range s.Domains {
+		// Written as is in the server_name directive
+		check(domain != "" && !strings.ContainsAny(domain, " \t\n/:;{}'\""), "invalid domain %q", domain)
+	}
+
+	// Written as is in the location directive
+	check(!strings.ContainsAny(s.Location, ";{}"), "invalid location %q", s.Location)
+
+	hasUpstream := len(s.Upstream) > 0
+	for i, l := range s.Locations {
+		check(l.Match != "", "location %d has no match", i+1)
+		check(!strings.ContainsAny(l.Match, ";{}"), "invalid match %q for location %d", l.Match, i+1)
+		hasUpstream = hasUpstream || len(l.Upstream) > 0
+	}
+
+	for _, u := range upstreams(s) {
+		check(u.Address != "", "an upstream has no address")
+		if u.Address != "" {
+			errs = append(errs, validateAddress(u.Address))
+		}
+		errs = append(errs, u.HealthCheck.validate(u.Address))
+	}
+
+	check(!s.HttpsOnly || s.Ssl, "httpsOnly needs ssl")
+
+	switch {
+	case s.LetsEncryptDNSPlugin == "":
+	case slices.Contains(removedDNSPlugins, s.LetsEncryptDNSPlugin):
+		check(false, "the certbot %q DNS plugin was removed. Use the letsEncryptAuthenticator and letsEncryptCleaner hooks instead (see Migrating from certbot in the readme)", s.LetsEncryptDNSPlugin)
+	default:
+		check(slices.Contains(dnsPlugins, s.LetsEncryptDNSPlugin), "unknown letsEncryptDNSPlugin %q. Use one of %s", s.LetsEncryptDNSPlugin, strings.Join(dnsPlugins, ", "))
+	}
+
+	if s.AcmeDirectory != "" {
+		errs = append(errs, validateURL("acmeDirectory", s.AcmeDirectory))
+	}
+	check((s.AcmeEabKid == "") == (s.AcmeEabHmacKey == ""), "acmeEabKid and acmeEabHmacKey must be set together")
+	if s.AcmeEabHmacKey != "" {
+		_, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s.AcmeEabHmacKey, "="))
+		check(err == nil, "acmeEabHmacKey is not base64url encoded")
+	}
+
+	if s.Webhook != "" {
+		errs = append(errs, validateURL("webhook", s.Webhook))
+	}
+	check(s.WebhookSecret == "" || s.Webhook != "", "webhookSecret is set without a webhook")
+
+	switch strings.ToLower(s.Type) {
+	case "", "http":
+		check(len(s.Domains) > 0, "no domains")
+		check(hasUpstream, "no upstream")
+		if s.Ssl {
+			errs = append(errs, s.validateSsl())
+		}
+
+	case "tcp", "udp", "stream":
+		_, err := s.PortRanges()
+		errs = append(errs, err)
+		check(hasUpstream, "no upstream")
+		check(!s.AcceptProxyProtocol || s.StreamProtocol() != "udp", "the PROXY protocol cannot be accepted over UDP")
+
+	case "tls-passthrough":
+		check(len(s.Domains) > 0, "no domains")
+		check(hasUpstream, "no upstream")
+
+	case "certificate":
+		check(len(s.Domains) > 0, "no domains")
+		check(s.Certificate == "", "a certificate cannot use another certificate")
+		errs = append(errs, s.validateSsl())
+
+		// There is no http config to serve the HTTP-01 challenge from
+		if strings.ToLower(s.SslSource) == "letsencrypt" {
+			check(
+				s.LetsEncryptDNSPlugin != "" || (s.LetsEncryptAuthenticator != "" && s.LetsEncryptCleaner != ""),
+				"a letsencrypt certificate must use a DNS-01 challenge",
+			)
+		}
+
+	default:
+		errs = append(errs, fmt.Errorf("unknown type %q", s.Type))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s Service) validateSsl() error {
+	var errs []error
+
+	if s.Certificate != "" && !s.IsCertificate() {
+		return nil
+	}
+
+	switch s.SslSource {
+	case "manual":
+		if s.CertPath == "" || s.KeyPath == "" {
+			errs = append(errs, fmt.Errorf("certPath and keyPath are required for manual certificates"))
+		}
+	case "letsencrypt":
+		if (s.LetsEncryptAuthenticator == "") != (s.LetsEncryptCleaner == "") {
+			errs = append(errs, fmt.Errorf("letsEncryptAuthenticator and letsEncryptCleaner must be set together"))
+		}
+	case "selfsigned", "internal-ca":
+	case "":
+		errs = append(errs, fmt.Errorf("no sslSource"))
+	default:
+		errs = append(errs, fmt.Errorf("unknown sslSource %q", s.SslSource))
+	}
+
+	switch strings.ToLower(s.KeyType) {
+	case "", KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeRSA2048, KeyTypeRSA4096:
+	default:
+		errs = append(errs, fmt.Errorf("unknown keyType %q", s.KeyType))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h HealthCheck) validate(address string) error {
+	var errs []error
+
+	switch strings.ToLower(h.Type) {
+	case "", "tcp", "http", "https", "none":
+	default:
+		errs = append(errs, fmt.Errorf("unknown health check type %q for %q", h.Type, address))
+	}
+
+	if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+		errs = append(errs, fmt.Errorf("the health check path for %q must start with /", address))
+	}
+	if h.ExpectedStatus != 0 && (h.ExpectedStatus < 100 || h.ExpectedStatus > 599) {
+		errs = append(errs, fmt.Errorf("invalid health check expectedStatus %d for %q", h.ExpectedStatus, address))
+	}
+	if h.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("negative health check timeout for %q", address))
+	}
+	if h.Retries < 0 {
+		errs = append(errs, fmt.Errorf("negative health check retries for %q", address))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateAddress checks the address of an upstream server.
+// It is a host with an optional port, or a unix socket
+func validateAddress(address string) error {
+	if strings.HasPrefix(address, "unix:") {
+		return nil
+	}
+
+	if strings.ContainsAny(address, " \t\n/;{}") {
+		return fmt.Errorf("invalid upstream address %q", address)
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port, e.g. upstream.io or an IPv6 address
+		return nil
+	}
+
+	// nginx does not look up service names, so it must be a number
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 {
+		return fmt.Errorf("invalid port in upstream address %q", address)
+	}
+
+	return nil
+}
+
+func validateURL(key, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be a http or https URL, got %q", key, value)
+	}
+
+	return nil
+}
+
+// upstreams returns every upstream server in the service
+func upstreams(s Service) []UpstreamServer {
+	all := append([]UpstreamServer{}, s.Upstream...)
+	for _, l := range s.Locations {
+		all = append(all, l.Upstream...)
+	}
+
+	return all
+}
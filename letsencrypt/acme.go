@@ -0,0 +1,299 @@
+package letsencrypt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	productionDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+	stagingDirectory    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// DirectoryURL is the ACME server that the certificate of the config is requested from
+func DirectoryURL(settings internal.Settings, config internal.Config) string {
+	if config.AcmeDirectory != "" {
+		return config.AcmeDirectory
+	}
+
+	if settings.ACME_DIRECTORY != "" {
+		return settings.ACME_DIRECTORY
+	}
+
+	if settings.TESTING {
+		return stagingDirectory
+	}
+
+	return productionDirectory
+}
+
+// KeyType is the type of key that the certificate of the config is requested with
+func KeyType(settings internal.Settings, config internal.Config) string {
+	if config.KeyType != "" {
+		return strings.ToLower(config.KeyType)
+	}
+
+	return strings.ToLower(settings.ACME_KEY_TYPE)
+}
+
+// RateLimit reports whether the error is a rate limit error from the ACME server,
+// and how long the server asked to wait before trying again. It is 0 if it did not say
+func RateLimit(err error) (time.Duration, bool) {
+	var acmeErr *acme.Error
+	if !errors.As(err, &acmeErr) {
+		return 0, false
+	}
+
+	return acme.RateLimit(acmeErr)
+}
+
+// externalAccountBinding returns the EAB credentials for the directory of the config, if any
+func externalAccountBinding(settings internal.Settings, config internal.Config) (*acme.ExternalAccountBinding, error) {
+	kid, hmacKey := settings.ACME_EAB_KID, settings.ACME_EAB_HMAC_KEY
+	if config.AcmeDirectory != "" {
+		kid, hmacKey = config.AcmeEabKid, config.AcmeEabHmacKey
+	}
+
+	if kid == "" {
+		return nil, nil
+	}
+
+	// Some CAs give out the key with padding
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(hmacKey, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EAB HMAC key: %w", err)
+	}
+
+	return &acme.ExternalAccountBinding{KID: kid, Key: key}, nil
+}
+
+// getClient returns a client with a registered account for the ACME directory of the config
+func getClient(ctx context.Context, settings internal.Settings, config internal.Config) (*acme.Client, error) {
+	directory := DirectoryURL(settings, config)
+
+	eab, err := externalAccountBinding(settings, config)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := accountKey(settings.LETSENCRYPT_ACCOUNTS_DIR, directory)
+	if err != nil {
+		return nil, fmt.Errorf("could not get account key: %w", err)
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: directory,
+		UserAgent:    "warden",
+	}
+
+	account := &acme.Account{ExternalAccountBinding: eab}
+	if settings.EMAIL != "" {
+		account.Contact = []string{"mailto:" + settings.EMAIL}
+	}
+
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("could not register account: %w", err)
+	}
+
+	return client, nil
+}
+
+// accountKey loads the key of the account for the directory, creating it if needed.
+// The account is identified by its key, so there is one key per directory
+func accountKey(dir, directory string) (crypto.Signer, error) {
+	u, err := url.Parse(directory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory URL %q: %w", directory, err)
+	}
+
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(u.Host + u.Path)
+	path := filepath.Join(dir, name+".key")
+
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("no key found in %q", path)
+		}
+		return internal.ParsePrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read account key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate account key: %w", err)
+	}
+
+	encoded, err := internal.EncodePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode account key: %w", err)
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("could not create accounts directory: %w", err)
+	}
+
+	err = internal.WriteFileAtomic(path, encoded, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not save account key: %w", err)
+	}
+
+	return key, nil
+}
+
+type pendingChallenge struct {
+	authz     *acme.Authorization
+	challenge *acme.Challenge
+}
+
+// obtain gets a certificate for the domains, solving the challenges with the solver
+// The certificate is for the given key. It returns the DER encoded certificate chain
+func obtain(ctx context.Context, client *acme.Client, domains []string, key crypto.Signer, solver challengeSolver) ([][]byte, error) {
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, fmt.Errorf("could not create order: %w", err)
+	}
+
+	var pending []pendingChallenge
+	defer func() {
+		// The context may be done, but we still want to clean up
+		cleanupCtx := context.WithoutCancel(ctx)
+		for _, p := range pending {
+			err := solver.CleanUp(cleanupCtx, client, p.authz.Identifier.Value, p.challenge)
+			if err != nil {
+				slog.Warn("could not clean up challenge", "domain", p.authz.Identifier.Value, "error", err)
+			}
+		}
+	}()
+
+	// All challenges are presented before any is accepted. e.g. the same
+	// DNS record is used for example.com and *.example.com
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not get authorization: %w", err)
+		}
+
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == solver.Type() {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return nil, fmt.Errorf("no %s challenge for %q", solver.Type(), authz.Identifier.Value)
+		}
+
+		err = solver.Present(ctx, client, authz.Identifier.Value, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("could not present challenge for %q: %w", authz.Identifier.Value, err)
+		}
+
+		pending = append(pending, pendingChallenge{authz: authz, challenge: challenge})
+	}
+
+	for _, p := range pending {
+		err = solver.Ready(ctx, client, p.authz.Identifier.Value, p.challenge)
+		if err != nil {
+			return nil, fmt.Errorf("challenge for %q is not ready: %w", p.authz.Identifier.Value, err)
+		}
+	}
+
+	for _, p := range pending {
+		_, err = client.Accept(ctx, p.challenge)
+		if err != nil {
+			return nil, fmt.Errorf("could not accept challenge for %q: %w", p.authz.Identifier.Value, err)
+		}
+	}
+
+	for _, p := range pending {
+		_, err = client.WaitAuthorization(ctx, p.authz.URI)
+		if err != nil {
+			return nil, fmt.Errorf("authorization failed for %q: %w", p.authz.Identifier.Value, err)
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("order failed: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create CSR: %w", err)
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not finalize order: %w", err)
+	}
+
+	return chain, nil
+}
+
+// saveCertificate writes the chain and key in the same layout as certbot
+// along with the ACME directory it was issued by
+func saveCertificate(dir string, chain [][]byte, key crypto.Signer, directory string) error {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("could not create certificate directory: %w", err)
+	}
+
+	var certs []byte
+	for _, der := range chain {
+		certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	encoded, err := internal.EncodePrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("could not encode certificate key: %w", err)
+	}
+
+	err = internal.WriteFileAtomic(filepath.Join(dir, "privkey.pem"), encoded, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = internal.WriteFileAtomic(filepath.Join(dir, "fullchain.pem"), certs, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return internal.WriteFileAtomic(filepath.Join(dir, directoryFile), []byte(directory+"\n"), 0o644)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,422 @@
+package letsencrypt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+	"golang.org/x/crypto/acme"
+)
+
+// fakeACME is an ACME server that validates HTTP-01 challenges
+// by reading the response from the webroot instead of over HTTP
+type fakeACME struct {
+	t        *testing.T
+	srv      *httptest.Server
+	webroot  string
+	thumb    func() string
+	ca       *x509.Certificate
+	caKey    crypto.Signer
+	mu       sync.Mutex
+	orders   int
+	nonce    int
+	authz    map[string]string // status by domain
+	tokens   map[string]string // token by domain
+	csrs     map[int]*x509.CertificateRequest
+	verified []string // the challenge files that were read
+}
+
+func newFakeACME(t *testing.T, webroot string) *fakeACME {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeACME{
+		t:       t,
+		webroot: webroot,
+		ca:      ca,
+		caKey:   caKey,
+		authz:   map[string]string{},
+		tokens:  map[string]string{},
+		csrs:    map[int]*x509.CertificateRequest{},
+	}
+	f.srv = httptest.NewServer(f)
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+func (f *fakeACME) directory() string {
+	return f.srv.URL + "/directory"
+}
+
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nonce++
+	w.Header().Set("Replay-Nonce", "nonce"+strconv.Itoa(f.nonce))
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodHead || r.URL.Path == "/nonce" {
+		return
+	}
+
+	if r.URL.Path == "/directory" {
+		f.json(w, http.StatusOK, map[string]string{
+			"newNonce":   f.srv.URL + "/nonce",
+			"newAccount": f.srv.URL + "/account",
+			"newOrder":   f.srv.URL + "/order",
+			"revokeCert": f.srv.URL + "/revoke",
+			"keyChange":  f.srv.URL + "/key-change",
+		})
+		return
+	}
+
+	payload, err := jwsPayload(r)
+	if err != nil {
+		f.t.Errorf("invalid JWS to %s: %v", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch kind {
+	case "account":
+		w.Header().Set("Location", f.srv.URL+"/account/1")
+		f.json(w, http.StatusCreated, map[string]string{"status": "valid"})
+
+	case "order":
+		if id != "" {
+			n, _ := strconv.Atoi(id)
+			f.json(w, http.StatusOK, f.order(n))
+			return
+		}
+
+		var req struct {
+			Identifiers []acme.AuthzID `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+
+		// A new order needs new challenges
+		f.orders++
+		f.authz = map[string]string{}
+		f.tokens = map[string]string{}
+		for _, ident := range req.Identifiers {
+			f.authz[ident.Value] = acme.StatusPending
+			f.tokens[ident.Value] = fmt.Sprintf("token%d-%s", f.orders, strings.ReplaceAll(ident.Value, ".", "_"))
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", f.srv.URL, f.orders))
+		f.json(w, http.StatusCreated, f.order(f.orders))
+
+	case "authz":
+		f.json(w, http.StatusOK, map[string]any{
+			"status":     f.authz[id],
+			"identifier": map[string]string{"type": "dns", "value": id},
+			"challenges": []map[string]string{
+				{"type": "dns-01", "url": f.srv.URL + "/challenge/dns/" + id, "token": "dns", "status": "pending"},
+				{"type": "http-01", "url": f.srv.URL + "/challenge/" + id, "token": f.tokens[id], "status": f.authz[id]},
+			},
+		})
+
+	case "challenge":
+		token := f.tokens[id]
+		path := filepath.Join(f.webroot, ".well-known", "acme-challenge", token)
+		f.verified = append(f.verified, path)
+
+		content, err := os.ReadFile(path)
+		if err != nil || string(content) != token+"."+f.thumb() {
+			f.t.Errorf("got challenge response %q (%v), want %q", content, err, token+"."+f.thumb())
+			f.authz[id] = acme.StatusInvalid
+		} else {
+			f.authz[id] = acme.StatusValid
+		}
+
+		f.json(w, http.StatusOK, map[string]string{
+			"type": "http-01", "url": f.srv.URL + r.URL.Path, "token": token, "status": f.authz[id],
+		})
+
+	case "finalize":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &req)
+
+		der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+		if err != nil {
+			f.t.Errorf("invalid CSR: %v", err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			f.t.Errorf("invalid CSR: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		n, _ := strconv.Atoi(id)
+		f.csrs[n] = csr
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", f.srv.URL, n))
+		f.json(w, http.StatusOK, f.order(n))
+
+	case "cert":
+		n, _ := strconv.Atoi(id)
+		csr := f.csrs[n]
+
+		leaf, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(int64(n + 1)),
+			Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}, f.ca, csr.PublicKey, f.caKey)
+		if err != nil {
+			f.t.Errorf("could not issue certificate: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: leaf})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: f.ca.Raw})
+
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeACME) order(n int) map[string]any {
+	status := acme.StatusReady
+	var authzURLs []string
+	var identifiers []acme.AuthzID
+	for _, domain := range slices.Sorted(maps.Keys(f.authz)) {
+		authzURLs = append(authzURLs, f.srv.URL+"/authz/"+domain)
+		identifiers = append(identifiers, acme.AuthzID{Type: "dns", Value: domain})
+		if f.authz[domain] != acme.StatusValid {
+			status = acme.StatusPending
+		}
+	}
+
+	order := map[string]any{
+		"status":         status,
+		"identifiers":    identifiers,
+		"authorizations": authzURLs,
+		"finalize":       fmt.Sprintf("%s/finalize/%d", f.srv.URL, n),
+	}
+	if f.csrs[n] != nil {
+		order["status"] = acme.StatusValid
+		order["certificate"] = fmt.Sprintf("%s/cert/%d", f.srv.URL, n)
+	}
+
+	return order
+}
+
+func (f *fakeACME) json(w http.ResponseWriter, status int, v any) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// jwsPayload returns the payload of a flattened JWS without verifying it
+func jwsPayload(r *http.Request) ([]byte, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, fmt.Errorf("got Content-Type %q", ct)
+	}
+
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &jws)
+	if err != nil {
+		return nil, err
+	}
+	if jws.Protected == "" || jws.Signature == "" {
+		return nil, fmt.Errorf("the JWS is not signed")
+	}
+
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+func TestGetSolver(t *testing.T) {
+	solver, err := getSolver(context.Background(), internal.Settings{}, internal.Config{Unique: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := webrootSolver{Root: "/docker/challenge/svc"}
+	if solver != want {
+		t.Errorf("got solver %+v, want %+v", solver, want)
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	oldRoot := challengeRoot
+	challengeRoot = filepath.Join(dir, "challenge")
+	t.Cleanup(func() { challengeRoot = oldRoot })
+
+	server := newFakeACME(t, filepath.Join(challengeRoot, "svc"))
+
+	settings := internal.Settings{
+		EMAIL:                    "admin@example.com",
+		ACME_DIRECTORY:           server.directory(),
+		ACME_KEY_TYPE:            internal.KeyTypeECDSAP256,
+		CERT_RENEWAL_WINDOW:      30 * 24 * time.Hour,
+		LETSENCRYPT_CERTS_DIR:    filepath.Join(dir, "certs"),
+		LETSENCRYPT_ACCOUNTS_DIR: filepath.Join(dir, "accounts"),
+	}
+	config := internal.Config{
+		Unique: "svc",
+		Service: internal.Service{
+			Domains: []string{"a.example.com", "b.example.com"},
+		},
+	}
+
+	server.thumb = func() string {
+		key, err := accountKey(settings.LETSENCRYPT_ACCOUNTS_DIR, server.directory())
+		if err != nil {
+			t.Fatal(err)
+		}
+		thumb, err := acme.JWKThumbprint(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return thumb
+	}
+
+	ctx := context.Background()
+	certDir := filepath.Join(settings.LETSENCRYPT_CERTS_DIR, "a.example.com")
+
+	// get checks the certificate and returns its serial number
+	get := func(t *testing.T, renew bool, wantOrders int) int64 {
+		t.Helper()
+
+		certPath, keyPath, err := GetCertificate(ctx, settings, "", config, renew)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certPath != filepath.Join(certDir, "fullchain.pem") || keyPath != filepath.Join(certDir, "privkey.pem") {
+			t.Errorf("got paths %q and %q", certPath, keyPath)
+		}
+		if server.orders != wantOrders {
+			t.Errorf("got %d orders, want %d", server.orders, wantOrders)
+		}
+
+		info, err := internal.ReadCertificateInfo(certPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(info.SANs, config.Domains) {
+			t.Errorf("got SANs %v, want %v", info.SANs, config.Domains)
+		}
+		if info.KeyType != KeyType(settings, config) {
+			t.Errorf("got key type %q, want %q", info.KeyType, KeyType(settings, config))
+		}
+
+		issuedBy, err := os.ReadFile(filepath.Join(certDir, directoryFile))
+		if err != nil || string(issuedBy) != server.directory()+"\n" {
+			t.Errorf("got directory %q (%v)", issuedBy, err)
+		}
+
+		raw, _ := os.ReadFile(certPath)
+		block, _ := pem.Decode(raw)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.SerialNumber.Int64()
+	}
+
+	t.Run("obtain", func(t *testing.T) {
+		get(t, false, 1)
+
+		// The responses are read from /docker/challenge/<Unique> and removed after
+		want := []string{
+			filepath.Join(challengeRoot, "svc", ".well-known/acme-challenge/token1-a_example_com"),
+			filepath.Join(challengeRoot, "svc", ".well-known/acme-challenge/token1-b_example_com"),
+		}
+		if !slices.Equal(server.verified, want) {
+			t.Errorf("got challenge files\n%q\nwant\n%q", server.verified, want)
+		}
+		for _, path := range want {
+			if fileExists(path) {
+				t.Errorf("challenge file %q was not removed", path)
+			}
+		}
+	})
+
+	first := get(t, false, 1)
+
+	t.Run("valid certificate is kept", func(t *testing.T) {
+		if serial := get(t, false, 1); serial != first {
+			t.Errorf("got serial %d, want %d", serial, first)
+		}
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		if serial := get(t, true, 2); serial == first {
+			t.Errorf("the certificate was not renewed")
+		}
+	})
+
+	t.Run("renewal window", func(t *testing.T) {
+		settings.CERT_RENEWAL_WINDOW = 100 * 24 * time.Hour
+		defer func() { settings.CERT_RENEWAL_WINDOW = 30 * 24 * time.Hour }()
+		get(t, false, 3)
+	})
+
+	t.Run("key type changed", func(t *testing.T) {
+		config.KeyType = internal.KeyTypeECDSAP384
+		get(t, false, 4)
+		get(t, false, 4)
+	})
+}
@@ -0,0 +1,63 @@
+package letsencrypt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+)
+
+// challengeSolver makes the response to an ACME challenge available
+type challengeSolver interface {
+	// Type is the ACME challenge type that is solved. e.g. http-01
+	Type() string
+	Present(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error
+	CleanUp(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error
+	// Ready waits until the presented challenge can be validated
+	Ready(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error
+}
+
+// webrootSolver solves HTTP-01 challenges by writing the response
+// to a directory that nginx serves /.well-known/acme-challenge from
+type webrootSolver struct {
+	Root string
+}
+
+func (webrootSolver) Type() string {
+	return "http-01"
+}
+
+func (w webrootSolver) Present(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("could not get challenge response: %w", err)
+	}
+
+	path := filepath.Join(w.Root, client.HTTP01ChallengePath(challenge.Token))
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("Can't make letsencrypt webroot dir: %w", err)
+	}
+
+	return os.WriteFile(path, []byte(response), 0o644)
+}
+
+// The file is served as soon as it is written
+func (webrootSolver) Ready(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	return nil
+}
+
+func (w webrootSolver) CleanUp(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	path := filepath.Join(w.Root, client.HTTP01ChallengePath(challenge.Token))
+
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/sethvargo/go-envconfig"
+	"github.com/stephenafamo/kronika"
+	"github.com/stephenafamo/warden/internal"
+	"golang.org/x/crypto/acme"
+)
+
+// TTL of the challenge records
+const dnsTTL = 120
+
+// How often to check if a challenge record has propagated
+const dnsPollInterval = 5 * time.Second
+
+// DNSProvider creates and removes the TXT record for a DNS-01 challenge.
+// domain is the domain being validated, without any wildcard
+// and value is the content of the TXT record at _acme-challenge.<domain>
+type DNSProvider interface {
+	Present(ctx context.Context, domain, value string) error
+	CleanUp(ctx context.Context, domain, value string) error
+	// Timeout is how long to wait for the record to propagate
+	// and how often to check. A zero timeout means there is no need to wait
+	Timeout() (timeout, interval time.Duration)
+}
+
+// getDNSProvider returns the provider for LetsEncryptDNSPlugin.
+// The credentials of each provider are read from the environment
+// or from a "<name>.env" file in LETSENCRYPT_CREDS_DIR
+func getDNSProvider(ctx context.Context, settings internal.Settings, name string) (DNSProvider, error) {
+	propagation := time.Duration(settings.LETSENCRYPT_DNS_PROPAGATION) * time.Second
+
+	switch name {
+	case "vultr":
+		return newVultrProvider(ctx, settings, propagation)
+	case "cloudflare":
+		return newCloudflareProvider(ctx, settings, propagation)
+	case "digitalocean":
+		return newDigitalOceanProvider(ctx, settings, propagation)
+	case "rfc2136":
+		return newRFC2136Provider(ctx, settings, propagation)
+	case "route53":
+		return newRoute53Provider(ctx, settings, propagation)
+	case "dnsimple", "google", "linode", "ovh":
+		return nil, fmt.Errorf("the certbot %q DNS plugin was removed. Use the letsEncryptAuthenticator and letsEncryptCleaner hooks instead (see Migrating from certbot in the readme)", name)
+	default:
+		return nil, fmt.Errorf("unsupported DNS plugin %q", name)
+	}
+}
+
+// loadCredentials fills target with the credentials of the named provider
+func loadCredentials(ctx context.Context, settings internal.Settings, name string, target any) error {
+	lookupers := []envconfig.Lookuper{envconfig.OsLookuper()}
+
+	path := filepath.Join(settings.LETSENCRYPT_CREDS_DIR, name+".env")
+	creds, err := godotenv.Read(path)
+	switch {
+	case err == nil:
+		lookupers = append(lookupers, envconfig.MapLookuper(creds))
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("could not read credentials file %q: %w", path, err)
+	}
+
+	err = envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   target,
+		Lookuper: envconfig.MultiLookuper(lookupers...),
+	})
+	if err != nil {
+		return fmt.Errorf("invalid %s credentials: %w", name, err)
+	}
+
+	return nil
+}
+
+// dnsSolver solves DNS-01 challenges with a DNSProvider
+type dnsSolver struct {
+	Provider DNSProvider
+}
+
+func (dnsSolver) Type() string {
+	return "dns-01"
+}
+
+func (d dnsSolver) Present(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("could not get challenge record: %w", err)
+	}
+
+	return d.Provider.Present(ctx, domain, value)
+}
+
+func (d dnsSolver) CleanUp(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("could not get challenge record: %w", err)
+	}
+
+	return d.Provider.CleanUp(ctx, domain, value)
+}
+
+// Ready waits until the record can be resolved or the provider's timeout is reached.
+// If the record is still not seen, the ACME server is left to decide
+// since it may be looking at different nameservers
+func (d dnsSolver) Ready(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	timeout, interval := d.Provider.Timeout()
+	if timeout <= 0 {
+		return nil
+	}
+	if interval <= 0 {
+		interval = dnsPollInterval
+	}
+
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("could not get challenge record: %w", err)
+	}
+
+	fqdn := challengeFQDN(domain)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for range kronika.Every(waitCtx, time.Now(), interval) {
+		records, _ := net.DefaultResolver.LookupTXT(waitCtx, fqdn)
+		if slices.Contains(records, value) {
+			return nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	slog.Warn("record has not propagated, continuing", "fqdn", fqdn, "timeout", timeout)
+	return nil
+}
+
+// hookProvider runs executables to create and remove the DNS records.
+// They get the same environment variables as certbot's manual hooks
+// so existing hooks keep working. The auth hook should wait
+// for the record to propagate before exiting
+type hookProvider struct {
+	Auth    string
+	Cleanup string
+}
+
+func (h hookProvider) Present(ctx context.Context, domain, value string) error {
+	return runHook(ctx, h.Auth, domain, value)
+}
+
+func (h hookProvider) CleanUp(ctx context.Context, domain, value string) error {
+	return runHook(ctx, h.Cleanup, domain, value)
+}
+
+// The auth hook already waits for propagation
+func (hookProvider) Timeout() (time.Duration, time.Duration) {
+	return 0, 0
+}
+
+func runHook(ctx context.Context, hook, domain, value string) error {
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Env = append(
+		os.Environ(),
+		"CERTBOT_DOMAIN="+domain,
+		"CERTBOT_VALIDATION="+value,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("hook %q failed: %w: %s", hook, err, output)
+	}
+
+	return nil
+}
+
+// challengeFQDN is the name of the TXT record for the domain, without the trailing dot
+func challengeFQDN(domain string) string {
+	return "_acme-challenge." + strings.TrimSuffix(domain, ".")
+}
+
+// zoneCandidates lists the domain and its parents that could be the zone it is in.
+// e.g. a.b.example.com -> a.b.example.com, b.example.com, example.com
+func zoneCandidates(domain string) []string {
+	labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+
+	var candidates []string
+	for i := 0; i < len(labels)-1; i++ {
+		candidates = append(candidates, strings.Join(labels[i:], "."))
+	}
+
+	return candidates
+}
+
+// relativeName is the name of the fqdn relative to the zone. e.g. _acme-challenge.www
+func relativeName(fqdn, zone string) string {
+	name := strings.TrimSuffix(fqdn, "."+zone)
+	if name == fqdn || name == zone {
+		return "@"
+	}
+	return name
+}
+
+// apiError is returned when a provider API responds with an unexpected status
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// doJSON sends a request with a bearer token to a JSON API.
+// If out is not nil, a successful response is decoded into it
+func doJSON(ctx context.Context, httpClient *http.Client, method, url, token string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return apiError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(raw))}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package letsencrypt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+const cloudflareBaseURL = "https://api.cloudflare.com/client/v4"
+
+type cloudflareCredentials struct {
+	// Needs the Zone:Read and DNS:Edit permissions
+	CLOUDFLARE_API_TOKEN string `env:"CLOUDFLARE_API_TOKEN,required"`
+}
+
+// cloudflareProvider manages the records with the Cloudflare API
+type cloudflareProvider struct {
+	BaseURL     string
+	Token       string
+	HTTPClient  *http.Client
+	Propagation time.Duration
+}
+
+func newCloudflareProvider(ctx context.Context, settings internal.Settings, propagation time.Duration) (*cloudflareProvider, error) {
+	creds := cloudflareCredentials{}
+	err := loadCredentials(ctx, settings, "cloudflare", &creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudflareProvider{
+		BaseURL:     cloudflareBaseURL,
+		Token:       creds.CLOUDFLARE_API_TOKEN,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+		Propagation: propagation,
+	}, nil
+}
+
+// Every response from the Cloudflare API is wrapped in this
+type cloudflareResponse[T any] struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result T `json:"result"`
+}
+
+func (r cloudflareResponse[T]) err() error {
+	if r.Success {
+		return nil
+	}
+
+	messages := make([]string, len(r.Errors))
+	for i, e := range r.Errors {
+		messages[i] = fmt.Sprintf("%d: %s", e.Code, e.Message)
+	}
+
+	return fmt.Errorf("cloudflare error: %s", strings.Join(messages, ", "))
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+func (c *cloudflareProvider) request(ctx context.Context, method, path string, in any, out interface{ err() error }) error {
+	err := doJSON(ctx, c.HTTPClient, method, c.BaseURL+path, c.Token, in, out)
+	if err != nil {
+		return err
+	}
+
+	return out.err()
+}
+
+// zoneID finds the zone that the domain is in
+func (c *cloudflareProvider) zoneID(ctx context.Context, domain string) (string, error) {
+	for _, candidate := range zoneCandidates(domain) {
+		var resp cloudflareResponse[[]struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}]
+
+		err := c.request(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(candidate), nil, &resp)
+		if err != nil {
+			return "", fmt.Errorf("could not get cloudflare zone %q: %w", candidate, err)
+		}
+
+		for _, zone := range resp.Result {
+			if zone.Name == candidate {
+				return zone.ID, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no cloudflare zone found for %q", domain)
+}
+
+func (c *cloudflareProvider) Present(ctx context.Context, domain, value string) error {
+	zoneID, err := c.zoneID(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	var resp cloudflareResponse[cloudflareRecord]
+	err = c.request(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", cloudflareRecord{
+		Type:    "TXT",
+		Name:    challengeFQDN(domain),
+		Content: value,
+		TTL:     dnsTTL,
+	}, &resp)
+	if err != nil {
+		return fmt.Errorf("could not create cloudflare DNS record: %w", err)
+	}
+
+	return nil
+}
+
+func (c *cloudflareProvider) CleanUp(ctx context.Context, domain, value string) error {
+	zoneID, err := c.zoneID(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("type", "TXT")
+	query.Set("name", challengeFQDN(domain))
+	query.Set("per_page", "100")
+
+	var resp cloudflareResponse[[]cloudflareRecord]
+	err = c.request(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, &resp)
+	if err != nil {
+		return fmt.Errorf("could not list cloudflare DNS records: %w", err)
+	}
+
+	for _, record := range resp.Result {
+		// The same name is used for a domain and its wildcard
+		if strings.Trim(record.Content, `"`) != value {
+			continue
+		}
+
+		var deleted cloudflareResponse[struct{}]
+		err = c.request(ctx, http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+record.ID, nil, &deleted)
+		if err != nil {
+			return fmt.Errorf("could not delete cloudflare DNS record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *cloudflareProvider) Timeout() (time.Duration, time.Duration) {
+	return c.Propagation, dnsPollInterval
+}
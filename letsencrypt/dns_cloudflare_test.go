@@ -0,0 +1,110 @@
+package letsencrypt
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCloudflareProvider(t *testing.T) {
+	var requests []string
+	var created []map[string]any
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("got Authorization %q", auth)
+		}
+		requests = append(requests, r.Method+" "+r.URL.String())
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			if r.URL.Query().Get("name") != "example.com" {
+				w.Write([]byte(`{"success": true, "result": []}`))
+				return
+			}
+			w.Write([]byte(`{"success": true, "result": [{"id": "z1", "name": "example.com"}]}`))
+
+		case r.Method == http.MethodPost && r.URL.Path == "/zones/z1/dns_records":
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			var record map[string]any
+			err := json.Unmarshal(body, &record)
+			if err != nil {
+				t.Errorf("invalid body %s: %v", body, err)
+			}
+			created = append(created, record)
+			w.Write([]byte(`{"success": true, "result": {"id": "r1"}}`))
+
+		case r.Method == http.MethodGet && r.URL.Path == "/zones/z1/dns_records":
+			w.Write([]byte(`{"success": true, "result": [
+				{"id": "r1", "type": "TXT", "name": "_acme-challenge.www.example.com", "content": "\"value\""},
+				{"id": "r2", "type": "TXT", "name": "_acme-challenge.www.example.com", "content": "other"}
+			]}`))
+
+		case r.Method == http.MethodDelete:
+			w.Write([]byte(`{"success": true, "result": {"id": "r1"}}`))
+
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := &cloudflareProvider{BaseURL: srv.URL, Token: "token", HTTPClient: srv.Client()}
+	ctx := context.Background()
+
+	err := p.Present(ctx, "www.example.com", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCreated := []map[string]any{{
+		"type":    "TXT",
+		"name":    "_acme-challenge.www.example.com",
+		"content": "value",
+		"ttl":     float64(dnsTTL),
+	}}
+	if !reflect.DeepEqual(created, wantCreated) {
+		t.Errorf("got records %v, want %v", created, wantCreated)
+	}
+
+	requests = nil
+	err = p.CleanUp(ctx, "www.example.com", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the record with the value is deleted
+	wantRequests := []string{
+		"GET /zones?name=www.example.com",
+		"GET /zones?name=example.com",
+		"GET /zones/z1/dns_records?name=_acme-challenge.www.example.com&per_page=100&type=TXT",
+		"DELETE /zones/z1/dns_records/r1",
+	}
+	if !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("got requests\n%q\nwant\n%q", requests, wantRequests)
+	}
+}
+
+func TestCloudflareProviderErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": false, "errors": [{"code": 9109, "message": "Invalid access token"}]}`))
+	}))
+	defer srv.Close()
+
+	p := &cloudflareProvider{BaseURL: srv.URL, Token: "token", HTTPClient: srv.Client()}
+
+	err := p.Present(context.Background(), "example.com", "value")
+	want := `could not get cloudflare zone "example.com": cloudflare error: 9109: Invalid access token`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
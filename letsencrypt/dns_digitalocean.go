@@ -0,0 +1,131 @@
+package letsencrypt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+const digitalOceanBaseURL = "https://api.digitalocean.com/v2"
+
+type digitalOceanCredentials struct {
+	DO_AUTH_TOKEN string `env:"DO_AUTH_TOKEN,required"`
+}
+
+// digitalOceanProvider manages the records with the DigitalOcean API
+type digitalOceanProvider struct {
+	BaseURL     string
+	Token       string
+	HTTPClient  *http.Client
+	Propagation time.Duration
+}
+
+func newDigitalOceanProvider(ctx context.Context, settings internal.Settings, propagation time.Duration) (*digitalOceanProvider, error) {
+	creds := digitalOceanCredentials{}
+	err := loadCredentials(ctx, settings, "digitalocean", &creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &digitalOceanProvider{
+		BaseURL:     digitalOceanBaseURL,
+		Token:       creds.DO_AUTH_TOKEN,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+		Propagation: propagation,
+	}, nil
+}
+
+type digitalOceanRecord struct {
+	ID   int64  `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl"`
+}
+
+func (d *digitalOceanProvider) request(ctx context.Context, method, path string, in, out any) error {
+	return doJSON(ctx, d.HTTPClient, method, d.BaseURL+path, d.Token, in, out)
+}
+
+// zone finds the domain registered with DigitalOcean that the domain is in
+func (d *digitalOceanProvider) zone(ctx context.Context, domain string) (string, error) {
+	for _, candidate := range zoneCandidates(domain) {
+		err := d.request(ctx, http.MethodGet, "/domains/"+url.PathEscape(candidate), nil, nil)
+
+		var apiErr apiError
+		switch {
+		case err == nil:
+			return candidate, nil
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			continue
+		default:
+			return "", fmt.Errorf("could not get digitalocean domain %q: %w", candidate, err)
+		}
+	}
+
+	return "", fmt.Errorf("no digitalocean domain found for %q", domain)
+}
+
+func (d *digitalOceanProvider) Present(ctx context.Context, domain, value string) error {
+	zone, err := d.zone(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	err = d.request(ctx, http.MethodPost, "/domains/"+url.PathEscape(zone)+"/records", digitalOceanRecord{
+		Type: "TXT",
+		Name: relativeName(challengeFQDN(domain), zone),
+		Data: value,
+		TTL:  dnsTTL,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("could not create digitalocean DNS record: %w", err)
+	}
+
+	return nil
+}
+
+func (d *digitalOceanProvider) CleanUp(ctx context.Context, domain, value string) error {
+	zone, err := d.zone(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("type", "TXT")
+	query.Set("name", challengeFQDN(domain))
+	query.Set("per_page", "200")
+
+	var resp struct {
+		DomainRecords []digitalOceanRecord `json:"domain_records"`
+	}
+	err = d.request(ctx, http.MethodGet, "/domains/"+url.PathEscape(zone)+"/records?"+query.Encode(), nil, &resp)
+	if err != nil {
+		return fmt.Errorf("could not list digitalocean DNS records: %w", err)
+	}
+
+	for _, record := range resp.DomainRecords {
+		// The same name is used for a domain and its wildcard
+		if record.Data != value {
+			continue
+		}
+
+		path := "/domains/" + url.PathEscape(zone) + "/records/" + strconv.FormatInt(record.ID, 10)
+		err = d.request(ctx, http.MethodDelete, path, nil, nil)
+		if err != nil {
+			return fmt.Errorf("could not delete digitalocean DNS record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (d *digitalOceanProvider) Timeout() (time.Duration, time.Duration) {
+	return d.Propagation, dnsPollInterval
+}
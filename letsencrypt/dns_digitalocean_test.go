@@ -0,0 +1,111 @@
+package letsencrypt
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDigitalOceanProvider(t *testing.T) {
+	var requests []string
+	var created []map[string]any
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("got Authorization %q", auth)
+		}
+		requests = append(requests, r.Method+" "+r.URL.String())
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/domains/www.example.com":
+			http.Error(w, `{"id": "not_found"}`, http.StatusNotFound)
+
+		case r.Method == http.MethodGet && r.URL.Path == "/domains/example.com":
+			w.Write([]byte(`{"domain": {"name": "example.com"}}`))
+
+		case r.Method == http.MethodPost && r.URL.Path == "/domains/example.com/records":
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			var record map[string]any
+			err := json.Unmarshal(body, &record)
+			if err != nil {
+				t.Errorf("invalid body %s: %v", body, err)
+			}
+			created = append(created, record)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"domain_record": {"id": 1}}`))
+
+		case r.Method == http.MethodGet && r.URL.Path == "/domains/example.com/records":
+			w.Write([]byte(`{"domain_records": [
+				{"id": 1, "type": "TXT", "name": "_acme-challenge.www", "data": "value"},
+				{"id": 2, "type": "TXT", "name": "_acme-challenge.www", "data": "other"}
+			]}`))
+
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := &digitalOceanProvider{BaseURL: srv.URL, Token: "token", HTTPClient: srv.Client()}
+	ctx := context.Background()
+
+	err := p.Present(ctx, "www.example.com", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The name is relative to the domain
+	wantCreated := []map[string]any{{
+		"type": "TXT",
+		"name": "_acme-challenge.www",
+		"data": "value",
+		"ttl":  float64(dnsTTL),
+	}}
+	if !reflect.DeepEqual(created, wantCreated) {
+		t.Errorf("got records %v, want %v", created, wantCreated)
+	}
+
+	requests = nil
+	err = p.CleanUp(ctx, "www.example.com", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the record with the value is deleted
+	wantRequests := []string{
+		"GET /domains/www.example.com",
+		"GET /domains/example.com",
+		"GET /domains/example.com/records?name=_acme-challenge.www.example.com&per_page=200&type=TXT",
+		"DELETE /domains/example.com/records/1",
+	}
+	if !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("got requests\n%q\nwant\n%q", requests, wantRequests)
+	}
+}
+
+func TestDigitalOceanProviderErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"id": "unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	p := &digitalOceanProvider{BaseURL: srv.URL, Token: "token", HTTPClient: srv.Client()}
+
+	err := p.Present(context.Background(), "example.com", "value")
+	want := `could not get digitalocean domain "example.com": unexpected status 401: {"id": "unauthorized"}`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,345 @@
+package letsencrypt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bobesa/go-domain-util/domainutil"
+	"github.com/stephenafamo/warden/internal"
+)
+
+type rfc2136Credentials struct {
+	RFC2136_NAMESERVER string `env:"RFC2136_NAMESERVER,required"` // host:port. Default port is 53
+	RFC2136_ZONE       string `env:"RFC2136_ZONE"`                // Default is the registered domain
+	RFC2136_NETWORK    string `env:"RFC2136_NETWORK,default=udp"` // udp or tcp
+
+	RFC2136_TSIG_KEY       string `env:"RFC2136_TSIG_KEY"`
+	RFC2136_TSIG_SECRET    string `env:"RFC2136_TSIG_SECRET"` // base64
+	RFC2136_TSIG_ALGORITHM string `env:"RFC2136_TSIG_ALGORITHM,default=hmac-sha256."`
+}
+
+// rfc2136Provider manages the records with dynamic DNS updates (RFC 2136)
+// signed with TSIG (RFC 8945) if a key is given
+type rfc2136Provider struct {
+	Nameserver string
+	Network    string
+	Zone       string
+
+	TSIGKey       string
+	TSIGSecret    []byte
+	TSIGAlgorithm string
+
+	Propagation time.Duration
+}
+
+func newRFC2136Provider(ctx context.Context, settings internal.Settings, propagation time.Duration) (*rfc2136Provider, error) {
+	creds := rfc2136Credentials{}
+	err := loadCredentials(ctx, settings, "rfc2136", &creds)
+	if err != nil {
+		return nil, err
+	}
+
+	nameserver := creds.RFC2136_NAMESERVER
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	if creds.RFC2136_NETWORK != "udp" && creds.RFC2136_NETWORK != "tcp" {
+		return nil, fmt.Errorf("invalid RFC2136_NETWORK %q", creds.RFC2136_NETWORK)
+	}
+
+	p := &rfc2136Provider{
+		Nameserver:  nameserver,
+		Network:     creds.RFC2136_NETWORK,
+		Zone:        creds.RFC2136_ZONE,
+		Propagation: propagation,
+	}
+
+	if creds.RFC2136_TSIG_KEY != "" {
+		secret, err := base64.StdEncoding.DecodeString(creds.RFC2136_TSIG_SECRET)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RFC2136_TSIG_SECRET: %w", err)
+		}
+
+		algorithm := strings.ToLower(creds.RFC2136_TSIG_ALGORITHM)
+		if !strings.HasSuffix(algorithm, ".") {
+			algorithm += "."
+		}
+		if tsigHash(algorithm) == nil {
+			return nil, fmt.Errorf("unsupported RFC2136_TSIG_ALGORITHM %q", creds.RFC2136_TSIG_ALGORITHM)
+		}
+
+		p.TSIGKey = creds.RFC2136_TSIG_KEY
+		p.TSIGSecret = secret
+		p.TSIGAlgorithm = algorithm
+	}
+
+	return p, nil
+}
+
+// DNS constants used in update messages
+const (
+	dnsOpcodeUpdate = 5
+	dnsTypeSOA      = 6
+	dnsTypeTXT      = 16
+	dnsTypeTSIG     = 250
+	dnsClassIN      = 1
+	dnsClassNONE    = 254
+	dnsClassANY     = 255
+	tsigFudge       = 300
+)
+
+var dnsRcodes = map[int]string{
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+func tsigHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "hmac-sha1.":
+		return sha1.New
+	case "hmac-sha256.":
+		return sha256.New
+	case "hmac-sha512.":
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+func (r *rfc2136Provider) Present(ctx context.Context, domain, value string) error {
+	return r.update(ctx, domain, value, dnsClassIN, dnsTTL)
+}
+
+// CleanUp deletes only the record with the value, since the
+// same name is used for a domain and its wildcard
+func (r *rfc2136Provider) CleanUp(ctx context.Context, domain, value string) error {
+	return r.update(ctx, domain, value, dnsClassNONE, 0)
+}
+
+func (r *rfc2136Provider) Timeout() (time.Duration, time.Duration) {
+	return r.Propagation, dnsPollInterval
+}
+
+func (r *rfc2136Provider) update(ctx context.Context, domain, value string, class uint16, ttl uint32) error {
+	zone := r.Zone
+	if zone == "" {
+		zone = domainutil.Domain(domain)
+	}
+
+	msg, id, err := r.updateMessage(zone, challengeFQDN(domain), value, class, ttl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.exchange(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("could not send DNS update to %s: %w", r.Nameserver, err)
+	}
+
+	// The TSIG of the response is not verified. A forged response can at
+	// worst make us think the update failed or that it worked when it did not
+	if len(resp) < 12 || binary.BigEndian.Uint16(resp) != id || resp[2]&0x80 == 0 {
+		return fmt.Errorf("invalid DNS update response from %s", r.Nameserver)
+	}
+
+	if rcode := int(resp[3] & 0x0f); rcode != 0 {
+		name, ok := dnsRcodes[rcode]
+		if !ok {
+			name = fmt.Sprintf("RCODE%d", rcode)
+		}
+		return fmt.Errorf("DNS update for %q was rejected: %s", domain, name)
+	}
+
+	return nil
+}
+
+// updateMessage builds the update message to add or delete a TXT record
+func (r *rfc2136Provider) updateMessage(zone, fqdn, value string, class uint16, ttl uint32) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	_, err := rand.Read(idBytes[:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not generate message ID: %w", err)
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	// Header: ID, flags, ZOCOUNT, PRCOUNT, UPCOUNT, ADCOUNT
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, dnsOpcodeUpdate<<11)
+	msg = binary.BigEndian.AppendUint16(msg, 1)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, 1)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+
+	// Zone section
+	msg, err = appendDNSName(msg, zone)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypeSOA)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+
+	// Update section
+	if len(value) > 255 {
+		return nil, 0, fmt.Errorf("TXT value is too long")
+	}
+	msg, err = appendDNSName(msg, fqdn)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypeTXT)
+	msg = binary.BigEndian.AppendUint16(msg, class)
+	msg = binary.BigEndian.AppendUint32(msg, ttl)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(value)+1))
+	msg = append(msg, byte(len(value)))
+	msg = append(msg, value...)
+
+	if r.TSIGKey == "" {
+		return msg, id, nil
+	}
+
+	msg, err = r.sign(msg, id, time.Now())
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not sign DNS update: %w", err)
+	}
+
+	return msg, id, nil
+}
+
+// sign appends a TSIG record to the message
+func (r *rfc2136Provider) sign(msg []byte, id uint16, now time.Time) ([]byte, error) {
+	keyName, err := appendDNSName(nil, strings.ToLower(r.TSIGKey))
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := appendDNSName(nil, r.TSIGAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := uint64(now.Unix())
+	timeSigned := []byte{
+		byte(signed >> 40), byte(signed >> 32),
+		byte(signed >> 24), byte(signed >> 16), byte(signed >> 8), byte(signed),
+	}
+
+	// The MAC covers the message and these TSIG variables
+	vars := append([]byte{}, keyName...)
+	vars = binary.BigEndian.AppendUint16(vars, dnsClassANY)
+	vars = binary.BigEndian.AppendUint32(vars, 0) // TTL
+	vars = append(vars, algorithm...)
+	vars = append(vars, timeSigned...)
+	vars = binary.BigEndian.AppendUint16(vars, tsigFudge)
+	vars = binary.BigEndian.AppendUint16(vars, 0) // Error
+	vars = binary.BigEndian.AppendUint16(vars, 0) // Other Len
+
+	mac := hmac.New(tsigHash(r.TSIGAlgorithm), r.TSIGSecret)
+	mac.Write(msg)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+
+	rdata := append([]byte{}, algorithm...)
+	rdata = append(rdata, timeSigned...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = binary.BigEndian.AppendUint16(rdata, id)
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // Error
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // Other Len
+
+	signedMsg := append([]byte{}, msg...)
+	binary.BigEndian.PutUint16(signedMsg[10:], 1) // ADCOUNT
+
+	signedMsg = append(signedMsg, keyName...)
+	signedMsg = binary.BigEndian.AppendUint16(signedMsg, dnsTypeTSIG)
+	signedMsg = binary.BigEndian.AppendUint16(signedMsg, dnsClassANY)
+	signedMsg = binary.BigEndian.AppendUint32(signedMsg, 0)
+	signedMsg = binary.BigEndian.AppendUint16(signedMsg, uint16(len(rdata)))
+	signedMsg = append(signedMsg, rdata...)
+
+	return signedMsg, nil
+}
+
+// exchange sends the message to the nameserver and returns the response
+func (r *rfc2136Provider) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, r.Network, r.Nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if r.Network == "tcp" {
+		// Messages over TCP are prefixed with their length
+		_, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+		if err != nil {
+			return nil, err
+		}
+
+		var length [2]byte
+		_, err = io.ReadFull(conn, length[:])
+		if err != nil {
+			return nil, err
+		}
+
+		resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+		_, err = io.ReadFull(conn, resp)
+		return resp, err
+	}
+
+	_, err = conn.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, 65535)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp[:n], nil
+}
+
+// appendDNSName appends the name in wire format without compression
+func appendDNSName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+
+	return append(b, 0), nil
+}
@@ -0,0 +1,308 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dnsUpdate is an update message as parsed by fakeNameserver
+type dnsUpdate struct {
+	Zone  string
+	Name  string
+	Class uint16
+	TTL   uint32
+	Value string
+	Key   string
+}
+
+// fakeNameserver accepts the DNS updates signed with the secret and
+// answers them with the rcode. Every update it gets is sent to the channel
+func fakeNameserver(t *testing.T, secret []byte, rcode byte) (string, <-chan dnsUpdate) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	updates := make(chan dnsUpdate, 10)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			msg := append([]byte{}, buf[:n]...)
+			update, err := parseDNSUpdate(msg, secret)
+
+			resp := append([]byte{}, msg[:12]...)
+			resp[2] |= 0x80 // QR
+			resp[3] = rcode
+			if err != nil {
+				t.Logf("rejected update: %v", err)
+				resp[3] = 9 // NOTAUTH
+			} else {
+				updates <- update
+			}
+
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String(), updates
+}
+
+// parseDNSUpdate parses an update with one TXT record and verifies its TSIG
+func parseDNSUpdate(msg, secret []byte) (dnsUpdate, error) {
+	var u dnsUpdate
+	r := &dnsReader{msg: msg, off: 12}
+
+	if len(msg) < 12 {
+		return u, errors.New("short message")
+	}
+	if opcode := msg[2] >> 3 & 0x0f; opcode != dnsOpcodeUpdate {
+		return u, fmt.Errorf("got opcode %d", opcode)
+	}
+	counts := [4]uint16{}
+	for i := range counts {
+		counts[i] = binary.BigEndian.Uint16(msg[4+2*i:])
+	}
+	if counts != [4]uint16{1, 0, 1, 1} {
+		return u, fmt.Errorf("got section counts %v", counts)
+	}
+
+	u.Zone = r.name()
+	if typ, class := r.uint16(), r.uint16(); typ != dnsTypeSOA || class != dnsClassIN {
+		return u, fmt.Errorf("got zone type %d and class %d", typ, class)
+	}
+
+	u.Name = r.name()
+	if typ := r.uint16(); typ != dnsTypeTXT {
+		return u, fmt.Errorf("got update type %d", typ)
+	}
+	u.Class = r.uint16()
+	u.TTL = r.uint32()
+	rdata := r.bytes(int(r.uint16()))
+	if len(rdata) == 0 || int(rdata[0]) != len(rdata)-1 {
+		return u, fmt.Errorf("invalid TXT data %q", rdata)
+	}
+	u.Value = string(rdata[1:])
+
+	// TSIG
+	tsigStart := r.off
+	u.Key = r.name()
+	keyName := msg[tsigStart:r.off]
+	if typ, class, ttl := r.uint16(), r.uint16(), r.uint32(); typ != dnsTypeTSIG || class != dnsClassANY || ttl != 0 {
+		return u, fmt.Errorf("got TSIG type %d, class %d and TTL %d", typ, class, ttl)
+	}
+	r.uint16() // RDLENGTH
+
+	algStart := r.off
+	algorithm := r.name()
+	algName := msg[algStart:r.off]
+	timeSigned := r.bytes(6)
+	fudge := r.uint16()
+	mac := r.bytes(int(r.uint16()))
+	originalID := r.uint16()
+	tsigErr := r.uint16()
+	otherLen := r.uint16()
+	if r.err != nil {
+		return u, r.err
+	}
+	if r.off != len(msg) {
+		return u, fmt.Errorf("%d bytes after the TSIG", len(msg)-r.off)
+	}
+	if originalID != binary.BigEndian.Uint16(msg) || tsigErr != 0 || otherLen != 0 {
+		return u, errors.New("invalid TSIG fields")
+	}
+
+	var signed uint64
+	for _, b := range timeSigned {
+		signed = signed<<8 | uint64(b)
+	}
+	if d := time.Since(time.Unix(int64(signed), 0)); d > time.Duration(fudge)*time.Second || -d > time.Duration(fudge)*time.Second {
+		return u, fmt.Errorf("time signed is %s from now", d)
+	}
+
+	hash := tsigHash(algorithm + ".")
+	if hash == nil {
+		return u, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+
+	// The MAC is of the message without the TSIG, as if it was never added
+	unsigned := append([]byte{}, msg[:tsigStart]...)
+	binary.BigEndian.PutUint16(unsigned[10:], 0)
+
+	h := hmac.New(hash, secret)
+	h.Write(unsigned)
+	h.Write(keyName)
+	h.Write([]byte{0, dnsClassANY, 0, 0, 0, 0})
+	h.Write(algName)
+	h.Write(timeSigned)
+	h.Write(binary.BigEndian.AppendUint16(nil, fudge))
+	h.Write([]byte{0, 0, 0, 0}) // Error and Other Len
+	if !hmac.Equal(mac, h.Sum(nil)) {
+		return u, errors.New("bad MAC")
+	}
+
+	return u, nil
+}
+
+type dnsReader struct {
+	msg []byte
+	off int
+	err error
+}
+
+func (r *dnsReader) bytes(n int) []byte {
+	if r.err != nil || r.off+n > len(r.msg) {
+		r.err = errors.New("short message")
+		return nil
+	}
+	b := r.msg[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *dnsReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *dnsReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// name reads a name without compression, which the updates do not use
+func (r *dnsReader) name() string {
+	var labels []string
+	for {
+		length := r.bytes(1)
+		if length == nil || length[0] == 0 {
+			return strings.Join(labels, ".")
+		}
+		if length[0] > 63 {
+			r.err = errors.New("compressed name")
+			return ""
+		}
+		labels = append(labels, string(r.bytes(int(length[0]))))
+	}
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	ctx := context.Background()
+
+	for _, algorithm := range []string{"hmac-sha1.", "hmac-sha256.", "hmac-sha512."} {
+		t.Run(algorithm, func(t *testing.T) {
+			nameserver, updates := fakeNameserver(t, secret, 0)
+
+			p := &rfc2136Provider{
+				Nameserver:    nameserver,
+				Network:       "udp",
+				TSIGKey:       "Warden.Key",
+				TSIGSecret:    secret,
+				TSIGAlgorithm: algorithm,
+			}
+
+			err := p.Present(ctx, "www.example.com", "value")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := <-updates
+			want := dnsUpdate{
+				Zone:  "example.com",
+				Name:  "_acme-challenge.www.example.com",
+				Class: dnsClassIN,
+				TTL:   dnsTTL,
+				Value: "value",
+				Key:   "warden.key",
+			}
+			if got != want {
+				t.Errorf("got present %+v, want %+v", got, want)
+			}
+
+			p.Zone = "www.example.com"
+			err = p.CleanUp(ctx, "www.example.com", "value")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = <-updates
+			want.Zone = "www.example.com"
+			want.Class = dnsClassNONE
+			want.TTL = 0
+			if got != want {
+				t.Errorf("got clean up %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRFC2136ProviderErrors(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		secret []byte
+		rcode  byte
+		want   string
+	}{
+		{name: "wrong secret", secret: []byte("wrong"), want: "NOTAUTH"},
+		{name: "refused", secret: []byte("secret"), rcode: 5, want: "REFUSED"},
+		{name: "unknown rcode", secret: []byte("secret"), rcode: 15, want: "RCODE15"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameserver, _ := fakeNameserver(t, []byte("secret"), tt.rcode)
+
+			p := &rfc2136Provider{
+				Nameserver:    nameserver,
+				Network:       "udp",
+				TSIGKey:       "key",
+				TSIGSecret:    tt.secret,
+				TSIGAlgorithm: "hmac-sha256.",
+			}
+
+			err := p.Present(ctx, "example.com", "value")
+			if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendDNSName(t *testing.T) {
+	got, err := appendDNSName(nil, "_acme-challenge.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("\x0f_acme-challenge\x07example\x03com\x00")
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, name := range []string{"a..com", strings.Repeat("a", 64) + ".com"} {
+		if _, err := appendDNSName(nil, name); err == nil {
+			t.Errorf("got no error for %q", name)
+		}
+	}
+}
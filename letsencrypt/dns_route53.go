@@ -0,0 +1,367 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+const (
+	route53Endpoint = "https://route53.amazonaws.com"
+	route53Region   = "us-east-1" // Route53 is a global service signed for this region
+	route53XMLNS    = "https://route53.amazonaws.com/doc/2013-04-01/"
+)
+
+type route53Credentials struct {
+	AWS_ACCESS_KEY_ID     string `env:"AWS_ACCESS_KEY_ID,required"`
+	AWS_SECRET_ACCESS_KEY string `env:"AWS_SECRET_ACCESS_KEY,required"`
+	AWS_SESSION_TOKEN     string `env:"AWS_SESSION_TOKEN"`
+	AWS_HOSTED_ZONE_ID    string `env:"AWS_HOSTED_ZONE_ID"` // Default is found from the domain
+}
+
+// route53Provider manages the records with the AWS Route53 API
+type route53Provider struct {
+	Endpoint     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	HostedZoneID string
+	HTTPClient   *http.Client
+	Propagation  time.Duration
+}
+
+func newRoute53Provider(ctx context.Context, settings internal.Settings, propagation time.Duration) (*route53Provider, error) {
+	creds := route53Credentials{}
+	err := loadCredentials(ctx, settings, "route53", &creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &route53Provider{
+		Endpoint:     route53Endpoint,
+		AccessKey:    creds.AWS_ACCESS_KEY_ID,
+		SecretKey:    creds.AWS_SECRET_ACCESS_KEY,
+		SessionToken: creds.AWS_SESSION_TOKEN,
+		HostedZoneID: creds.AWS_HOSTED_ZONE_ID,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		Propagation:  propagation,
+	}, nil
+}
+
+type route53RecordSet struct {
+	Name            string                  `xml:"Name"`
+	Type            string                  `xml:"Type"`
+	TTL             int                     `xml:"TTL"`
+	ResourceRecords []route53ResourceRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type route53Change struct {
+	Action            string           `xml:"Action"`
+	ResourceRecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+// The same name is used for a domain and its wildcard, so the
+// value is added to the values already in the record set
+func (r *route53Provider) Present(ctx context.Context, domain, value string) error {
+	zoneID, err := r.zoneID(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	fqdn := challengeFQDN(domain) + "."
+	existing, err := r.recordSet(ctx, zoneID, fqdn)
+	if err != nil {
+		return err
+	}
+
+	quoted := strconv.Quote(value)
+	values := []route53ResourceRecord{{Value: quoted}}
+	if existing != nil {
+		for _, v := range existing.ResourceRecords {
+			if v.Value != quoted {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return r.change(ctx, zoneID, "UPSERT", route53RecordSet{
+		Name:            fqdn,
+		Type:            "TXT",
+		TTL:             dnsTTL,
+		ResourceRecords: values,
+	})
+}
+
+func (r *route53Provider) CleanUp(ctx context.Context, domain, value string) error {
+	zoneID, err := r.zoneID(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	fqdn := challengeFQDN(domain) + "."
+	existing, err := r.recordSet(ctx, zoneID, fqdn)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+
+	quoted := strconv.Quote(value)
+	var values []route53ResourceRecord
+	for _, v := range existing.ResourceRecords {
+		if v.Value != quoted {
+			values = append(values, v)
+		}
+	}
+
+	switch {
+	case len(values) == len(existing.ResourceRecords):
+		return nil
+	case len(values) == 0:
+		// A delete must match the existing record set exactly
+		return r.change(ctx, zoneID, "DELETE", *existing)
+	default:
+		existing.ResourceRecords = values
+		return r.change(ctx, zoneID, "UPSERT", *existing)
+	}
+}
+
+func (r *route53Provider) Timeout() (time.Duration, time.Duration) {
+	return r.Propagation, dnsPollInterval
+}
+
+// zoneID finds the public hosted zone that the domain is in
+func (r *route53Provider) zoneID(ctx context.Context, domain string) (string, error) {
+	if r.HostedZoneID != "" {
+		return r.HostedZoneID, nil
+	}
+
+	for _, candidate := range zoneCandidates(domain) {
+		query := url.Values{}
+		query.Set("dnsname", candidate)
+		query.Set("maxitems", "1")
+
+		var resp struct {
+			HostedZones []struct {
+				ID          string `xml:"Id"`
+				Name        string `xml:"Name"`
+				PrivateZone bool   `xml:"Config>PrivateZone"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+
+		err := r.request(ctx, http.MethodGet, "/2013-04-01/hostedzonesbyname", query, nil, &resp)
+		if err != nil {
+			return "", fmt.Errorf("could not list route53 hosted zones: %w", err)
+		}
+
+		for _, zone := range resp.HostedZones {
+			if zone.Name == candidate+"." && !zone.PrivateZone {
+				return strings.TrimPrefix(zone.ID, "/hostedzone/"), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no route53 hosted zone found for %q", domain)
+}
+
+// recordSet returns the TXT record set with the name, or nil if there is none
+func (r *route53Provider) recordSet(ctx context.Context, zoneID, fqdn string) (*route53RecordSet, error) {
+	query := url.Values{}
+	query.Set("name", fqdn)
+	query.Set("type", "TXT")
+	query.Set("maxitems", "1")
+
+	var resp struct {
+		ResourceRecordSets []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+
+	err := r.request(ctx, http.MethodGet, "/2013-04-01/hostedzone/"+zoneID+"/rrset", query, nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("could not list route53 records: %w", err)
+	}
+
+	// The list starts at the name, so it may be a different record
+	for _, set := range resp.ResourceRecordSets {
+		if strings.EqualFold(set.Name, fqdn) && set.Type == "TXT" {
+			return &set, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *route53Provider) change(ctx context.Context, zoneID, action string, set route53RecordSet) error {
+	req := route53ChangeRequest{
+		XMLNS:   route53XMLNS,
+		Changes: []route53Change{{Action: action, ResourceRecordSet: set}},
+	}
+
+	err := r.request(ctx, http.MethodPost, "/2013-04-01/hostedzone/"+zoneID+"/rrset/", nil, req, nil)
+	if err != nil {
+		return fmt.Errorf("could not %s route53 record: %w", strings.ToLower(action), err)
+	}
+
+	return nil
+}
+
+// request sends a signed request to the Route53 API
+func (r *route53Provider) request(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body []byte
+	if in != nil {
+		raw, err := xml.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = append([]byte(xml.Header), raw...)
+	}
+
+	u := r.Endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+
+	r.sign(req, body, time.Now().UTC())
+
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return apiError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(raw))}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	err = xml.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return nil
+}
+
+func (r *route53Provider) sign(req *http.Request, body []byte, now time.Time) {
+	awsSigner{
+		AccessKey:    r.AccessKey,
+		SecretKey:    r.SecretKey,
+		SessionToken: r.SessionToken,
+		Region:       route53Region,
+		Service:      "route53",
+	}.sign(req, body, now)
+}
+
+// awsSigner signs requests to an AWS service
+type awsSigner struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
+}
+
+// sign adds an AWS Signature Version 4 to the request
+// See https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+func (a awsSigner) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + a.Region + "/" + a.Service + "/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+a.SecretKey), date)
+	key = hmacSHA256(key, a.Region)
+	key = hmacSHA256(key, a.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,259 @@
+package letsencrypt
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The examples from the AWS Signature Version 4 test suite and documentation
+func TestAWSSignerVectors(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		service string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "get-vanilla",
+			method:  http.MethodGet,
+			url:     "https://example.amazonaws.com/",
+			service: "service",
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:    "get-vanilla-query-order-key-case",
+			method:  http.MethodGet,
+			url:     "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			service: "service",
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:    "post-vanilla",
+			method:  http.MethodPost,
+			url:     "https://example.amazonaws.com/",
+			service: "service",
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:    "iam list users",
+			method:  http.MethodGet,
+			url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			service: "iam",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			awsSigner{
+				AccessKey: "AKIDEXAMPLE",
+				SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+				Region:    "us-east-1",
+				Service:   tt.service,
+			}.sign(req, nil, now)
+
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("got Authorization\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+var awsAuthorization = regexp.MustCompile(
+	`^AWS4-HMAC-SHA256 Credential=AKID/\d{8}/us-east-1/route53/aws4_request, SignedHeaders=(\S+), Signature=[0-9a-f]{64}$`,
+)
+
+// verifyAWSSignature signs the request again as it was received
+// and checks that the signature is the same
+func verifyAWSSignature(r *http.Request, body []byte, signer awsSigner) error {
+	auth := r.Header.Get("Authorization")
+	match := awsAuthorization.FindStringSubmatch(auth)
+	if match == nil {
+		return fmt.Errorf("invalid Authorization %q", auth)
+	}
+
+	signed, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return fmt.Errorf("invalid X-Amz-Date: %w", err)
+	}
+
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(match[1], ";") {
+		if name != "host" {
+			req.Header.Set(name, r.Header.Get(name))
+		}
+	}
+
+	signer.sign(req, body, signed)
+	if want := req.Header.Get("Authorization"); auth != want {
+		return fmt.Errorf("got Authorization\n%s\nwant\n%s", auth, want)
+	}
+
+	return nil
+}
+
+func TestRoute53Provider(t *testing.T) {
+	signer := awsSigner{
+		AccessKey:    "AKID",
+		SecretKey:    "secret",
+		SessionToken: "token",
+		Region:       "us-east-1",
+		Service:      "route53",
+	}
+
+	fqdn := "_acme-challenge.www.example.com."
+	records := []route53ResourceRecord{{Value: `"other"`}}
+	var changes []route53Change
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := verifyAWSSignature(r, body, signer)
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if token := r.Header.Get("X-Amz-Security-Token"); token != "token" {
+			t.Errorf("got X-Amz-Security-Token %q", token)
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/2013-04-01/hostedzonesbyname":
+			// The list starts at the name, so the zone is also returned for www
+			w.Write([]byte(`<ListHostedZonesByNameResponse><HostedZones>
+				<HostedZone><Id>/hostedzone/PRIVATE</Id><Name>example.com.</Name><Config><PrivateZone>true</PrivateZone></Config></HostedZone>
+				<HostedZone><Id>/hostedzone/Z1</Id><Name>example.com.</Name><Config><PrivateZone>false</PrivateZone></Config></HostedZone>
+			</HostedZones></ListHostedZonesByNameResponse>`))
+
+		case r.Method == http.MethodGet && r.URL.Path == "/2013-04-01/hostedzone/Z1/rrset":
+			if name := r.URL.Query().Get("name"); name != fqdn {
+				t.Errorf("got records of %q, want %q", name, fqdn)
+			}
+
+			var sets []route53RecordSet
+			if len(records) > 0 {
+				sets = append(sets, route53RecordSet{Name: fqdn, Type: "TXT", TTL: dnsTTL, ResourceRecords: records})
+			}
+			xml.NewEncoder(w).Encode(struct {
+				XMLName            xml.Name           `xml:"ListResourceRecordSetsResponse"`
+				ResourceRecordSets []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+			}{ResourceRecordSets: sets})
+
+		case r.Method == http.MethodPost && r.URL.Path == "/2013-04-01/hostedzone/Z1/rrset/":
+			if ct := r.Header.Get("Content-Type"); ct != "text/xml" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+
+			var req route53ChangeRequest
+			err := xml.Unmarshal(body, &req)
+			if err != nil {
+				t.Errorf("invalid change request: %v\n%s", err, body)
+			}
+			if len(req.Changes) != 1 {
+				t.Fatalf("got %d changes, want 1", len(req.Changes))
+			}
+
+			change := req.Changes[0]
+			changes = append(changes, change)
+			records = change.ResourceRecordSet.ResourceRecords
+			if change.Action == "DELETE" {
+				records = nil
+			}
+			w.Write([]byte(`<ChangeResourceRecordSetsResponse/>`))
+
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := &route53Provider{
+		Endpoint:     srv.URL,
+		AccessKey:    signer.AccessKey,
+		SecretKey:    signer.SecretKey,
+		SessionToken: signer.SessionToken,
+		HTTPClient:   srv.Client(),
+	}
+
+	ctx := context.Background()
+	steps := []struct {
+		name string
+		fn   func(context.Context, string, string) error
+		want *route53Change
+	}{
+		{name: "present", fn: p.Present, want: &route53Change{
+			Action: "UPSERT",
+			ResourceRecordSet: route53RecordSet{
+				Name: fqdn, Type: "TXT", TTL: dnsTTL,
+				ResourceRecords: []route53ResourceRecord{{Value: `"value"`}, {Value: `"other"`}},
+			},
+		}},
+		{name: "present again", fn: p.Present, want: &route53Change{
+			Action: "UPSERT",
+			ResourceRecordSet: route53RecordSet{
+				Name: fqdn, Type: "TXT", TTL: dnsTTL,
+				ResourceRecords: []route53ResourceRecord{{Value: `"value"`}, {Value: `"other"`}},
+			},
+		}},
+		{name: "clean up", fn: p.CleanUp, want: &route53Change{
+			Action: "UPSERT",
+			ResourceRecordSet: route53RecordSet{
+				Name: fqdn, Type: "TXT", TTL: dnsTTL,
+				ResourceRecords: []route53ResourceRecord{{Value: `"other"`}},
+			},
+		}},
+		{name: "clean up again", fn: p.CleanUp},
+	}
+
+	for _, step := range steps {
+		changes = nil
+		err := step.fn(ctx, "www.example.com", "value")
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		var want []route53Change
+		if step.want != nil {
+			want = []route53Change{*step.want}
+		}
+		if !reflect.DeepEqual(changes, want) {
+			t.Errorf("%s: got changes %+v, want %+v", step.name, changes, want)
+		}
+	}
+
+	// The last value deletes the record set
+	changes = nil
+	err := p.CleanUp(ctx, "www.example.com", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != "DELETE" || len(changes[0].ResourceRecordSet.ResourceRecords) != 1 {
+		t.Errorf("got changes %+v, want the record set deleted", changes)
+	}
+}
@@ -0,0 +1,108 @@
+package letsencrypt
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bobesa/go-domain-util/domainutil"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/vultr/govultr/v3"
+	"golang.org/x/oauth2"
+)
+
+type vultrCredentials struct {
+	VULTR_API_KEY string `env:"VULTR_API_KEY,required"`
+}
+
+// vultrProvider manages the records with the Vultr API.
+// The domain must be managed by Vultr DNS
+type vultrProvider struct {
+	Client      *govultr.Client
+	Propagation time.Duration
+}
+
+func newVultrProvider(ctx context.Context, settings internal.Settings, propagation time.Duration) (*vultrProvider, error) {
+	creds := vultrCredentials{}
+	err := loadCredentials(ctx, settings, "vultr", &creds)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client is used long after ctx, by later requests
+	ts := (&oauth2.Config{}).TokenSource(context.Background(), &oauth2.Token{AccessToken: creds.VULTR_API_KEY})
+
+	return &vultrProvider{
+		Client:      govultr.NewClient(oauth2.NewClient(context.Background(), ts)),
+		Propagation: propagation,
+	}, nil
+}
+
+// vultrRecord returns the domain registered with vultr and the record name in it
+func vultrRecord(domain string) (string, string) {
+	rootDomain := domainutil.Domain(domain)
+	recordName := "_acme-challenge"
+	if domainutil.HasSubdomain(domain) {
+		recordName += "." + domainutil.Subdomain(domain)
+	}
+
+	return rootDomain, recordName
+}
+
+func (v *vultrProvider) Present(ctx context.Context, domain, value string) error {
+	rootDomain, recordName := vultrRecord(domain)
+
+	_, _, err := v.Client.DomainRecord.Create(ctx, rootDomain, &govultr.DomainRecordReq{
+		Type: "TXT",
+		Name: recordName,
+		Data: strconv.Quote(value),
+		TTL:  dnsTTL,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create vultr DNS record: %w", err)
+	}
+
+	return nil
+}
+
+func (v *vultrProvider) CleanUp(ctx context.Context, domain, value string) error {
+	rootDomain, recordName := vultrRecord(domain)
+
+	var records []govultr.DomainRecord
+	var cursor string
+
+	for {
+		newRecords, meta, _, err := v.Client.DomainRecord.List(ctx, rootDomain, &govultr.ListOptions{
+			PerPage: 500,
+			Cursor:  cursor,
+		})
+		if err != nil {
+			return fmt.Errorf("could not list vultr DNS records for %q: %w", rootDomain, err)
+		}
+		records = append(records, newRecords...)
+
+		if meta == nil || meta.Links == nil || meta.Links.Next == "" {
+			break
+		}
+		cursor = meta.Links.Next
+	}
+
+	for _, record := range records {
+		// The same name is used for a domain and its wildcard
+		if record.Type != "TXT" || record.Name != recordName || record.Data != strconv.Quote(value) {
+			continue
+		}
+
+		err := v.Client.DomainRecord.Delete(ctx, rootDomain, record.ID)
+		if err != nil {
+			return fmt.Errorf("could not delete vultr DNS record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (v *vultrProvider) Timeout() (time.Duration, time.Duration) {
+	return v.Propagation, dnsPollInterval
+}
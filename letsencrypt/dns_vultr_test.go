@@ -0,0 +1,161 @@
+package letsencrypt
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+// newTestVultrProvider reads the credentials from vultr.env
+// and sends the requests to the server
+func newTestVultrProvider(t *testing.T, srv *httptest.Server) *vultrProvider {
+	t.Helper()
+
+	// The environment is looked at before the file
+	t.Setenv("VULTR_API_KEY", "")
+	os.Unsetenv("VULTR_API_KEY")
+
+	settings := internal.Settings{LETSENCRYPT_CREDS_DIR: t.TempDir()}
+	err := os.WriteFile(filepath.Join(settings.LETSENCRYPT_CREDS_DIR, "vultr.env"), []byte("VULTR_API_KEY=key\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newVultrProvider(context.Background(), settings, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Client.SetBaseURL(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestVultrProvider(t *testing.T) {
+	var requests []string
+	var created []map[string]any
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			t.Errorf("got Authorization %q", auth)
+		}
+		requests = append(requests, r.Method+" "+r.URL.String())
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v2/domains/example.com/records":
+			body, _ := io.ReadAll(r.Body)
+			var record map[string]any
+			err := json.Unmarshal(body, &record)
+			if err != nil {
+				t.Errorf("invalid body %s: %v", body, err)
+			}
+			created = append(created, record)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"record": {"id": "r1"}}`))
+
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/domains/example.com/records":
+			// Two pages of records
+			if r.URL.Query().Get("cursor") == "" {
+				w.Write([]byte(`{
+					"records": [
+						{"id": "r1", "type": "TXT", "name": "_acme-challenge.www", "data": "\"value\""},
+						{"id": "r2", "type": "TXT", "name": "_acme-challenge.www", "data": "\"other\""}
+					],
+					"meta": {"total": 4, "links": {"next": "page2", "prev": ""}}
+				}`))
+				return
+			}
+			w.Write([]byte(`{
+				"records": [
+					{"id": "r3", "type": "TXT", "name": "_acme-challenge", "data": "\"value\""},
+					{"id": "r4", "type": "TXT", "name": "_acme-challenge.www", "data": "\"value\""}
+				],
+				"meta": {"total": 4, "links": {"next": "", "prev": "page1"}}
+			}`))
+
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := newTestVultrProvider(t, srv)
+	ctx := context.Background()
+
+	err := p.Present(ctx, "www.example.com", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCreated := []map[string]any{{
+		"type": "TXT",
+		"name": "_acme-challenge.www",
+		"data": `"value"`,
+		"ttl":  float64(dnsTTL),
+	}}
+	if !reflect.DeepEqual(created, wantCreated) {
+		t.Errorf("got records %v, want %v", created, wantCreated)
+	}
+
+	requests = nil
+	err = p.CleanUp(ctx, "www.example.com", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the records with the name and value are deleted, from every page
+	wantRequests := []string{
+		"GET /v2/domains/example.com/records?per_page=500",
+		"GET /v2/domains/example.com/records?cursor=page2&per_page=500",
+		"DELETE /v2/domains/example.com/records/r1",
+		"DELETE /v2/domains/example.com/records/r4",
+	}
+	if !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("got requests\n%q\nwant\n%q", requests, wantRequests)
+	}
+}
+
+func TestVultrProviderErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "Invalid API token.", "status": 401}`))
+	}))
+	defer srv.Close()
+
+	p := newTestVultrProvider(t, srv)
+	ctx := context.Background()
+
+	err := p.Present(ctx, "example.com", "value")
+	if err == nil || !strings.Contains(err.Error(), "Invalid API token.") {
+		t.Errorf("got error %v for Present", err)
+	}
+
+	err = p.CleanUp(ctx, "example.com", "value")
+	if err == nil || !strings.Contains(err.Error(), "Invalid API token.") {
+		t.Errorf("got error %v for CleanUp", err)
+	}
+}
+
+func TestRemovedDNSPlugins(t *testing.T) {
+	for _, name := range []string{"dnsimple", "google", "linode", "ovh"} {
+		_, err := getDNSProvider(context.Background(), internal.Settings{}, name)
+		if err == nil || !strings.Contains(err.Error(), "Migrating from certbot") {
+			t.Errorf("%s: got error %v, want a migration note", name, err)
+		}
+	}
+}
@@ -2,167 +2,171 @@ package letsencrypt
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/metrics"
 )
 
-func GetCertificate(ctx context.Context, settings internal.Settings, config internal.Config) (string, string, error) {
-	var err error
+// The challenge directories for each service are in here. See the "httpBase" template
+var challengeRoot = "/docker/challenge"
 
-	outputDir := "/etc/letsencrypt/live"
-	certPath := filepath.Join(outputDir, config.Domains[0], "fullchain.pem")
-	keyPath := filepath.Join(outputDir, config.Domains[0], "privkey.pem")
+// Saved next to the certificate with the URL of the ACME server that issued it
+const directoryFile = "acme-directory"
 
-	switch {
-	case config.LetsEncryptDNSPlugin != "":
-		err = getCertificateDNS(ctx, settings, config)
-	case config.LetsEncryptAuthenticator != "" && config.LetsEncryptCleaner != "":
-		err = getCertificateManual(ctx, settings, config)
-	default:
-		err = getCertificateAuto(ctx, settings, config, certPath)
+// How long to wait to get a certificate before giving up
+const orderTimeout = 10 * time.Minute
+
+// GetCertificate gets a certificate for the domains of the config.
+// It is saved in a directory with the given name, or the first domain if name is empty.
+// If renew is set, a new certificate is requested even if the current one is not yet due
+func GetCertificate(ctx context.Context, settings internal.Settings, name string, config internal.Config, renew bool) (string, string, error) {
+	if len(config.Domains) == 0 {
+		return "", "", fmt.Errorf("no domains to get a certificate for")
 	}
 
-	return certPath, keyPath, err
-}
+	if name == "" {
+		name = config.Domains[0]
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", "", fmt.Errorf("invalid certificate name %q", name)
+	}
 
-func getCertificateDNS(ctx context.Context, settings internal.Settings, config internal.Config) error {
-	dnsPlugin := config.LetsEncryptDNSPlugin
+	certDir := filepath.Join(settings.LETSENCRYPT_CERTS_DIR, name)
+	certPath := filepath.Join(certDir, "fullchain.pem")
+	keyPath := filepath.Join(certDir, "privkey.pem")
 
-	// Check if it is our internal DNS plugin
-	switch dnsPlugin {
-	case "vultr":
-		config.LetsEncryptAuthenticator = "./bin/vultr-auth"
-		config.LetsEncryptCleaner = "./bin/vultr-clean"
-		return getCertificateManual(ctx, settings, config)
-	}
+	keyType := KeyType(settings, config)
+	directory := DirectoryURL(settings, config)
 
-	dnsPluginFlag := fmt.Sprintf("--dns-%s", dnsPlugin)
-	dnsCredsFlag := fmt.Sprintf("--dns-%s-credentials", dnsPlugin)
-	dnsPropagationFlag := fmt.Sprintf("--dns-%s-propagation-seconds", dnsPlugin)
+	// e.g. after a restart without a persisted state
+	if !renew && hasValidCertificate(certDir, config.Domains, directory, certbotDirectory(settings), keyType, settings.CERT_RENEWAL_WINDOW) {
+		return certPath, keyPath, nil
+	}
 
-	var dnsCredFilename string
-	switch dnsPlugin {
-	case "google":
-		dnsCredFilename = fmt.Sprintf("%s.json", dnsPlugin)
-	case "route53":
-		dnsCredFilename = ""
-	default:
-		dnsCredFilename = fmt.Sprintf("%s.ini", dnsPlugin)
+	metrics.AcmeAttempts.Inc()
+	err := obtainCertificate(ctx, settings, config, certDir, keyType, directory)
+	if err != nil {
+		metrics.AcmeFailures.Inc()
+		return "", "", err
 	}
 
-	cmd := exec.CommandContext(
-		ctx,
-		"certbot",
-		"certonly",
-		"--agree-tos",
-		"-q", "-n",
-		"--email", settings.EMAIL,
-		"--preferred-challenges", "dns",
-		dnsPluginFlag,
-		dnsPropagationFlag, strconv.Itoa(settings.LETSENCRYPT_DNS_PROPAGATION),
-		"--cert-name", config.Domains[0],
-	)
+	return certPath, keyPath, nil
+}
 
-	// Useful for route53 which does not take any flag
-	if dnsCredFilename != "" {
-		dnsCredFilepath := filepath.Join(settings.LETSENCRYPT_CREDS_DIR, dnsCredFilename)
-		cmd.Args = append(cmd.Args, dnsCredsFlag, dnsCredFilepath)
+// obtainCertificate orders a new certificate from the ACME server and saves it in certDir
+func obtainCertificate(ctx context.Context, settings internal.Settings, config internal.Config, certDir, keyType, directory string) error {
+	// Generated first so that an invalid key type does not waste an order
+	key, err := internal.GenerateKey(keyType)
+	if err != nil {
+		return fmt.Errorf("could not generate certificate key: %w", err)
 	}
 
-	if settings.TESTING {
-		cmd.Args = append(cmd.Args, "--test-cert")
+	solver, err := getSolver(ctx, settings, config)
+	if err != nil {
+		return err
 	}
 
-	for _, domain := range config.Domains {
-		cmd.Args = append(cmd.Args, "-d")
-		cmd.Args = append(cmd.Args, domain)
+	ctx, cancel := context.WithTimeout(ctx, orderTimeout)
+	defer cancel()
+
+	client, err := getClient(ctx, settings, config)
+	if err != nil {
+		return fmt.Errorf("could not get ACME client: %w", err)
 	}
 
-	log.Printf("Generating dns certificate for: %q\n", config.Unique)
-	output, err := cmd.CombinedOutput()
+	slog.Info("requesting certificate", "unique", config.Unique, "challenge", solver.Type(), "directory", client.DirectoryURL)
+	chain, err := obtain(ctx, client, config.Domains, key, solver)
 	if err != nil {
-		return fmt.Errorf("Can't get certificate from letsencrypt: %w: %s", err, output)
+		return fmt.Errorf("Can't get certificate from %s: %w", client.DirectoryURL, err)
+	}
+
+	err = saveCertificate(certDir, chain, key, directory)
+	if err != nil {
+		return fmt.Errorf("could not save certificate: %w", err)
 	}
 
 	return nil
 }
 
-func getCertificateManual(ctx context.Context, settings internal.Settings, config internal.Config) error {
-
-	cmd := exec.CommandContext(
-		ctx,
-		"certbot",
-		"certonly",
-		"--agree-tos",
-		"-q", "-n",
-		"--email", settings.EMAIL,
-		"-a", "manual",
-		"--preferred-challenges", "dns",
-		"--manual-public-ip-logging-ok",
-		"--manual-auth-hook", config.LetsEncryptAuthenticator,
-		"--manual-cleanup-hook", config.LetsEncryptCleaner,
-		"--cert-name", config.Domains[0],
-	)
-
+// certbotDirectory is the ACME server of certificates that were issued by certbot,
+// which did not save the directory along with the certificate
+func certbotDirectory(settings internal.Settings) string {
 	if settings.TESTING {
-		cmd.Args = append(cmd.Args, "--test-cert")
+		return stagingDirectory
 	}
 
-	for _, domain := range config.Domains {
-		cmd.Args = append(cmd.Args, "-d")
-		cmd.Args = append(cmd.Args, domain)
-	}
-
-	log.Printf("Generating manual certificate for: %q\n", config.Unique)
+	return productionDirectory
+}
 
-	output, err := cmd.CombinedOutput()
+// hasValidCertificate checks if there is already a certificate in the directory for all the domains
+// from the same ACME server and with the same key type, that does not need to be renewed yet.
+// A certificate without the directory it was issued by is from defaultDirectory
+func hasValidCertificate(certDir string, domains []string, directory, defaultDirectory, keyType string, window time.Duration) bool {
+	info, err := internal.ReadCertificateInfo(filepath.Join(certDir, "fullchain.pem"))
 	if err != nil {
-		return fmt.Errorf("Can't get certificate from letsencrypt: %w: %s", err, output)
+		return false
 	}
 
-	return nil
-}
-
-func getCertificateAuto(ctx context.Context, settings internal.Settings, config internal.Config, path string) error {
-	webrootPath := filepath.Join("/docker/challenge", config.Unique)
+	if info.KeyType != keyType {
+		return false
+	}
 
-	err := os.MkdirAll(webrootPath, 0755)
-	if err != nil {
-		return fmt.Errorf("Can't make letsencrypt webroot dir: %w", err)
+	if !fileExists(filepath.Join(certDir, "privkey.pem")) {
+		return false
 	}
 
-	cmd := exec.CommandContext(
-		ctx,
-		"certbot",
-		"certonly",
-		"--agree-tos",
-		"-q", "-n",
-		"--email", settings.EMAIL,
-		"-a", "webroot",
-		"--webroot-path", webrootPath,
-		"--cert-name", config.Domains[0],
-	)
+	issuedBy, err := os.ReadFile(filepath.Join(certDir, directoryFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		issuedBy = []byte(defaultDirectory)
+	case err != nil:
+		return false
+	}
+	if strings.TrimSpace(string(issuedBy)) != directory {
+		return false
+	}
 
-	for _, domain := range config.Domains {
-		cmd.Args = append(cmd.Args, "-d")
-		cmd.Args = append(cmd.Args, domain)
+	if time.Now().Add(window).After(info.NotAfter) {
+		return false
 	}
 
-	if settings.TESTING {
-		cmd.Args = append(cmd.Args, "--test-cert")
+	sans := make(map[string]bool, len(info.SANs))
+	for _, san := range info.SANs {
+		sans[strings.ToLower(san)] = true
 	}
 
-	log.Printf("Generating webroot certificate for: %q\n", config.Unique)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("Can't get certificate from letsencrypt: %w: %s", err, output)
+	for _, domain := range domains {
+		if !sans[strings.ToLower(domain)] {
+			return false
+		}
 	}
 
-	return nil
+	return true
+}
+
+func getSolver(ctx context.Context, settings internal.Settings, config internal.Config) (challengeSolver, error) {
+	switch {
+	case config.LetsEncryptDNSPlugin != "":
+		provider, err := getDNSProvider(ctx, settings, config.LetsEncryptDNSPlugin)
+		if err != nil {
+			return nil, err
+		}
+		return dnsSolver{Provider: provider}, nil
+
+	case config.LetsEncryptAuthenticator != "" && config.LetsEncryptCleaner != "":
+		return dnsSolver{Provider: hookProvider{
+			Auth:    config.LetsEncryptAuthenticator,
+			Cleanup: config.LetsEncryptCleaner,
+		}}, nil
+
+	default:
+		return webrootSolver{Root: filepath.Join(challengeRoot, config.Unique)}, nil
+	}
 }
@@ -0,0 +1,118 @@
+package letsencrypt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+// writeCertbotCertificate saves a certificate the way certbot does, with
+// the files in the live directory linking to the archive directory
+func writeCertbotCertificate(t *testing.T, root, name string, domains []string, notAfter time.Time) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKey, err := internal.EncodePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(root, "archive", name)
+	live := filepath.Join(root, "live", name)
+	for _, dir := range []string{archive, live} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string][]byte{
+		"fullchain": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"privkey":   encodedKey,
+	}
+	for file, contents := range files {
+		err := os.WriteFile(filepath.Join(archive, file+"1.pem"), contents, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Symlink(filepath.Join("..", "..", "archive", name, file+"1.pem"), filepath.Join(live, file+".pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return live
+}
+
+func TestHasValidCertificate(t *testing.T) {
+	root := t.TempDir()
+	domains := []string{"a.com", "b.com"}
+	window := 30 * 24 * time.Hour
+
+	certDir := writeCertbotCertificate(t, root, "a.com", domains, time.Now().Add(60*24*time.Hour))
+	expiring := writeCertbotCertificate(t, root, "expiring", domains, time.Now().Add(10*24*time.Hour))
+
+	issued := writeCertbotCertificate(t, root, "issued", domains, time.Now().Add(60*24*time.Hour))
+	err := os.WriteFile(filepath.Join(issued, directoryFile), []byte("https://acme.example.com/dir\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		certDir   string
+		domains   []string
+		directory string
+		keyType   string
+		want      bool
+	}{
+		{name: "certbot certificate from the default directory", certDir: certDir, domains: domains, directory: productionDirectory, keyType: internal.KeyTypeECDSAP256, want: true},
+		{name: "certbot certificate for fewer domains", certDir: certDir, domains: []string{"A.com"}, directory: productionDirectory, keyType: internal.KeyTypeECDSAP256, want: true},
+		{name: "certbot certificate from another directory", certDir: certDir, domains: domains, directory: "https://acme.example.com/dir", keyType: internal.KeyTypeECDSAP256, want: false},
+		{name: "certbot certificate with another key type", certDir: certDir, domains: domains, directory: productionDirectory, keyType: internal.KeyTypeRSA2048, want: false},
+		{name: "certbot certificate without a domain", certDir: certDir, domains: []string{"c.com"}, directory: productionDirectory, keyType: internal.KeyTypeECDSAP256, want: false},
+		{name: "certbot certificate to renew", certDir: expiring, domains: domains, directory: productionDirectory, keyType: internal.KeyTypeECDSAP256, want: false},
+		{name: "saved directory", certDir: issued, domains: domains, directory: "https://acme.example.com/dir", keyType: internal.KeyTypeECDSAP256, want: true},
+		{name: "saved directory is not the default", certDir: issued, domains: domains, directory: productionDirectory, keyType: internal.KeyTypeECDSAP256, want: false},
+		{name: "no certificate", certDir: filepath.Join(root, "live", "missing"), domains: domains, directory: productionDirectory, keyType: internal.KeyTypeECDSAP256, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hasValidCertificate(tt.certDir, tt.domains, tt.directory, productionDirectory, tt.keyType, window)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertbotDirectory(t *testing.T) {
+	if got := certbotDirectory(internal.Settings{ACME_DIRECTORY: "https://acme.example.com/dir"}); got != productionDirectory {
+		t.Errorf("got %q, want %q", got, productionDirectory)
+	}
+	if got := certbotDirectory(internal.Settings{TESTING: true}); got != stagingDirectory {
+		t.Errorf("got %q with TESTING, want %q", got, stagingDirectory)
+	}
+}
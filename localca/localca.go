@@ -0,0 +1,331 @@
+// Package localca issues certificates that do not need an ACME server.
+// They are either self-signed or signed by a CA that is created and kept locally
+package localca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+// How long the local CA is valid for
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// Services are configured concurrently. This makes sure only one CA is created
+var caMu sync.Mutex
+
+// Request describes the certificate to get
+type Request struct {
+	Name       string // The directory to keep the certificate in. See certificateDir
+	Domains    []string
+	KeyType    string
+	SelfSigned bool // Otherwise, it is signed by the local CA
+	Validity   time.Duration
+	Renew      bool // Issue a new certificate even if the current one can still be used
+}
+
+// GetCertificate returns the paths to a certificate for the request.
+// The current certificate is kept if it matches and is valid for at least half of its validity.
+// changed reports if a new certificate was issued
+func GetCertificate(settings internal.Settings, req Request) (certPath, keyPath string, changed bool, err error) {
+	if len(req.Domains) == 0 {
+		return "", "", false, fmt.Errorf("no domains to get a certificate for")
+	}
+
+	// It would never be kept, so a new certificate would be written every time
+	if req.Validity <= 0 {
+		return "", "", false, fmt.Errorf("invalid validity %s. It must be more than 0", req.Validity)
+	}
+
+	if strings.ContainsAny(req.Name, `/\`) || req.Name == "" || req.Name == "." || req.Name == ".." {
+		return "", "", false, fmt.Errorf("invalid certificate name %q", req.Name)
+	}
+
+	certDir := certificateDir(settings, req)
+	certPath = filepath.Join(certDir, "fullchain.pem")
+	keyPath = filepath.Join(certDir, "privkey.pem")
+
+	var ca *x509.Certificate
+	var caKey crypto.Signer
+	if !req.SelfSigned {
+		ca, caKey, err = loadCA(settings.LOCAL_CA_DIR)
+		if err != nil {
+			return "", "", false, fmt.Errorf("could not get local CA: %w", err)
+		}
+	}
+
+	if !req.Renew && canKeep(certPath, keyPath, req, ca) {
+		return certPath, keyPath, false, nil
+	}
+
+	key, err := internal.GenerateKey(req.KeyType)
+	if err != nil {
+		return "", "", false, fmt.Errorf("could not generate certificate key: %w", err)
+	}
+
+	template, err := certificateTemplate(req)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	// A self-signed certificate is its own CA
+	parent, parentKey := template, key
+	if !req.SelfSigned {
+		parent, parentKey = ca, caKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return "", "", false, fmt.Errorf("could not create certificate: %w", err)
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if !req.SelfSigned {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	}
+
+	err = save(certDir, chain, key)
+	if err != nil {
+		return "", "", false, fmt.Errorf("could not save certificate: %w", err)
+	}
+
+	return certPath, keyPath, true, nil
+}
+
+// certificateDir is where the certificate for the request is kept.
+// Each source has its own directory in LOCAL_CERTS_DIR so that a self-signed
+// certificate and one from the local CA with the same name do not replace each other
+func certificateDir(settings internal.Settings, req Request) string {
+	source := "internal-ca"
+	if req.SelfSigned {
+		source = "selfsigned"
+	}
+
+	return filepath.Join(settings.LOCAL_CERTS_DIR, source, req.Name)
+}
+
+// CACertificatePath is where the certificate of the local CA is.
+// Clients that should trust the certificates it issues can import it
+func CACertificatePath(settings internal.Settings) string {
+	return filepath.Join(settings.LOCAL_CA_DIR, "ca.pem")
+}
+
+// loadCA loads the local CA, creating it if it does not exist
+func loadCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	caMu.Lock()
+	defer caMu.Unlock()
+
+	certPath := filepath.Join(dir, "ca.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+
+	rawCert, certErr := os.ReadFile(certPath)
+	rawKey, keyErr := os.ReadFile(keyPath)
+
+	switch {
+	case certErr == nil && keyErr == nil:
+		return parseCA(rawCert, rawKey)
+
+	case errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist):
+		return createCA(dir, certPath, keyPath)
+
+	default:
+		return nil, nil, fmt.Errorf("could not read CA in %q: %w", dir, errors.Join(certErr, keyErr))
+	}
+}
+
+func parseCA(rawCert, rawKey []byte) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(rawCert)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no CA certificate found")
+	}
+
+	ca, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse CA certificate: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(rawKey)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no CA key found")
+	}
+
+	key, err := internal.ParsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse CA key: %w", err)
+	}
+
+	return ca, key, nil
+}
+
+func createCA(dir, certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := internal.GenerateKey(internal.KeyTypeECDSAP256)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not generate CA key: %w", err)
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Warden Local CA", Organization: []string{"Warden"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create CA certificate: %w", err)
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse CA certificate: %w", err)
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create CA directory: %w", err)
+	}
+
+	encoded, err := internal.EncodePrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not encode CA key: %w", err)
+	}
+
+	// The key first, so that there is never a CA certificate without its key
+	err = internal.WriteFileAtomic(keyPath, encoded, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = internal.WriteFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ca, key, nil
+}
+
+func certificateTemplate(req Request) (*x509.Certificate, error) {
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: req.Domains[0]},
+		DNSNames:              req.Domains,
+		NotBefore:             now.Add(-time.Hour), // in case of clock skew
+		NotAfter:              now.Add(req.Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	return template, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("could not generate serial number: %w", err)
+	}
+
+	return serial, nil
+}
+
+// canKeep checks if the current certificate is for the same domains, key type
+// and issuer as the request, and is not past half of its validity
+func canKeep(certPath, keyPath string, req Request, ca *x509.Certificate) bool {
+	raw, err := os.ReadFile(certPath)
+	if err != nil {
+		return false
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return false
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+
+	if _, err := os.Stat(keyPath); err != nil {
+		return false
+	}
+
+	if internal.PublicKeyType(cert.PublicKey) != req.KeyType {
+		return false
+	}
+
+	if time.Until(cert.NotAfter) < req.Validity/2 {
+		return false
+	}
+
+	// A self-signed certificate is not a CA, so it cannot be checked with CheckSignatureFrom
+	if ca == nil {
+		err = cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+	} else {
+		err = cert.CheckSignatureFrom(ca)
+	}
+	if err != nil {
+		return false
+	}
+
+	sans := make(map[string]bool, len(cert.DNSNames))
+	for _, san := range cert.DNSNames {
+		sans[strings.ToLower(san)] = true
+	}
+
+	for _, domain := range req.Domains {
+		if !sans[strings.ToLower(domain)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// save writes the chain and key in the same layout as the ACME certificates
+func save(dir string, chain []byte, key crypto.Signer) error {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("could not create certificate directory: %w", err)
+	}
+
+	encoded, err := internal.EncodePrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("could not encode certificate key: %w", err)
+	}
+
+	err = internal.WriteFileAtomic(filepath.Join(dir, "privkey.pem"), encoded, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return internal.WriteFileAtomic(filepath.Join(dir, "fullchain.pem"), chain, 0o644)
+}
@@ -0,0 +1,204 @@
+package localca
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+func testSettings(t *testing.T) internal.Settings {
+	dir := t.TempDir()
+	return internal.Settings{
+		LOCAL_CA_DIR:    filepath.Join(dir, "ca"),
+		LOCAL_CERTS_DIR: filepath.Join(dir, "certs"),
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	settings := testSettings(t)
+	req := Request{
+		Name:     "web",
+		Domains:  []string{"a.com", "b.com"},
+		KeyType:  internal.KeyTypeECDSAP256,
+		Validity: 90 * 24 * time.Hour,
+	}
+
+	certPath, keyPath, changed, err := GetCertificate(settings, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("got changed false for a new certificate")
+	}
+	if certPath != filepath.Join(settings.LOCAL_CERTS_DIR, "internal-ca", "web", "fullchain.pem") || keyPath != filepath.Join(settings.LOCAL_CERTS_DIR, "internal-ca", "web", "privkey.pem") {
+		t.Errorf("got paths %q and %q", certPath, keyPath)
+	}
+
+	info, err := internal.ReadCertificateInfo(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Issuer != "CN=Warden Local CA,O=Warden" {
+		t.Errorf("got issuer %q", info.Issuer)
+	}
+
+	_, _, changed, err = GetCertificate(settings, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("the certificate was issued again")
+	}
+
+	req.Renew = true
+	_, _, changed, err = GetCertificate(settings, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("the certificate was not renewed")
+	}
+}
+
+func TestGetCertificateSources(t *testing.T) {
+	settings := testSettings(t)
+	fromCA := Request{
+		Name:     "web",
+		Domains:  []string{"a.com"},
+		KeyType:  internal.KeyTypeECDSAP256,
+		Validity: 90 * 24 * time.Hour,
+	}
+	selfSigned := fromCA
+	selfSigned.SelfSigned = true
+
+	wantDirs := map[bool]string{
+		false: filepath.Join(settings.LOCAL_CERTS_DIR, "internal-ca", "web"),
+		true:  filepath.Join(settings.LOCAL_CERTS_DIR, "selfsigned", "web"),
+	}
+
+	// Certificates with the same name from both sources are kept
+	for i := 0; i < 2; i++ {
+		for _, req := range []Request{fromCA, selfSigned} {
+			certPath, keyPath, changed, err := GetCertificate(settings, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := wantDirs[req.SelfSigned]
+			if certPath != filepath.Join(dir, "fullchain.pem") || keyPath != filepath.Join(dir, "privkey.pem") {
+				t.Errorf("self-signed %v: got paths %q and %q", req.SelfSigned, certPath, keyPath)
+			}
+			if changed != (i == 0) {
+				t.Errorf("self-signed %v, request %d: got changed %v", req.SelfSigned, i+1, changed)
+			}
+		}
+	}
+}
+
+func TestGetCertificateValidity(t *testing.T) {
+	settings := testSettings(t)
+
+	for _, validity := range []time.Duration{0, -time.Hour} {
+		_, _, _, err := GetCertificate(settings, Request{
+			Name:     "web",
+			Domains:  []string{"a.com"},
+			KeyType:  internal.KeyTypeECDSAP256,
+			Validity: validity,
+		})
+		if err == nil {
+			t.Errorf("got no error for a validity of %s", validity)
+		}
+	}
+
+	// Nothing is written for an invalid request, not even the CA
+	if _, err := os.Stat(settings.LOCAL_CERTS_DIR); err == nil {
+		t.Error("a certificate was written")
+	}
+	if _, err := os.Stat(settings.LOCAL_CA_DIR); err == nil {
+		t.Error("a CA was created")
+	}
+}
+
+func TestCanKeep(t *testing.T) {
+	settings := testSettings(t)
+
+	current := Request{
+		Name:     "web",
+		Domains:  []string{"a.com", "b.com"},
+		KeyType:  internal.KeyTypeECDSAP256,
+		Validity: 90 * 24 * time.Hour,
+	}
+	certPath, keyPath, _, err := GetCertificate(settings, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, _, err := loadCA(settings.LOCAL_CA_DIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, _, err := loadCA(filepath.Join(t.TempDir(), "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selfSigned := current
+	selfSigned.Name = "self"
+	selfSigned.SelfSigned = true
+	selfCertPath, selfKeyPath, _, err := GetCertificate(settings, selfSigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with returns the current request with a change
+	with := func(change func(*Request)) Request {
+		req := current
+		req.Domains = append([]string{}, current.Domains...)
+		change(&req)
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		certPath string
+		keyPath  string
+		req      Request
+		issuer   *x509.Certificate // nil if self-signed
+		want     bool
+	}{
+		{name: "same request", req: current, issuer: ca, want: true},
+		{name: "domains in another case", req: with(func(r *Request) { r.Domains = []string{"B.com", "A.COM"} }), issuer: ca, want: true},
+		{name: "fewer domains", req: with(func(r *Request) { r.Domains = []string{"a.com"} }), issuer: ca, want: true},
+		{name: "another domain", req: with(func(r *Request) { r.Domains = append(r.Domains, "c.com") }), issuer: ca, want: false},
+		{name: "wildcard is not the same SAN", req: with(func(r *Request) { r.Domains = []string{"*.a.com"} }), issuer: ca, want: false},
+		{name: "another key type", req: with(func(r *Request) { r.KeyType = internal.KeyTypeECDSAP384 }), issuer: ca, want: false},
+		{name: "rsa key type", req: with(func(r *Request) { r.KeyType = internal.KeyTypeRSA2048 }), issuer: ca, want: false},
+		{name: "past half of a longer validity", req: with(func(r *Request) { r.Validity = 200 * 24 * time.Hour }), issuer: ca, want: false},
+		{name: "another CA", req: current, issuer: otherCA, want: false},
+		{name: "self-signed wanted", req: with(func(r *Request) { r.SelfSigned = true }), want: false},
+		{name: "self-signed kept", certPath: selfCertPath, keyPath: selfKeyPath, req: selfSigned, want: true},
+		{name: "self-signed but the CA is wanted", certPath: selfCertPath, keyPath: selfKeyPath, req: current, issuer: ca, want: false},
+		{name: "no key", keyPath: filepath.Join(t.TempDir(), "privkey.pem"), req: current, issuer: ca, want: false},
+		{name: "no certificate", certPath: filepath.Join(t.TempDir(), "fullchain.pem"), req: current, issuer: ca, want: false},
+		{name: "not a certificate", certPath: keyPath, req: current, issuer: ca, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.certPath == "" {
+				tt.certPath = certPath
+			}
+			if tt.keyPath == "" {
+				tt.keyPath = keyPath
+			}
+
+			if got := canKeep(tt.certPath, tt.keyPath, tt.req, tt.issuer); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -38,6 +38,7 @@ func main() {
 	}
 
 	if err := cmd.Execute(ctx, settings); err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,243 @@
+// Package metrics keeps counters, gauges and histograms and exposes them
+// in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Every metric created in this package, in the order they are written
+var (
+	registryMu sync.Mutex
+	registry   []*family
+)
+
+// The buckets used for durations in seconds
+var DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64 // Only for histograms
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+
+	// Only for histograms
+	counts []uint64
+	count  uint64
+}
+
+func newFamily(name, help, typ string, buckets []float64, labels []string) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	registryMu.Lock()
+	registry = append(registry, f)
+	registryMu.Unlock()
+
+	return f
+}
+
+// get returns the series with the label values. f.mu must be held
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// Counter only goes up. e.g. the number of reloads
+type Counter struct{ f *family }
+
+func NewCounter(name, help string, labels ...string) Counter {
+	return Counter{f: newFamily(name, help, "counter", nil, labels)}
+}
+
+func (c Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c Counter) Add(v float64, values ...string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+
+	c.f.get(values).value += v
+}
+
+// Gauge is a value that can go up and down. e.g. the number of services
+type Gauge struct{ f *family }
+
+func NewGauge(name, help string, labels ...string) Gauge {
+	return Gauge{f: newFamily(name, help, "gauge", nil, labels)}
+}
+
+func (g Gauge) Set(v float64, values ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+
+	g.f.get(values).value = v
+}
+
+// Reset removes every series. It is used for gauges that are set
+// from scratch every time, so that removed services are not kept
+func (g Gauge) Reset() {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+
+	g.f.series = make(map[string]*series)
+}
+
+// Histogram counts observations in buckets. e.g. how long something took
+type Histogram struct{ f *family }
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	return Histogram{f: newFamily(name, help, "histogram", buckets, labels)}
+}
+
+func (h Histogram) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.get(values)
+	s.value += v
+	s.count++
+	for i, upper := range h.f.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+}
+
+// Handler serves every metric in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = Write(w)
+	})
+}
+
+// Write writes every metric in the Prometheus text format
+func Write(out io.Writer) error {
+	registryMu.Lock()
+	families := append([]*family{}, registry...)
+	registryMu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, f := range families {
+		f.write(w)
+	}
+
+	return w.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	// Sorted so that the output is stable
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+
+		if f.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelSet(f.labels, s.values), formatValue(s.value))
+			continue
+		}
+
+		// Copied so that "le" is never appended to the shared slices
+		names := append(append(make([]string, 0, len(f.labels)+1), f.labels...), "le")
+		values := append(make([]string, 0, len(s.values)+1), s.values...)
+
+		for i, upper := range f.buckets {
+			labels := labelSet(names, append(values, formatValue(upper)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels, s.counts[i])
+		}
+		labels := labelSet(names, append(values, "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelSet(f.labels, s.values), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelSet(f.labels, s.values), s.count)
+	}
+}
+
+func labelSet(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(f *family) string {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	f.write(w)
+	w.Flush()
+	return b.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests by path.\nWith a \\ in the help", "path", "code")
+	c.Inc("/b", "200")
+	c.Inc(`/a"quoted"`, "500")
+	c.Add(2.5, "/b", "200")
+	c.Inc("line\nbreak\\", "200")
+
+	want := `# HELP test_requests_total Requests by path.\nWith a \\ in the help
+# TYPE test_requests_total counter
+test_requests_total{path="/a\"quoted\"",code="500"} 1
+test_requests_total{path="/b",code="200"} 3.5
+test_requests_total{path="line\nbreak\\",code="200"} 1
+`
+	if got := render(c.f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_services", "Services.")
+
+	want := "# HELP test_services Services.\n# TYPE test_services gauge\n"
+	if got := render(g.f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	g.Set(3)
+	g.Set(math.Inf(-1))
+	want += "test_services -Inf\n"
+	if got := render(g.f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	g.Reset()
+	g.Set(math.NaN())
+	want = "# HELP test_services Services.\n# TYPE test_services gauge\ntest_services NaN\n"
+	if got := render(g.f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "How long it took.", []float64{1, 0.5, 2.5}, "step")
+	h.Observe(0.25, "a")
+	h.Observe(0.5, "a")
+	h.Observe(2, "a")
+	h.Observe(10, "a")
+	h.Observe(1, `b"`)
+
+	want := `# HELP test_duration_seconds How long it took.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{step="a",le="0.5"} 2
+test_duration_seconds_bucket{step="a",le="1"} 2
+test_duration_seconds_bucket{step="a",le="2.5"} 3
+test_duration_seconds_bucket{step="a",le="+Inf"} 4
+test_duration_seconds_sum{step="a"} 12.75
+test_duration_seconds_count{step="a"} 4
+test_duration_seconds_bucket{step="b\"",le="0.5"} 0
+test_duration_seconds_bucket{step="b\"",le="1"} 1
+test_duration_seconds_bucket{step="b\"",le="2.5"} 1
+test_duration_seconds_bucket{step="b\"",le="+Inf"} 1
+test_duration_seconds_sum{step="b\""} 1
+test_duration_seconds_count{step="b\""} 1
+`
+	if got := render(h.f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	h := NewHistogram("test_size_bytes", "Sizes.", []float64{100})
+	h.Observe(50)
+	h.Observe(150)
+
+	want := `# HELP test_size_bytes Sizes.
+# TYPE test_size_bytes histogram
+test_size_bytes_bucket{le="100"} 1
+test_size_bytes_bucket{le="+Inf"} 2
+test_size_bytes_sum 200
+test_size_bytes_count 2
+`
+	if got := render(h.f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	c := NewCounter("test_labels_total", "Labels.", "a")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for the wrong number of label values")
+		}
+	}()
+	c.Inc("a", "b")
+}
+
+func TestHandler(t *testing.T) {
+	c := NewCounter("test_handler_total", "Handler.")
+	c.Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "# TYPE test_handler_total counter\ntest_handler_total 1\n") {
+		t.Errorf("test_handler_total is missing from\n%s", body)
+	}
+}
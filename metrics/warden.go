@@ -0,0 +1,93 @@
+package metrics
+
+// Set from the state DB every time the metrics are served
+var (
+	Services = NewGauge(
+		"warden_services",
+		"The number of services in each state.",
+		"state",
+	)
+	CertificateExpiry = NewGauge(
+		"warden_certificate_expiry_timestamp_seconds",
+		"When the certificate of the service expires, as a unix timestamp.",
+		"service", "file",
+	)
+	UpstreamHealthy = NewGauge(
+		"warden_upstream_healthy",
+		"1 if the last health check of the upstream server passed, 0 otherwise.",
+		"service", "file", "address",
+	)
+)
+
+var (
+	ConfigFileChanges = NewCounter(
+		"warden_config_file_changes_total",
+		"The number of config files added or updated, by source.",
+		"source", "change",
+	)
+	ConfigFileErrors = NewCounter(
+		"warden_config_file_errors_total",
+		"The number of times a config file could not be read.",
+	)
+	FileConfigurations = NewCounter(
+		"warden_file_configurations_total",
+		"The number of times the services of a config file were set up, by result.",
+		"result",
+	)
+)
+
+var (
+	ConfigGenerationDuration = NewHistogram(
+		"warden_config_generation_duration_seconds",
+		"How long each stage of generating the nginx configs took.",
+		DurationBuckets,
+		"stage",
+	)
+	ConfigGenerationFailures = NewCounter(
+		"warden_config_generation_failures_total",
+		"The number of times a stage of generating the nginx configs failed.",
+		"stage",
+	)
+	RejectedConfigs = NewCounter(
+		"warden_nginx_rejected_configs_total",
+		"The number of services whose generated config was rejected by nginx.",
+	)
+	NginxReloads = NewCounter(
+		"warden_nginx_reloads_total",
+		"The number of nginx reloads, by result.",
+		"result",
+	)
+)
+
+var (
+	AcmeAttempts = NewCounter(
+		"warden_acme_issuance_attempts_total",
+		"The number of certificates requested from an ACME server.",
+	)
+	AcmeFailures = NewCounter(
+		"warden_acme_issuance_failures_total",
+		"The number of certificates that could not be obtained from an ACME server.",
+	)
+)
+
+var (
+	UpstreamChecks = NewCounter(
+		"warden_upstream_checks_total",
+		"The number of upstream health checks, by result.",
+		"result",
+	)
+	WebhookDeliveries = NewCounter(
+		"warden_webhook_deliveries_total",
+		"The number of events sent to webhooks, by result.",
+		"result",
+	)
+)
+
+// Result is the value of the "result" label
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+
+	return "success"
+}
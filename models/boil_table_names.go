@@ -4,11 +4,15 @@
 package models
 
 var TableNames = struct {
-	Files        string
-	NginxConfigs string
-	Services     string
+	Files             string
+	NginxConfigs      string
+	Services          string
+	UpstreamChecks    string
+	WebhookDeliveries string
 }{
-	Files:        "files",
-	NginxConfigs: "nginx_configs",
-	Services:     "services",
+	Files:             "files",
+	NginxConfigs:      "nginx_configs",
+	Services:          "services",
+	UpstreamChecks:    "upstream_checks",
+	WebhookDeliveries: "webhook_deliveries",
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/friendsofgo/errors"
 	"github.com/stephenafamo/warden/internal"
+	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 	"github.com/volatiletech/sqlboiler/v4/queries"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
@@ -29,6 +30,10 @@ type File struct {
 	Content      internal.ServiceMap `boil:"content" json:"content" toml:"content" yaml:"content"`
 	IsConfigured bool                `boil:"is_configured" json:"is_configured" toml:"is_configured" yaml:"is_configured"`
 	LastModified time.Time           `boil:"last_modified" json:"last_modified" toml:"last_modified" yaml:"last_modified"`
+	Checksum     string              `boil:"checksum" json:"checksum" toml:"checksum" yaml:"checksum"`
+	Source       string              `boil:"source" json:"source" toml:"source" yaml:"source"`
+	Error        null.String         `boil:"error" json:"error,omitempty" toml:"error" yaml:"error,omitempty"`
+	Format       string              `boil:"format" json:"format" toml:"format" yaml:"format"`
 
 	R *fileR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L fileL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -41,6 +46,10 @@ var FileColumns = struct {
 	Content      string
 	IsConfigured string
 	LastModified string
+	Checksum     string
+	Source       string
+	Error        string
+	Format       string
 }{
 	ID:           "id",
 	Path:         "path",
@@ -48,6 +57,10 @@ var FileColumns = struct {
 	Content:      "content",
 	IsConfigured: "is_configured",
 	LastModified: "last_modified",
+	Checksum:     "checksum",
+	Source:       "source",
+	Error:        "error",
+	Format:       "format",
 }
 
 // Generated where
@@ -156,6 +169,10 @@ var FileWhere = struct {
 	Content      whereHelperinternal_ServiceMap
 	IsConfigured whereHelperbool
 	LastModified whereHelpertime_Time
+	Checksum     whereHelperstring
+	Source       whereHelperstring
+	Error        whereHelpernull_String
+	Format       whereHelperstring
 }{
 	ID:           whereHelperint64{field: "\"files\".\"id\""},
 	Path:         whereHelperstring{field: "\"files\".\"path\""},
@@ -163,6 +180,10 @@ var FileWhere = struct {
 	Content:      whereHelperinternal_ServiceMap{field: "\"files\".\"content\""},
 	IsConfigured: whereHelperbool{field: "\"files\".\"is_configured\""},
 	LastModified: whereHelpertime_Time{field: "\"files\".\"last_modified\""},
+	Checksum:     whereHelperstring{field: "\"files\".\"checksum\""},
+	Source:       whereHelperstring{field: "\"files\".\"source\""},
+	Error:        whereHelpernull_String{field: "\"files\".\"error\""},
+	Format:       whereHelperstring{field: "\"files\".\"format\""},
 }
 
 // FileRels is where relationship names are stored.
@@ -186,9 +207,9 @@ func (*fileR) NewStruct() *fileR {
 type fileL struct{}
 
 var (
-	fileAllColumns            = []string{"id", "path", "name", "content", "is_configured", "last_modified"}
-	fileColumnsWithoutDefault = []string{"path", "name", "content", "last_modified"}
-	fileColumnsWithDefault    = []string{"id", "is_configured"}
+	fileAllColumns            = []string{"id", "path", "name", "content", "is_configured", "last_modified", "checksum", "source", "error", "format"}
+	fileColumnsWithoutDefault = []string{"path", "name", "content", "last_modified", "checksum", "source", "error"}
+	fileColumnsWithDefault    = []string{"id", "is_configured", "format"}
 	filePrimaryKeyColumns     = []string{"id"}
 )
 
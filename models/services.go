@@ -32,6 +32,19 @@ type Service struct {
 	IsSSL           bool             `boil:"is_ssl" json:"is_ssl" toml:"is_ssl" yaml:"is_ssl"`
 	HTTPSConfigured null.Time        `boil:"https_configured" json:"https_configured,omitempty" toml:"https_configured" yaml:"https_configured,omitempty"`
 	LastModified    time.Time        `boil:"last_modified" json:"last_modified" toml:"last_modified" yaml:"last_modified"`
+	LastError       null.String      `boil:"last_error" json:"last_error,omitempty" toml:"last_error" yaml:"last_error,omitempty"`
+	CertNotAfter    null.Time        `boil:"cert_not_after" json:"cert_not_after,omitempty" toml:"cert_not_after" yaml:"cert_not_after,omitempty"`
+	CertIssuer      null.String      `boil:"cert_issuer" json:"cert_issuer,omitempty" toml:"cert_issuer" yaml:"cert_issuer,omitempty"`
+	CertSans        null.String      `boil:"cert_sans" json:"cert_sans,omitempty" toml:"cert_sans" yaml:"cert_sans,omitempty"`
+	CertFingerprint null.String      `boil:"cert_fingerprint" json:"cert_fingerprint,omitempty" toml:"cert_fingerprint" yaml:"cert_fingerprint,omitempty"`
+	CertPath        null.String      `boil:"cert_path" json:"cert_path,omitempty" toml:"cert_path" yaml:"cert_path,omitempty"`
+	KeyPath         null.String      `boil:"key_path" json:"key_path,omitempty" toml:"key_path" yaml:"key_path,omitempty"`
+	AcmeDirectory   null.String      `boil:"acme_directory" json:"acme_directory,omitempty" toml:"acme_directory" yaml:"acme_directory,omitempty"`
+	KeyType         null.String      `boil:"key_type" json:"key_type,omitempty" toml:"key_type" yaml:"key_type,omitempty"`
+	CertFallback    bool             `boil:"cert_fallback" json:"cert_fallback" toml:"cert_fallback" yaml:"cert_fallback"`
+	CertFailures    int64            `boil:"cert_failures" json:"cert_failures" toml:"cert_failures" yaml:"cert_failures"`
+	CertRetryAt     null.Time        `boil:"cert_retry_at" json:"cert_retry_at,omitempty" toml:"cert_retry_at" yaml:"cert_retry_at,omitempty"`
+	RenewRequested  bool             `boil:"renew_requested" json:"renew_requested" toml:"renew_requested" yaml:"renew_requested"`
 
 	R *serviceR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L serviceL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -46,6 +59,19 @@ var ServiceColumns = struct {
 	IsSSL           string
 	HTTPSConfigured string
 	LastModified    string
+	LastError       string
+	CertNotAfter    string
+	CertIssuer      string
+	CertSans        string
+	CertFingerprint string
+	CertPath        string
+	KeyPath         string
+	AcmeDirectory   string
+	KeyType         string
+	CertFallback    string
+	CertFailures    string
+	CertRetryAt     string
+	RenewRequested  string
 }{
 	ID:              "id",
 	FileID:          "file_id",
@@ -55,6 +81,19 @@ var ServiceColumns = struct {
 	IsSSL:           "is_ssl",
 	HTTPSConfigured: "https_configured",
 	LastModified:    "last_modified",
+	LastError:       "last_error",
+	CertNotAfter:    "cert_not_after",
+	CertIssuer:      "cert_issuer",
+	CertSans:        "cert_sans",
+	CertFingerprint: "cert_fingerprint",
+	CertPath:        "cert_path",
+	KeyPath:         "key_path",
+	AcmeDirectory:   "acme_directory",
+	KeyType:         "key_type",
+	CertFallback:    "cert_fallback",
+	CertFailures:    "cert_failures",
+	CertRetryAt:     "cert_retry_at",
+	RenewRequested:  "renew_requested",
 }
 
 // Generated where
@@ -103,6 +142,29 @@ func (w whereHelpernull_Time) GTE(x null.Time) qm.QueryMod {
 	return qmhelper.Where(w.field, qmhelper.GTE, x)
 }
 
+type whereHelpernull_String struct{ field string }
+
+func (w whereHelpernull_String) EQ(x null.String) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, false, x)
+}
+func (w whereHelpernull_String) NEQ(x null.String) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, true, x)
+}
+func (w whereHelpernull_String) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
+func (w whereHelpernull_String) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
+func (w whereHelpernull_String) LT(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpernull_String) LTE(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpernull_String) GT(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpernull_String) GTE(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+
 var ServiceWhere = struct {
 	ID              whereHelperint64
 	FileID          whereHelpernull_Int64
@@ -112,6 +174,19 @@ var ServiceWhere = struct {
 	IsSSL           whereHelperbool
 	HTTPSConfigured whereHelpernull_Time
 	LastModified    whereHelpertime_Time
+	LastError       whereHelpernull_String
+	CertNotAfter    whereHelpernull_Time
+	CertIssuer      whereHelpernull_String
+	CertSans        whereHelpernull_String
+	CertFingerprint whereHelpernull_String
+	CertPath        whereHelpernull_String
+	KeyPath         whereHelpernull_String
+	AcmeDirectory   whereHelpernull_String
+	KeyType         whereHelpernull_String
+	CertFallback    whereHelperbool
+	CertFailures    whereHelperint64
+	CertRetryAt     whereHelpernull_Time
+	RenewRequested  whereHelperbool
 }{
 	ID:              whereHelperint64{field: "\"services\".\"id\""},
 	FileID:          whereHelpernull_Int64{field: "\"services\".\"file_id\""},
@@ -121,21 +196,37 @@ var ServiceWhere = struct {
 	IsSSL:           whereHelperbool{field: "\"services\".\"is_ssl\""},
 	HTTPSConfigured: whereHelpernull_Time{field: "\"services\".\"https_configured\""},
 	LastModified:    whereHelpertime_Time{field: "\"services\".\"last_modified\""},
+	LastError:       whereHelpernull_String{field: "\"services\".\"last_error\""},
+	CertNotAfter:    whereHelpernull_Time{field: "\"services\".\"cert_not_after\""},
+	CertIssuer:      whereHelpernull_String{field: "\"services\".\"cert_issuer\""},
+	CertSans:        whereHelpernull_String{field: "\"services\".\"cert_sans\""},
+	CertFingerprint: whereHelpernull_String{field: "\"services\".\"cert_fingerprint\""},
+	CertPath:        whereHelpernull_String{field: "\"services\".\"cert_path\""},
+	KeyPath:         whereHelpernull_String{field: "\"services\".\"key_path\""},
+	AcmeDirectory:   whereHelpernull_String{field: "\"services\".\"acme_directory\""},
+	KeyType:         whereHelpernull_String{field: "\"services\".\"key_type\""},
+	CertFallback:    whereHelperbool{field: "\"services\".\"cert_fallback\""},
+	CertFailures:    whereHelperint64{field: "\"services\".\"cert_failures\""},
+	CertRetryAt:     whereHelpernull_Time{field: "\"services\".\"cert_retry_at\""},
+	RenewRequested:  whereHelperbool{field: "\"services\".\"renew_requested\""},
 }
 
 // ServiceRels is where relationship names are stored.
 var ServiceRels = struct {
-	File         string
-	NginxConfigs string
+	File           string
+	NginxConfigs   string
+	UpstreamChecks string
 }{
-	File:         "File",
-	NginxConfigs: "NginxConfigs",
+	File:           "File",
+	NginxConfigs:   "NginxConfigs",
+	UpstreamChecks: "UpstreamChecks",
 }
 
 // serviceR is where relationships are stored.
 type serviceR struct {
-	File         *File            `boil:"File" json:"File" toml:"File" yaml:"File"`
-	NginxConfigs NginxConfigSlice `boil:"NginxConfigs" json:"NginxConfigs" toml:"NginxConfigs" yaml:"NginxConfigs"`
+	File           *File              `boil:"File" json:"File" toml:"File" yaml:"File"`
+	NginxConfigs   NginxConfigSlice   `boil:"NginxConfigs" json:"NginxConfigs" toml:"NginxConfigs" yaml:"NginxConfigs"`
+	UpstreamChecks UpstreamCheckSlice `boil:"UpstreamChecks" json:"UpstreamChecks" toml:"UpstreamChecks" yaml:"UpstreamChecks"`
 }
 
 // NewStruct creates a new relationship struct
@@ -147,9 +238,9 @@ func (*serviceR) NewStruct() *serviceR {
 type serviceL struct{}
 
 var (
-	serviceAllColumns            = []string{"id", "file_id", "name", "content", "state", "is_ssl", "https_configured", "last_modified"}
-	serviceColumnsWithoutDefault = []string{"file_id", "name", "content", "state", "is_ssl", "https_configured", "last_modified"}
-	serviceColumnsWithDefault    = []string{"id"}
+	serviceAllColumns            = []string{"id", "file_id", "name", "content", "state", "is_ssl", "https_configured", "last_modified", "last_error", "cert_not_after", "cert_issuer", "cert_sans", "cert_fingerprint", "cert_path", "key_path", "acme_directory", "key_type", "cert_fallback", "cert_failures", "cert_retry_at"}
+	serviceColumnsWithoutDefault = []string{"file_id", "name", "content", "state", "is_ssl", "https_configured", "last_modified", "last_error", "cert_not_after", "cert_issuer", "cert_sans", "cert_fingerprint", "cert_path", "key_path", "acme_directory", "key_type", "cert_retry_at"}
+	serviceColumnsWithDefault    = []string{"id", "cert_fallback", "cert_failures", "renew_requested"}
 	servicePrimaryKeyColumns     = []string{"id"}
 )
 
@@ -463,6 +554,27 @@ func (o *Service) NginxConfigs(mods ...qm.QueryMod) nginxConfigQuery {
 	return query
 }
 
+// UpstreamChecks retrieves all the upstream_check's UpstreamChecks with an executor.
+func (o *Service) UpstreamChecks(mods ...qm.QueryMod) upstreamCheckQuery {
+	var queryMods []qm.QueryMod
+	if len(mods) != 0 {
+		queryMods = append(queryMods, mods...)
+	}
+
+	queryMods = append(queryMods,
+		qm.Where("\"upstream_checks\".\"service_id\"=?", o.ID),
+	)
+
+	query := UpstreamChecks(queryMods...)
+	queries.SetFrom(query.Query, "\"upstream_checks\"")
+
+	if len(queries.GetSelect(query.Query)) == 0 {
+		queries.SetSelect(query.Query, []string{"\"upstream_checks\".*"})
+	}
+
+	return query
+}
+
 // LoadFile allows an eager lookup of values, cached into the
 // loaded structs of the objects. This is for an N-1 relationship.
 func (serviceL) LoadFile(ctx context.Context, e boil.ContextExecutor, singular bool, maybeService interface{}, mods queries.Applicator) error {
@@ -669,6 +781,104 @@ func (serviceL) LoadNginxConfigs(ctx context.Context, e boil.ContextExecutor, si
 	return nil
 }
 
+// LoadUpstreamChecks allows an eager lookup of values, cached into the
+// loaded structs of the objects. This is for a 1-M or N-M relationship.
+func (serviceL) LoadUpstreamChecks(ctx context.Context, e boil.ContextExecutor, singular bool, maybeService interface{}, mods queries.Applicator) error {
+	var slice []*Service
+	var object *Service
+
+	if singular {
+		object = maybeService.(*Service)
+	} else {
+		slice = *maybeService.(*[]*Service)
+	}
+
+	args := make([]interface{}, 0, 1)
+	if singular {
+		if object.R == nil {
+			object.R = &serviceR{}
+		}
+		args = append(args, object.ID)
+	} else {
+	Outer:
+		for _, obj := range slice {
+			if obj.R == nil {
+				obj.R = &serviceR{}
+			}
+
+			for _, a := range args {
+				if queries.Equal(a, obj.ID) {
+					continue Outer
+				}
+			}
+
+			args = append(args, obj.ID)
+		}
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	query := NewQuery(
+		qm.From(`upstream_checks`),
+		qm.WhereIn(`upstream_checks.service_id in ?`, args...),
+	)
+	if mods != nil {
+		mods.Apply(query)
+	}
+
+	results, err := query.QueryContext(ctx, e)
+	if err != nil {
+		return errors.Wrap(err, "failed to eager load upstream_checks")
+	}
+
+	var resultSlice []*UpstreamCheck
+	if err = queries.Bind(results, &resultSlice); err != nil {
+		return errors.Wrap(err, "failed to bind eager loaded slice upstream_checks")
+	}
+
+	if err = results.Close(); err != nil {
+		return errors.Wrap(err, "failed to close results in eager load on upstream_checks")
+	}
+	if err = results.Err(); err != nil {
+		return errors.Wrap(err, "error occurred during iteration of eager loaded relations for upstream_checks")
+	}
+
+	if len(upstreamCheckAfterSelectHooks) != 0 {
+		for _, obj := range resultSlice {
+			if err := obj.doAfterSelectHooks(ctx, e); err != nil {
+				return err
+			}
+		}
+	}
+	if singular {
+		object.R.UpstreamChecks = resultSlice
+		for _, foreign := range resultSlice {
+			if foreign.R == nil {
+				foreign.R = &upstreamCheckR{}
+			}
+			foreign.R.Service = object
+		}
+		return nil
+	}
+
+	for _, foreign := range resultSlice {
+		for _, local := range slice {
+			if queries.Equal(local.ID, foreign.ServiceID) {
+				local.R.UpstreamChecks = append(local.R.UpstreamChecks, foreign)
+				if foreign.R == nil {
+					foreign.R = &upstreamCheckR{}
+				}
+				foreign.R.Service = local
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
 // SetFile of the service to the related item.
 // Sets o.R.File to related.
 // Adds o to related.R.Services.
@@ -872,6 +1082,129 @@ func (o *Service) RemoveNginxConfigs(ctx context.Context, exec boil.ContextExecu
 	return nil
 }
 
+// AddUpstreamChecks adds the given related objects to the existing relationships
+// of the service, optionally inserting them as new records.
+// Appends related to o.R.UpstreamChecks.
+// Sets related.R.Service appropriately.
+func (o *Service) AddUpstreamChecks(ctx context.Context, exec boil.ContextExecutor, insert bool, related ...*UpstreamCheck) error {
+	var err error
+	for _, rel := range related {
+		if insert {
+			queries.Assign(&rel.ServiceID, o.ID)
+			if err = rel.Insert(ctx, exec, boil.Infer()); err != nil {
+				return errors.Wrap(err, "failed to insert into foreign table")
+			}
+		} else {
+			updateQuery := fmt.Sprintf(
+				"UPDATE \"upstream_checks\" SET %s WHERE %s",
+				strmangle.SetParamNames("\"", "\"", 0, []string{"service_id"}),
+				strmangle.WhereClause("\"", "\"", 0, upstreamCheckPrimaryKeyColumns),
+			)
+			values := []interface{}{o.ID, rel.ID}
+
+			if boil.IsDebug(ctx) {
+				writer := boil.DebugWriterFrom(ctx)
+				fmt.Fprintln(writer, updateQuery)
+				fmt.Fprintln(writer, values)
+			}
+			if _, err = exec.ExecContext(ctx, updateQuery, values...); err != nil {
+				return errors.Wrap(err, "failed to update foreign table")
+			}
+
+			queries.Assign(&rel.ServiceID, o.ID)
+		}
+	}
+
+	if o.R == nil {
+		o.R = &serviceR{
+			UpstreamChecks: related,
+		}
+	} else {
+		o.R.UpstreamChecks = append(o.R.UpstreamChecks, related...)
+	}
+
+	for _, rel := range related {
+		if rel.R == nil {
+			rel.R = &upstreamCheckR{
+				Service: o,
+			}
+		} else {
+			rel.R.Service = o
+		}
+	}
+	return nil
+}
+
+// SetUpstreamChecks removes all previously related items of the
+// service replacing them completely with the passed
+// in related items, optionally inserting them as new records.
+// Sets o.R.Service's UpstreamChecks accordingly.
+// Replaces o.R.UpstreamChecks with related.
+// Sets related.R.Service's UpstreamChecks accordingly.
+func (o *Service) SetUpstreamChecks(ctx context.Context, exec boil.ContextExecutor, insert bool, related ...*UpstreamCheck) error {
+	query := "update \"upstream_checks\" set \"service_id\" = null where \"service_id\" = ?"
+	values := []interface{}{o.ID}
+	if boil.IsDebug(ctx) {
+		writer := boil.DebugWriterFrom(ctx)
+		fmt.Fprintln(writer, query)
+		fmt.Fprintln(writer, values)
+	}
+	_, err := exec.ExecContext(ctx, query, values...)
+	if err != nil {
+		return errors.Wrap(err, "failed to remove relationships before set")
+	}
+
+	if o.R != nil {
+		for _, rel := range o.R.UpstreamChecks {
+			queries.SetScanner(&rel.ServiceID, nil)
+			if rel.R == nil {
+				continue
+			}
+
+			rel.R.Service = nil
+		}
+
+		o.R.UpstreamChecks = nil
+	}
+	return o.AddUpstreamChecks(ctx, exec, insert, related...)
+}
+
+// RemoveUpstreamChecks relationships from objects passed in.
+// Removes related items from R.UpstreamChecks (uses pointer comparison, removal does not keep order)
+// Sets related.R.Service.
+func (o *Service) RemoveUpstreamChecks(ctx context.Context, exec boil.ContextExecutor, related ...*UpstreamCheck) error {
+	var err error
+	for _, rel := range related {
+		queries.SetScanner(&rel.ServiceID, nil)
+		if rel.R != nil {
+			rel.R.Service = nil
+		}
+		if _, err = rel.Update(ctx, exec, boil.Whitelist("service_id")); err != nil {
+			return err
+		}
+	}
+	if o.R == nil {
+		return nil
+	}
+
+	for _, rel := range related {
+		for i, ri := range o.R.UpstreamChecks {
+			if rel != ri {
+				continue
+			}
+
+			ln := len(o.R.UpstreamChecks)
+			if ln > 1 && i < ln-1 {
+				o.R.UpstreamChecks[i] = o.R.UpstreamChecks[ln-1]
+			}
+			o.R.UpstreamChecks = o.R.UpstreamChecks[:ln-1]
+			break
+		}
+	}
+
+	return nil
+}
+
 // Services retrieves all the records using an executor.
 func Services(mods ...qm.QueryMod) serviceQuery {
 	mods = append(mods, qm.From("\"services\""))
@@ -0,0 +1,268 @@
+package workers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// Used as the source of the files created from the admin API
+const apiSource = "api"
+
+// The name is used in the names of the generated files and nginx upstreams
+var definitionName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// The largest definition that is accepted
+const maxDefinitionSize = 1 << 20
+
+// adminDefinition is a service created from the admin API
+type adminDefinition struct {
+	Name         string           `json:"name"`
+	Path         string           `json:"path"`
+	IsConfigured bool             `json:"is_configured"`
+	LastModified time.Time        `json:"last_modified"`
+	Services     []int64          `json:"services"`
+	Config       internal.Service `json:"config"`
+}
+
+func definitionPath(name string) string {
+	return "api://" + name
+}
+
+func (a AdminServer) listDefinitions(w http.ResponseWriter, r *http.Request) {
+	files, err := models.Files(
+		models.FileWhere.Source.EQ(apiSource),
+		qm.Load(models.FileRels.Services),
+		qm.OrderBy(models.FileColumns.Name),
+	).All(r.Context(), a.DB)
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get definitions: %w", err))
+		return
+	}
+
+	resp := make([]adminDefinition, len(files))
+	for i, f := range files {
+		resp[i] = definitionView(f)
+	}
+
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+func (a AdminServer) getDefinition(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.findDefinition(w, r)
+	if !ok {
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, definitionView(f))
+}
+
+// putDefinition creates or replaces the service with the name.
+// It is then configured like a service from a config file
+func (a AdminServer) putDefinition(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !definitionName.MatchString(name) {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf(
+			"invalid name %q. Only letters, digits, '.', '_' and '-' are allowed", name,
+		))
+		return
+	}
+
+	service, status, err := decodeDefinition(r)
+	if err != nil {
+		writeAdminError(w, status, err)
+		return
+	}
+
+	err = errors.Join(service.Validate(), validateDefinition(service))
+	if err != nil {
+		writeAdminError(w, http.StatusUnprocessableEntity, fmt.Errorf("invalid service %q: %w", name, err))
+		return
+	}
+
+	path := definitionPath(name)
+	exists, err := models.Files(models.FileWhere.Path.EQ(path)).Exists(r.Context(), a.DB)
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not check definition %q: %w", name, err))
+		return
+	}
+
+	content := internal.ServiceMap{name: service}
+	_, err = syncVirtualFile(r.Context(), a.DB, apiSource, path, name, content, time.Now().Round(0))
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not save definition %q: %w", name, err))
+		return
+	}
+
+	f, err := models.Files(
+		models.FileWhere.Path.EQ(path),
+		qm.Load(models.FileRels.Services),
+	).One(r.Context(), a.DB)
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get definition %q: %w", name, err))
+		return
+	}
+
+	status = http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+
+	writeAdminJSON(w, status, definitionView(f))
+}
+
+// deleteDefinition removes the service. Its nginx configs are removed with it
+func (a AdminServer) deleteDefinition(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.findDefinition(w, r)
+	if !ok {
+		return
+	}
+
+	err := removeFiles(r.Context(), a.DB, models.FileSlice{f})
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not delete definition %q: %w", f.Name, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findDefinition gets the definition in the path. If it cannot, the error is written
+func (a AdminServer) findDefinition(w http.ResponseWriter, r *http.Request) (*models.File, bool) {
+	name := r.PathValue("name")
+
+	f, err := models.Files(
+		models.FileWhere.Path.EQ(definitionPath(name)),
+		qm.Load(models.FileRels.Services),
+	).One(r.Context(), a.DB)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("no definition named %q", name))
+		return nil, false
+	}
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get definition %q: %w", name, err))
+		return nil, false
+	}
+
+	return f, true
+}
+
+// validateDefinition checks what cannot be set from the API, even though it can
+// be set in a config file. Hooks are executables that would be run, and
+// options that open or close a block would add anything to the nginx config
+func validateDefinition(s internal.Service) error {
+	var errs []error
+
+	if s.LetsEncryptAuthenticator != "" || s.LetsEncryptCleaner != "" {
+		errs = append(errs, errors.New("letsEncryptAuthenticator and letsEncryptCleaner cannot be set from the admin API"))
+	}
+
+	checkOptions := func(field string, options internal.Options) {
+		for key, value := range options {
+			if strings.ContainsAny(key+value, "{}\n") {
+				errs = append(errs, fmt.Errorf("%s %q cannot have '{', '}' or new lines", field, key))
+			}
+		}
+	}
+	checkParameters := func(upstream []internal.UpstreamServer) {
+		for _, u := range upstream {
+			for _, p := range u.Parameters {
+				if strings.ContainsAny(p, "{};\n") {
+					errs = append(errs, fmt.Errorf("parameter %q of upstream %q cannot have '{', '}', ';' or new lines", p, u.Address))
+				}
+			}
+		}
+	}
+
+	checkOptions("upstreamOptions", s.UpstreamOptions)
+	checkOptions("locationOptions", s.LocationOptions)
+	checkOptions("serverOptions", s.ServerOptions)
+	checkParameters(s.Upstream)
+	for _, l := range s.Locations {
+		checkOptions("options", l.Options)
+		checkOptions("upstreamOptions", l.UpstreamOptions)
+		checkParameters(l.Upstream)
+	}
+
+	return errors.Join(errs...)
+}
+
+// The formats definitions can be sent in, by media type
+var definitionFormats = map[string]string{
+	"application/toml":   "toml",
+	"text/toml":          "toml",
+	"text/plain":         "toml",
+	"application/json":   "json",
+	"application/yaml":   "yaml",
+	"application/x-yaml": "yaml",
+	"text/yaml":          "yaml",
+}
+
+// decodeDefinition reads a service from a TOML, JSON or YAML body.
+// It is decoded like a config file, so the same keys and values are accepted.
+// Unknown keys are rejected, since they are most likely typos
+func decodeDefinition(r *http.Request) (internal.Service, int, error) {
+	var service internal.Service
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDefinitionSize+1))
+	if err != nil {
+		return service, http.StatusBadRequest, fmt.Errorf("could not read body: %w", err)
+	}
+	if len(body) > maxDefinitionSize {
+		return service, http.StatusRequestEntityTooLarge, fmt.Errorf("the body is larger than %d bytes", maxDefinitionSize)
+	}
+
+	mediaType := "application/toml"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return service, http.StatusUnsupportedMediaType, fmt.Errorf("invalid Content-Type: %w", err)
+		}
+	}
+
+	format, ok := definitionFormats[mediaType]
+	if !ok {
+		return service, http.StatusUnsupportedMediaType, fmt.Errorf(
+			"unsupported Content-Type %q. Use application/toml, application/json or application/yaml", mediaType,
+		)
+	}
+
+	unknown, err := decodeConfig(body, format, &service)
+	if err != nil {
+		return service, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", strings.ToUpper(format), err)
+	}
+	if len(unknown) > 0 {
+		return service, http.StatusBadRequest, fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+
+	return service, 0, nil
+}
+
+func definitionView(f *models.File) adminDefinition {
+	view := adminDefinition{
+		Name:         f.Name,
+		Path:         f.Path,
+		IsConfigured: f.IsConfigured,
+		LastModified: f.LastModified,
+		Services:     []int64{},
+		Config:       f.Content[f.Name],
+	}
+
+	if f.R != nil {
+		for _, s := range f.R.Services {
+			view.Services = append(view.Services, s.ID)
+		}
+	}
+
+	return view
+}
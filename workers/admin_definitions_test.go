@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeDefinition(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{
+			name: "toml without content type",
+			body: "domains = [\"a.com\"]\n[[upstream]]\naddress = \"a:80\"\nhealthCheck = {timeout = \"5s\"}\n",
+		},
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"domains": ["a.com"], "upstream": [{"address": "a:80", "healthCheck": {"timeout": "5s"}}]}`,
+		},
+		{
+			name:        "json keys without case",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"Domains": ["a.com"], "UPSTREAM": [{"address": "a:80", "healthcheck": {"Timeout": "5s"}}]}`,
+		},
+		{
+			name:        "yaml",
+			contentType: "application/yaml",
+			body:        "domains: [a.com]\nupstream:\n  - address: a:80\n    healthCheck: {timeout: 5s}\n",
+		},
+		{
+			name:        "unknown key in an array of tables",
+			contentType: "application/toml",
+			body:        "domains = [\"a.com\"]\n[[upstream]]\naddress = \"a:80\"\n[upstream.healthCheck]\npth = \"/\"\ntimeout = \"5s\"\n",
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "unknown json key",
+			contentType: "application/json",
+			body:        `{"domain": ["a.com"]}`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        `domains = ["a.com"]`,
+			status:      http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/definitions/a", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			service, status, err := decodeDefinition(r)
+			if status != tt.status {
+				t.Fatalf("got status %d (%v), want %d", status, err, tt.status)
+			}
+			if tt.status != 0 {
+				return
+			}
+
+			if len(service.Domains) != 1 || service.Domains[0] != "a.com" {
+				t.Errorf("got domains %v", service.Domains)
+			}
+			if len(service.Upstream) != 1 || service.Upstream[0].HealthCheck.Timeout != 5*time.Second {
+				t.Errorf("got upstream %+v", service.Upstream)
+			}
+		})
+	}
+}
+
+func TestDefinitionsNeedToken(t *testing.T) {
+	body := "domains = [\"a.com\"]\n[[upstream]]\naddress = \"a:80\"\n"
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		body   string
+		status int
+	}{
+		{name: "put without token", method: http.MethodPut, body: body, status: http.StatusForbidden},
+		{name: "delete without token", method: http.MethodDelete, status: http.StatusForbidden},
+		{
+			name:   "hooks",
+			token:  "secret",
+			method: http.MethodPut,
+			body:   "letsEncryptAuthenticator = \"/bin/sh\"\n" + body,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "options with a block",
+			token:  "secret",
+			method: http.MethodPut,
+			body:   "serverOptions = {\"return\" = \"200; } server { listen 8080\"}\n" + body,
+			status: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := AdminServer{}
+			a.Settings.ADMIN_TOKEN = tt.token
+
+			r := httptest.NewRequest(tt.method, "/definitions/a", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/toml")
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			a.authenticate(a.routes()).ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("got status %d (%s), want %d", w.Code, w.Body, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,498 @@
+package workers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/janus/monitor"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// AdminServer serves a JSON API to inspect and manage the services
+type AdminServer struct {
+	DB       *sql.DB
+	Monitor  monitor.Monitor
+	Settings internal.Settings
+}
+
+func (a AdminServer) Play(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              a.Settings.ADMIN_ADDR,
+		Handler:           a.Monitor.Middleware(a.authenticate(a.routes())),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			err = fmt.Errorf("could not shut down admin server: %w", err)
+			a.Monitor.CaptureException(err, nil)
+		}
+	}()
+
+	slog.Info("admin API listening", "addr", a.Settings.ADMIN_ADDR)
+	if a.Settings.ADMIN_TOKEN == "" {
+		slog.Warn("the admin API is read-only since ADMIN_TOKEN is not set")
+	}
+
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("admin server failed: %w", err)
+	}
+
+	return nil
+}
+
+func (a AdminServer) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /files", a.listFiles)
+	mux.HandleFunc("GET /services", a.listServices)
+	mux.HandleFunc("GET /services/{id}", a.getService)
+	mux.HandleFunc("GET /definitions", a.listDefinitions)
+	mux.HandleFunc("GET /definitions/{name}", a.getDefinition)
+	mux.HandleFunc("GET /deliveries", a.listDeliveries)
+
+	// Anyone who can reach the API could change what is served,
+	// so it is read-only unless requests must have a token
+	write := map[string]http.HandlerFunc{
+		"POST /services/{id}/reconfigure": a.reconfigureService,
+		"POST /services/{id}/renew":       a.renewService,
+		"PUT /definitions/{name}":         a.putDefinition,
+		"POST /definitions/{name}":        a.putDefinition,
+		"DELETE /definitions/{name}":      a.deleteDefinition,
+	}
+	for pattern, handler := range write {
+		if a.Settings.ADMIN_TOKEN == "" {
+			handler = readOnly
+		}
+		mux.HandleFunc(pattern, handler)
+	}
+
+	return mux
+}
+
+func readOnly(w http.ResponseWriter, r *http.Request) {
+	writeAdminError(w, http.StatusForbidden, errors.New("the admin API is read-only since ADMIN_TOKEN is not set"))
+}
+
+// authenticate checks the bearer token if ADMIN_TOKEN is set
+func (a AdminServer) authenticate(next http.Handler) http.Handler {
+	if a.Settings.ADMIN_TOKEN == "" {
+		return next
+	}
+
+	expected := []byte("Bearer " + a.Settings.ADMIN_TOKEN)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(given, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminFile struct {
+	ID           int64       `json:"id"`
+	Path         string      `json:"path"`
+	Name         string      `json:"name"`
+	Source       string      `json:"source"`
+	Format       string      `json:"format"`
+	IsConfigured bool        `json:"is_configured"`
+	LastModified time.Time   `json:"last_modified"`
+	Checksum     string      `json:"checksum"`
+	Error        null.String `json:"error"` // Why the file is not used. Its last valid services are kept
+	Services     []int64     `json:"services"`
+}
+
+type adminService struct {
+	ID              int64                `json:"id"`
+	Name            string               `json:"name"`
+	File            string               `json:"file"`
+	Type            string               `json:"type"`
+	Domains         []string             `json:"domains"`
+	State           string               `json:"state"`
+	IsSSL           bool                 `json:"is_ssl"`
+	HTTPSConfigured null.Time            `json:"https_configured"`
+	LastModified    time.Time            `json:"last_modified"`
+	LastError       null.String          `json:"last_error"`
+	NginxConfigs    []adminNginxConfig   `json:"nginx_configs"`
+	Certificate     *adminCertificate    `json:"certificate"`
+	Upstreams       []adminUpstreamCheck `json:"upstreams"`
+}
+
+type adminNginxConfig struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type adminCertificate struct {
+	Source         string      `json:"source"`
+	Shared         string      `json:"shared,omitempty"` // The name of the shared certificate used
+	CertPath       null.String `json:"cert_path"`
+	KeyPath        null.String `json:"key_path"`
+	NotAfter       null.Time   `json:"not_after"`
+	Issuer         null.String `json:"issuer"`
+	SANs           []string    `json:"sans"`
+	Fingerprint    null.String `json:"fingerprint"`
+	KeyType        null.String `json:"key_type"`
+	AcmeDirectory  null.String `json:"acme_directory"`
+	Fallback       bool        `json:"fallback"`
+	Failures       int64       `json:"failures"`
+	NextRetry      null.Time   `json:"next_retry"`
+	RenewRequested bool        `json:"renew_requested"`
+}
+
+type adminUpstreamCheck struct {
+	Address   string      `json:"address"`
+	IsHealthy bool        `json:"is_healthy"`
+	Error     null.String `json:"error"`
+	CheckedAt time.Time   `json:"checked_at"`
+}
+
+type adminDelivery struct {
+	ID            int64       `json:"id"`
+	Service       string      `json:"service"`
+	Event         string      `json:"event"`
+	URL           string      `json:"url"`
+	State         string      `json:"state"`
+	Attempts      int64       `json:"attempts"`
+	ResponseCode  null.Int64  `json:"response_code"`
+	LastError     null.String `json:"last_error"`
+	NextAttemptAt null.Time   `json:"next_attempt_at"`
+	CreatedAt     time.Time   `json:"created_at"`
+	DeliveredAt   null.Time   `json:"delivered_at"`
+}
+
+// The most deliveries returned by GET /deliveries
+const adminDeliveriesLimit = 100
+
+func (a AdminServer) listFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := models.Files(
+		qm.Load(models.FileRels.Services),
+		qm.OrderBy(models.FileColumns.Path),
+	).All(r.Context(), a.DB)
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get files: %w", err))
+		return
+	}
+
+	resp := make([]adminFile, len(files))
+	for i, f := range files {
+		resp[i] = adminFile{
+			ID:           f.ID,
+			Path:         f.Path,
+			Name:         f.Name,
+			Source:       f.Source,
+			Format:       f.Format,
+			IsConfigured: f.IsConfigured,
+			LastModified: f.LastModified,
+			Checksum:     f.Checksum,
+			Error:        f.Error,
+			Services:     []int64{},
+		}
+		if f.R != nil {
+			for _, s := range f.R.Services {
+				resp[i].Services = append(resp[i].Services, s.ID)
+			}
+		}
+	}
+
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+func (a AdminServer) listServices(w http.ResponseWriter, r *http.Request) {
+	services, err := models.Services(
+		qm.Load(models.ServiceRels.File),
+		qm.Load(models.ServiceRels.NginxConfigs),
+		qm.OrderBy(models.ServiceColumns.ID),
+	).All(r.Context(), a.DB)
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get services: %w", err))
+		return
+	}
+
+	resp := make([]adminService, len(services))
+	for i, s := range services {
+		resp[i], err = a.serviceView(r.Context(), s)
+		if err != nil {
+			a.serverError(w, err)
+			return
+		}
+	}
+
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+func (a AdminServer) getService(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.findService(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := a.serviceView(r.Context(), s)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+// listDeliveries returns the latest webhook deliveries.
+// They can be filtered by the service and state query parameters
+func (a AdminServer) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	mods := []qm.QueryMod{
+		qm.OrderBy(models.WebhookDeliveryColumns.ID + " DESC"),
+		qm.Limit(adminDeliveriesLimit),
+	}
+	if service := r.URL.Query().Get("service"); service != "" {
+		mods = append(mods, models.WebhookDeliveryWhere.ServiceName.EQ(service))
+	}
+	if state := r.URL.Query().Get("state"); state != "" {
+		mods = append(mods, models.WebhookDeliveryWhere.State.EQ(state))
+	}
+
+	deliveries, err := models.WebhookDeliveries(mods...).All(r.Context(), a.DB)
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get deliveries: %w", err))
+		return
+	}
+
+	resp := make([]adminDelivery, len(deliveries))
+	for i, d := range deliveries {
+		resp[i] = adminDelivery{
+			ID:            d.ID,
+			Service:       d.ServiceName,
+			Event:         d.Event,
+			URL:           d.URL,
+			State:         d.State,
+			Attempts:      d.Attempts,
+			ResponseCode:  d.ResponseCode,
+			LastError:     d.LastError,
+			NextAttemptAt: d.NextAttemptAt,
+			CreatedAt:     d.CreatedAt,
+			DeliveredAt:   d.DeliveredAt,
+		}
+	}
+
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+// reconfigureService generates the nginx configs of the service again.
+// Failed services are also retried
+func (a AdminServer) reconfigureService(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.findService(w, r)
+	if !ok {
+		return
+	}
+
+	s.LastError = null.String{}
+	err := reconfigureService(r.Context(), a.DB, s)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+
+	serviceLogger(s).Info("reconfiguring from admin API")
+	a.respondWithService(w, r, s.ID, http.StatusAccepted)
+}
+
+// renewService gets a new certificate for the service even if it is not due.
+// It also skips any wait after a failure
+func (a AdminServer) renewService(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.findService(w, r)
+	if !ok {
+		return
+	}
+
+	switch {
+	case !s.IsSSL:
+		writeAdminError(w, http.StatusConflict, fmt.Errorf("%q does not use https", s.Name))
+		return
+	case s.Content.Certificate != "":
+		writeAdminError(w, http.StatusConflict, fmt.Errorf(
+			"%q uses the shared certificate %q. Renew that instead", s.Name, s.Content.Certificate,
+		))
+		return
+	case s.Content.SslSource == "manual":
+		writeAdminError(w, http.StatusConflict, fmt.Errorf("%q uses a manual certificate", s.Name))
+		return
+	case s.State == internal.StateFailed || s.State == internal.StateNotConfigured:
+		writeAdminError(w, http.StatusConflict, fmt.Errorf("%q is %s", s.Name, s.State))
+		return
+	}
+
+	s.RenewRequested = true
+	s.CertRetryAt = null.Time{}
+
+	_, err := s.Update(r.Context(), a.DB, boil.Whitelist(
+		models.ServiceColumns.RenewRequested,
+		models.ServiceColumns.CertRetryAt,
+	))
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not update service %q: %w", s.Name, err))
+		return
+	}
+
+	serviceLogger(s).Info("renewal requested from admin API")
+	a.respondWithService(w, r, s.ID, http.StatusAccepted)
+}
+
+// findService gets the service in the path. If it cannot, the error is written
+func (a AdminServer) findService(w http.ResponseWriter, r *http.Request) (*models.Service, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid service ID %q", r.PathValue("id")))
+		return nil, false
+	}
+
+	s, err := models.Services(
+		models.ServiceWhere.ID.EQ(id),
+		qm.Load(models.ServiceRels.File),
+		qm.Load(models.ServiceRels.NginxConfigs),
+	).One(r.Context(), a.DB)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("no service with ID %d", id))
+		return nil, false
+	}
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get service %d: %w", id, err))
+		return nil, false
+	}
+
+	return s, true
+}
+
+// respondWithService writes the current state of the service after an action
+func (a AdminServer) respondWithService(w http.ResponseWriter, r *http.Request, id int64, status int) {
+	s, err := models.Services(
+		models.ServiceWhere.ID.EQ(id),
+		qm.Load(models.ServiceRels.File),
+		qm.Load(models.ServiceRels.NginxConfigs),
+	).One(r.Context(), a.DB)
+	if err != nil {
+		a.serverError(w, fmt.Errorf("could not get service %d: %w", id, err))
+		return
+	}
+
+	resp, err := a.serviceView(r.Context(), s)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+
+	writeAdminJSON(w, status, resp)
+}
+
+func (a AdminServer) serviceView(ctx context.Context, s *models.Service) (adminService, error) {
+	view := adminService{
+		ID:              s.ID,
+		Name:            s.Name,
+		Type:            strings.ToLower(s.Content.Type),
+		Domains:         s.Content.Domains,
+		State:           s.State,
+		IsSSL:           s.IsSSL,
+		HTTPSConfigured: s.HTTPSConfigured,
+		LastModified:    s.LastModified,
+		LastError:       s.LastError,
+		NginxConfigs:    []adminNginxConfig{},
+		Upstreams:       []adminUpstreamCheck{},
+	}
+
+	if view.Type == "" {
+		view.Type = "http"
+	}
+
+	if s.R != nil && s.R.File != nil {
+		view.File = s.R.File.Path
+	}
+
+	if s.R != nil {
+		for _, ngf := range s.R.NginxConfigs {
+			view.NginxConfigs = append(view.NginxConfigs, adminNginxConfig{Type: ngf.Type, Path: ngf.Path})
+		}
+	}
+
+	if s.IsSSL {
+		view.Certificate = &adminCertificate{
+			Source:         s.Content.SslSource,
+			Shared:         s.Content.Certificate,
+			CertPath:       s.CertPath,
+			KeyPath:        s.KeyPath,
+			NotAfter:       s.CertNotAfter,
+			Issuer:         s.CertIssuer,
+			SANs:           []string{},
+			Fingerprint:    s.CertFingerprint,
+			KeyType:        s.KeyType,
+			AcmeDirectory:  s.AcmeDirectory,
+			Fallback:       s.CertFallback,
+			Failures:       s.CertFailures,
+			NextRetry:      s.CertRetryAt,
+			RenewRequested: s.RenewRequested,
+		}
+		if s.CertSans.String != "" {
+			view.Certificate.SANs = strings.Split(s.CertSans.String, ",")
+		}
+	}
+
+	latest, err := latestUpstreamChecks(ctx, a.DB, s.ID)
+	if err != nil {
+		return view, fmt.Errorf("could not get upstream checks for %q: %w", s.Name, err)
+	}
+
+	for _, check := range latest {
+		view.Upstreams = append(view.Upstreams, adminUpstreamCheck{
+			Address:   check.Address,
+			IsHealthy: check.IsHealthy,
+			Error:     check.Error,
+			CheckedAt: check.CheckedAt,
+		})
+	}
+	sort.Slice(view.Upstreams, func(i, j int) bool {
+		return view.Upstreams[i].Address < view.Upstreams[j].Address
+	})
+
+	return view, nil
+}
+
+func (a AdminServer) serverError(w http.ResponseWriter, err error) {
+	a.Monitor.CaptureException(err, nil)
+	writeAdminError(w, http.StatusInternalServerError, err)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
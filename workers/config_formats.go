@@ -0,0 +1,288 @@
+package workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stephenafamo/warden/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// The formats config files can be written in, by extension
+var configFormats = map[string]string{
+	".toml": "toml",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".json": "json",
+}
+
+// configFormat gets the format of a config file from its extension.
+// It is empty if the file is not a config file
+func configFormat(path string) string {
+	return configFormats[filepath.Ext(path)]
+}
+
+// givenFileFormat is the format of a file that was given explicitly
+// instead of found in a directory. Files with other extensions are TOML
+func givenFileFormat(path string) string {
+	if format := configFormat(path); format != "" {
+		return format
+	}
+
+	return "toml"
+}
+
+// decodeServices decodes the services in a config file.
+// It also returns the keys that are not used, e.g. typos
+func decodeServices(raw []byte, format string) (internal.ServiceMap, []string, error) {
+	var configs internal.ServiceMap
+	unknown, err := decodeConfig(raw, format, &configs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not decode file: %w", err)
+	}
+
+	return configs, unknown, nil
+}
+
+// decodeConfig decodes a TOML, YAML or JSON document into v, which must be a pointer.
+// It also returns the keys that are not used, e.g. typos
+//
+// YAML and JSON documents are converted to TOML first, so that every format
+// has the same keys (matched without case), values (e.g. durations as "5s")
+// and unknown keys
+func decodeConfig(raw []byte, format string, v any) ([]string, error) {
+	doc := string(raw)
+
+	if format == "yaml" || format == "json" {
+		converted, err := convertToTOML(raw, format)
+		if err != nil {
+			return nil, err
+		}
+		doc = converted
+	}
+
+	_, err := toml.Decode(doc, v)
+	if err != nil {
+		if doc != string(raw) {
+			// The lines are of the converted document
+			err = withoutLine(err)
+		}
+		return nil, err
+	}
+
+	var tree map[string]any
+	_, err = toml.Decode(doc, &tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return unknownKeys(tree, reflect.TypeOf(v).Elem(), ""), nil
+}
+
+// unknownKeys finds the keys in a decoded document that are not fields of typ.
+// Fields are matched without case, like the TOML decoder does.
+// MetaData.Undecoded is not used since it can report the wrong keys
+// for tables in arrays of tables
+func unknownKeys(value any, typ reflect.Type, key string) []string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	var unknown []string
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil // the decoder reports the wrong type
+		}
+
+		for _, k := range sortedKeys(table) {
+			field, ok := tomlField(typ, k)
+			if !ok {
+				unknown = append(unknown, joinKey(key, k))
+				continue
+			}
+			unknown = append(unknown, unknownKeys(table[k], field.Type, joinKey(key, k))...)
+		}
+
+	case reflect.Map:
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+
+		for _, k := range sortedKeys(table) {
+			unknown = append(unknown, unknownKeys(table[k], typ.Elem(), joinKey(key, k))...)
+		}
+
+	case reflect.Slice, reflect.Array:
+		switch array := value.(type) {
+		case []any:
+			for _, v := range array {
+				unknown = append(unknown, unknownKeys(v, typ.Elem(), key)...)
+			}
+		case []map[string]any:
+			for _, v := range array {
+				unknown = append(unknown, unknownKeys(v, typ.Elem(), key)...)
+			}
+		}
+	}
+
+	// The same key can be in more than one table of an array
+	slices.Sort(unknown)
+	return slices.Compact(unknown)
+}
+
+// tomlField finds the field the TOML decoder uses for the key
+func tomlField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	var found reflect.StructField
+	ok := false
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		if tag, _, _ := strings.Cut(field.Tag.Get("toml"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		if name == key {
+			return field, true
+		}
+		if !ok && strings.EqualFold(name, key) {
+			found, ok = field, true
+		}
+	}
+
+	return found, ok
+}
+
+func sortedKeys(table map[string]any) []string {
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinKey(key, k string) string {
+	if key == "" {
+		return k
+	}
+	return key + "." + k
+}
+
+// convertToTOML encodes a YAML or JSON document as TOML
+func convertToTOML(raw []byte, format string) (string, error) {
+	var doc any
+
+	switch format {
+	case "yaml":
+		err := yaml.Unmarshal(raw, &doc)
+		if err != nil {
+			return "", err
+		}
+
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		err := dec.Decode(&doc)
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := bytes.Count(raw[:syntaxErr.Offset], []byte("\n")) + 1
+			return "", fmt.Errorf("json: line %d: %s", line, syntaxErr)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if doc == nil {
+		return "", nil
+	}
+
+	services, ok := tomlValue(doc).(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("%s: the services must be an object, got %T", format, doc)
+	}
+
+	buf := &bytes.Buffer{}
+	err := toml.NewEncoder(buf).Encode(services)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// tomlValue converts a decoded YAML or JSON value to one that can be encoded
+// as TOML. There is no null in TOML, so null values are left out like missing keys
+func tomlValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, val := range v {
+			if val != nil {
+				m[key] = tomlValue(val)
+			}
+		}
+		return m
+
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, val := range v {
+			if val != nil {
+				m[fmt.Sprint(key)] = tomlValue(val)
+			}
+		}
+		return m
+
+	case []any:
+		s := make([]any, 0, len(v))
+		for _, val := range v {
+			if val != nil {
+				s = append(s, tomlValue(val))
+			}
+		}
+		return s
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+
+	default:
+		return v
+	}
+}
+
+// withoutLine removes the line from a decoding error, keeping the key
+func withoutLine(err error) error {
+	match := decodeLineErr.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+
+	if match[2] == "" {
+		return errors.New(match[3])
+	}
+
+	return fmt.Errorf("%s: %s", match[2], match[3])
+}
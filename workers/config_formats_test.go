@@ -0,0 +1,307 @@
+package workers
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+func TestDecodeServicesFormats(t *testing.T) {
+	want := internal.ServiceMap{
+		"web": {
+			Domains:   []string{"a.com"},
+			Ssl:       true,
+			HttpsOnly: true,
+			Port:      8080,
+			Upstream: []internal.UpstreamServer{{
+				Address:     "a:80",
+				HealthCheck: internal.HealthCheck{Type: "http", Timeout: 5 * time.Second, ExpectedStatus: 204},
+			}},
+			Locations: []internal.Location{{
+				Match:   "/b",
+				Options: internal.Options{"proxy_read_timeout": "60s"},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		raw     string
+		unknown []string
+	}{
+		{
+			name:   "toml",
+			format: "toml",
+			raw: `[web]
+domains = ["a.com"]
+ssl = true
+httpsOnly = true
+port = 8080
+
+[[web.upstream]]
+address = "a:80"
+healthCheck = {type = "http", timeout = "5s", expectedStatus = 204}
+
+[[web.locations]]
+match = "/b"
+options = {proxy_read_timeout = "60s"}
+`,
+		},
+		{
+			name:   "yaml",
+			format: "yaml",
+			raw: `web:
+  domains: [a.com]
+  ssl: true
+  httpsOnly: true
+  port: 8080
+  upstream:
+    - address: a:80
+      healthCheck: {type: http, timeout: 5s, expectedStatus: 204}
+  locations:
+    - match: /b
+      options:
+        proxy_read_timeout: 60s
+`,
+		},
+		{
+			name:   "json",
+			format: "json",
+			raw: `{"web": {
+				"Domains": ["a.com"], "SSL": true, "httpsonly": true, "port": 8080,
+				"upstream": [{"address": "a:80", "healthCheck": {"type": "http", "timeout": "5s", "expectedStatus": 204}}],
+				"locations": [{"match": "/b", "options": {"proxy_read_timeout": "60s"}}]
+			}}`,
+		},
+		{
+			name:   "yaml nulls are left out",
+			format: "yaml",
+			raw: `web:
+  domains: [a.com, null]
+  ssl: true
+  httpsOnly: true
+  port: 8080
+  sslSource: null
+  upstream:
+    - address: a:80
+      parameters: ~
+      healthCheck: {type: http, timeout: 5s, expectedStatus: 204}
+  locations:
+    - match: /b
+      options:
+        proxy_read_timeout: 60s
+        proxy_buffering: null
+`,
+		},
+		{
+			name:   "json nulls are left out",
+			format: "json",
+			raw: `{"web": {
+				"domains": ["a.com"], "ssl": true, "httpsOnly": true, "port": 8080, "certPath": null,
+				"upstream": [{"address": "a:80", "healthCheck": {"type": "http", "timeout": "5s", "expectedStatus": 204, "path": null}}],
+				"locations": [null, {"match": "/b", "options": {"proxy_read_timeout": "60s"}}]
+			}}`,
+		},
+		{
+			name:   "yaml typos",
+			format: "yaml",
+			raw: `web:
+  domains: [a.com]
+  ssl: true
+  httpsOnly: true
+  port: 8080
+  sslSourc: letsencrypt
+  upstream:
+    - address: a:80
+      healthCheck: {type: http, timeout: 5s, expectedStatus: 204, pth: /}
+  locations:
+    - match: /b
+      options:
+        proxy_read_timeout: 60s
+`,
+			unknown: []string{"web.sslSourc", "web.upstream.healthCheck.pth"},
+		},
+		{
+			name:   "json typos in nested arrays",
+			format: "json",
+			raw: `{"web": {
+				"domains": ["a.com"], "ssl": true, "httpsOnly": true, "port": 8080,
+				"upstream": [{"address": "a:80", "healthCheck": {"type": "http", "timeout": "5s", "expectedStatus": 204}}],
+				"locations": [{"match": "/b", "options": {"proxy_read_timeout": "60s"}, "upstreams": []}]
+			}}`,
+			unknown: []string{"web.locations.upstreams"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unknown, err := decodeServices([]byte(tt.raw), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got services\n%+v\nwant\n%+v", got, want)
+			}
+			if !slices.Equal(unknown, tt.unknown) {
+				t.Errorf("got unknown keys %q, want %q", unknown, tt.unknown)
+			}
+		})
+	}
+}
+
+func TestDecodeServicesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		raw    string
+		line   int
+		want   string
+	}{
+		{
+			name:   "yaml syntax",
+			format: "yaml",
+			raw:    "web:\n  ssl: true\n  port: 80\n port: 1\n",
+			line:   3,
+		},
+		{
+			name:   "json syntax",
+			format: "json",
+			raw:    "{\"web\": {\n\"domains\": [\"a.com\"],\n}}",
+			line:   3,
+		},
+		{
+			name:   "json not an object",
+			format: "json",
+			raw:    `["web"]`,
+			want:   "the services must be an object",
+		},
+		{
+			name:   "wrong type without the line of the converted document",
+			format: "json",
+			raw:    `{"web": {"port": "80"}}`,
+			want:   "web.port",
+		},
+		{
+			name:   "invalid duration",
+			format: "yaml",
+			raw:    "web:\n  upstream:\n    - healthCheck: {timeout: soon}\n",
+			want:   "soon",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeServices([]byte(tt.raw), tt.format)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			d := decodeDiagnostic("file", err)
+			if d.Line != tt.line {
+				t.Errorf("got line %d, want %d: %v", d.Line, tt.line, err)
+			}
+			if !strings.Contains(d.Message, tt.want) {
+				t.Errorf("got message %q, want it to contain %q", d.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertToTOML(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		raw    string
+		want   string
+	}{
+		{name: "empty yaml", format: "yaml", raw: "", want: ""},
+		{name: "json null", format: "json", raw: "null", want: ""},
+		{
+			name:   "numbers",
+			format: "json",
+			raw:    `{"web": {"port": 8080, "weight": 1.5}}`,
+			want:   "[web]\n  port = 8080\n  weight = 1.5\n",
+		},
+		{
+			name:   "yaml keys that are not strings",
+			format: "yaml",
+			raw:    "web:\n  options:\n    1: true\n",
+			want:   "[web]\n  [web.options]\n    1 = true\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertToTOML([]byte(tt.raw), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceLineFormats(t *testing.T) {
+	yamlRaw := []byte(`# The services
+web:
+  domains: [a.com]
+  locations:
+    - match: /a
+    - matc: /b
+"api.v2":
+  domains: [b.com]
+  upstream:
+    - {address: "b:80", weigth: 1}
+`)
+
+	jsonRaw := []byte(`{
+  "web": {
+    "domains": ["a.com"],
+    "locations": [
+      {"match": "/a"},
+      {"matc": "/b"}
+    ]
+  },
+  "api.v2": {
+    "domains": ["b.com"],
+    "upstream": [{"address": "b:80", "weigth": 1}]
+  }
+}`)
+
+	tests := []struct {
+		key      string
+		wantYAML int
+		wantJSON int
+	}{
+		{key: "web", wantYAML: 2, wantJSON: 2},
+		{key: "web.locations.matc", wantYAML: 6, wantJSON: 6},
+		{key: "api.v2", wantYAML: 7, wantJSON: 9},
+		{key: "api.v2.upstream.weigth", wantYAML: 10, wantJSON: 11},
+		{key: "missing.domains", wantYAML: 0, wantJSON: 0},
+	}
+
+	for _, tt := range tests {
+		if got := keyLine(yamlRaw, tt.key); got != tt.wantYAML {
+			t.Errorf("yaml: keyLine(%q) = %d, want %d", tt.key, got, tt.wantYAML)
+		}
+		if got := keyLine(jsonRaw, tt.key); got != tt.wantJSON {
+			t.Errorf("json: keyLine(%q) = %d, want %d", tt.key, got, tt.wantJSON)
+		}
+	}
+
+	// Quoted service names with dots are found by serviceLine
+	if got := serviceLine(yamlRaw, "api.v2"); got != 7 {
+		t.Errorf("yaml: serviceLine(%q) = %d, want 7", "api.v2", got)
+	}
+	if got := serviceLine(jsonRaw, "api.v2"); got != 9 {
+		t.Errorf("json: serviceLine(%q) = %d, want 9", "api.v2", got)
+	}
+}
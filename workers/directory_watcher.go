@@ -2,72 +2,192 @@ package workers
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
 	"github.com/stephenafamo/janus/monitor"
 	"github.com/stephenafamo/kronika"
 	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/metrics"
 	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 )
 
 type FilePathAndInfo struct {
 	os.FileInfo
 	Path string
+	Root string // The config directory the file was found in
 }
 
 type DirectoryWatcher struct {
 	DB       *sql.DB
 	Monitor  monitor.Monitor
 	Settings internal.Settings
+
+	// set in Play. Every directory found while walking is added to it
+	watcher *fsnotify.Watcher
 }
 
 func (d DirectoryWatcher) Play(ctx context.Context) error {
-	for range kronika.Every(ctx, time.Now(), d.Settings.CONFIG_RELOAD_TIME) {
-		err := d.WalkConfigDirectory(context.Background()) // use new context
-		if err != nil {
-			err = fmt.Errorf("error walking config dir: %w", err)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("could not create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	d.watcher = watcher
+
+	// The periodic walk is only a safety net in case we miss some events
+	// The first tick is immediate, so it also does the initial walk
+	resync := kronika.Every(ctx, time.Now(), d.Settings.CONFIG_RESYNC_TIME)
+
+	// Events usually come in bursts, e.g. when an editor saves a file
+	// or a directory is swapped, so we wait for things to settle
+	var debounce <-chan time.Time
+	var firstChange time.Time
+	changed := map[string]bool{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case _, ok := <-resync:
+			if !ok {
+				return nil
+			}
+			d.walk(nil)
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Permission changes do not change the config
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			now := time.Now()
+			if len(changed) == 0 {
+				firstChange = now
+			}
+			changed[event.Name] = true
+			debounce = time.After(debounceDelay(d.Settings, firstChange, now))
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			err = fmt.Errorf("error watching config dir: %w", err)
 			d.Monitor.CaptureException(err, nil)
+
+		case <-debounce:
+			debounce = nil
+			d.walk(changed)
+			changed = map[string]bool{}
 		}
 	}
+}
+
+// debounceDelay is how long to wait after a change before reading the files.
+// The wait is reset by every change, up to CONFIG_WATCH_MAX_DELAY after the first one
+// so that a directory that keeps changing is still read
+func debounceDelay(settings internal.Settings, firstChange, now time.Time) time.Duration {
+	delay := settings.CONFIG_WATCH_DEBOUNCE
+
+	left := firstChange.Add(settings.CONFIG_WATCH_MAX_DELAY).Sub(now)
+	if left < delay {
+		delay = max(left, 0)
+	}
 
-	return nil
+	return delay
+}
+
+func (d DirectoryWatcher) walk(changed map[string]bool) {
+	err := d.WalkConfigDirectory(context.Background(), changed) // use new context
+	if err != nil {
+		err = fmt.Errorf("error walking config dir: %w", err)
+		d.Monitor.CaptureException(err, nil)
+	}
+}
+
+// WalkConfigDirectory syncs the config files in the DB with every directory
+// in CONFIG_DIR. A directory that cannot be walked is skipped so that
+// the files from the other directories are still synced.
+func (d DirectoryWatcher) WalkConfigDirectory(ctx context.Context, changed map[string]bool) error {
+	var errs []error
+
+	for _, root := range filepath.SplitList(d.Settings.CONFIG_DIR) {
+		if root == "" {
+			continue
+		}
+
+		err := d.walkRoot(ctx, root, changed)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", root, err))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
-func (d DirectoryWatcher) WalkConfigDirectory(ctx context.Context) error {
+// walkRoot syncs the config files in the DB with a single config directory.
+// Files are normally only re-read when their modification time changes.
+// Files in changed are re-read and compared by checksum since some edits
+// (e.g. swapping a symlink) keep the modification time. If a path that is not
+// a config file changed, such as a directory, every file is compared.
+func (d DirectoryWatcher) walkRoot(ctx context.Context, root string, changed map[string]bool) error {
 	var wg sync.WaitGroup
 	var filepaths []string
 	var files []FilePathAndInfo
 
-	err := filepath.Walk(
-		d.Settings.CONFIG_DIR,
-		setFilesInfo(&filepaths, &files),
-	)
+	err := walkFiles(root, d.setFilesInfo(root, &filepaths, &files))
 	if err != nil {
+		// Do not delete the files from this directory since we could not
+		// tell which ones are gone. e.g. the volume may be temporarily unavailable
 		return fmt.Errorf("error while walking config directory: %w", err)
 	}
 
+	verifyAll := false
+	for path := range changed {
+		if configFormat(path) == "" {
+			verifyAll = true
+			break
+		}
+	}
+
 	wg.Add(len(files))
 	for _, file := range files {
-		d.checkFile(ctx, file, &wg)
+		d.checkFile(ctx, file, verifyAll || changed[file.Path], &wg)
 	}
 	wg.Wait()
 
-	// Delete all files if there's no filepaths
-	query := models.Files()
+	// Delete all files from this directory if there's no filepaths
+	query := models.Files(models.FileWhere.Source.EQ(root))
 	if len(filepaths) > 0 {
-		query = models.Files(models.FileWhere.Path.NIN(filepaths))
+		query = models.Files(
+			models.FileWhere.Source.EQ(root),
+			models.FileWhere.Path.NIN(filepaths),
+		)
 	}
 
-	_, err = query.DeleteAll(ctx, d.DB)
+	redundant, err := query.All(ctx, d.DB)
+	if err != nil {
+		return fmt.Errorf("error getting redundant filepaths: %w", err)
+	}
+
+	err = removeFiles(ctx, d.DB, redundant)
 	if err != nil {
 		return fmt.Errorf("error deleting redundant filepaths: %w", err)
 	}
@@ -75,21 +195,103 @@ func (d DirectoryWatcher) WalkConfigDirectory(ctx context.Context) error {
 	return nil
 }
 
-func setFilesInfo(filepaths *[]string, files *[]FilePathAndInfo) filepath.WalkFunc {
+// walkFiles walks the directory like filepath.Walk, and also walks the directories
+// that symlinks in it point to. Their files are reported under the path of the symlink.
+// The directory itself may be a symlink, e.g. a mounted volume
+func walkFiles(dir string, walkFn filepath.WalkFunc) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return walkFn(dir, nil, err)
+	}
+
+	return walkDir(dir, realDir, map[string]bool{}, walkFn)
+}
+
+// walkDir walks realDir, reporting its paths under dir.
+// parents are the directories being walked, to stop at a symlink to one of them
+func walkDir(dir, realDir string, parents map[string]bool, walkFn filepath.WalkFunc) error {
+	parents[realDir] = true
+	defer delete(parents, realDir)
+
+	return filepath.Walk(realDir, func(path string, info os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(realDir, path)
+		if relErr != nil {
+			return relErr
+		}
+		realPath, path := path, filepath.Join(dir, rel)
+
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return walkFn(path, info, err)
+		}
+
+		// Links to files and broken links are left to walkFn
+		target, err := filepath.EvalSymlinks(realPath)
+		if err != nil {
+			return walkFn(path, info, nil)
+		}
+		targetInfo, err := os.Stat(target)
+		if err != nil || !targetInfo.IsDir() {
+			return walkFn(path, info, nil)
+		}
+
+		if parents[target] {
+			return nil
+		}
+
+		return walkDir(path, target, parents, walkFn)
+	})
+}
+
+func (d DirectoryWatcher) setFilesInfo(root string, filepaths *[]string, files *[]FilePathAndInfo) filepath.WalkFunc {
 	return func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
 		if info.IsDir() {
+			// Skip hidden directories. This also skips the timestamped
+			// directories kubernetes uses for atomic ConfigMap updates.
+			// The name of the path is used since a symlinked directory
+			// has the info of its target
+			if path != root && strings.HasPrefix(filepath.Base(path), ".") {
+				return filepath.SkipDir
+			}
+			d.watch(path)
 			return nil
 		}
-		if filepath.Ext(path) != ".toml" {
+		if configFormat(path) == "" {
 			return nil
 		}
+
+		// Symlinks to files are not followed, so we get the info of the target
+		// to know when it was actually modified
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("could not resolve symlink %q: %w", path, err)
+			}
+		}
+
 		*filepaths = append(*filepaths, path)
-		*files = append(*files, FilePathAndInfo{FileInfo: info, Path: path})
+		*files = append(*files, FilePathAndInfo{FileInfo: info, Path: path, Root: root})
 		return nil
 	}
 }
 
-func (d DirectoryWatcher) checkFile(ctx context.Context, file FilePathAndInfo, wg *sync.WaitGroup) {
+func (d DirectoryWatcher) watch(dir string) {
+	if d.watcher == nil {
+		return
+	}
+
+	// Adding a directory that is already watched is a no-op
+	err := d.watcher.Add(dir)
+	if err != nil {
+		err = fmt.Errorf("could not watch directory %q: %w", dir, err)
+		d.Monitor.CaptureException(err, nil)
+	}
+}
+
+func (d DirectoryWatcher) checkFile(ctx context.Context, file FilePathAndInfo, verify bool, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	oldFile, err := models.Files(models.FileWhere.Path.EQ(file.Path)).One(ctx, d.DB)
@@ -97,36 +299,49 @@ func (d DirectoryWatcher) checkFile(ctx context.Context, file FilePathAndInfo, w
 		err = d.addFile(ctx, file)
 		if err != nil {
 			err = fmt.Errorf("error adding file to DB: %w", err)
-			d.Monitor.CaptureException(err, nil)
+			d.Monitor.CaptureException(err, fileTags(file.Path))
 		}
 		return
 	}
 	if err != nil {
 		err = fmt.Errorf("error getting file from DB: %w", err)
-		d.Monitor.CaptureException(err, nil)
+		d.Monitor.CaptureException(err, fileTags(file.Path))
 		return
 	}
 
-	if file.ModTime().After(oldFile.LastModified) {
-		err = d.updateFile(ctx, oldFile, file)
-		if err != nil {
-			err = fmt.Errorf("error updating file in DB: %w", err)
-			d.Monitor.CaptureException(err, nil)
-			return
-		}
+	if !verify && file.ModTime().Equal(oldFile.LastModified) {
+		return
+	}
+
+	err = d.updateFile(ctx, oldFile, file)
+	if err != nil {
+		err = fmt.Errorf("error updating file in DB: %w", err)
+		d.Monitor.CaptureException(err, fileTags(file.Path))
+		return
 	}
 }
 
 func (d DirectoryWatcher) addFile(ctx context.Context, file FilePathAndInfo) error {
-	content, err := getFileContent(file.Path)
+	content, checksum, err := d.getFileContent(file.Path)
 	if err != nil {
-		return fmt.Errorf("error adding file: %w", err)
+		// Added anyway so that the error is kept until the file is fixed
+		fModel := &models.File{
+			Name:         strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())),
+			Path:         file.Path,
+			Source:       file.Root,
+			Format:       configFormat(file.Path),
+			LastModified: file.ModTime(),
+		}
+		return d.markInvalid(ctx, fModel, err)
 	}
 
 	fModel := models.File{
 		Name:         strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())),
 		Path:         file.Path,
 		Content:      content,
+		Checksum:     checksum,
+		Source:       file.Root,
+		Format:       configFormat(file.Path),
 		LastModified: file.ModTime(),
 		IsConfigured: false,
 	}
@@ -136,34 +351,108 @@ func (d DirectoryWatcher) addFile(ctx context.Context, file FilePathAndInfo) err
 		return fmt.Errorf("error inserting file %d in db: %w", fModel.ID, err)
 	}
 
-	log.Printf("ADDED: %s", file.Path)
+	slog.Info("added file", "file", file.Path)
+	metrics.ConfigFileChanges.Inc(file.Root, "added")
 	return nil
 }
 
 func (d DirectoryWatcher) updateFile(ctx context.Context, oldFile *models.File, file FilePathAndInfo) error {
-	content, err := getFileContent(file.Path)
+	content, checksum, err := d.getFileContent(file.Path)
 	if err != nil {
-		return fmt.Errorf("error updating file: %w", err)
+		return d.markInvalid(ctx, oldFile, err)
+	}
+
+	// The file was touched but not changed, or changed back after an error
+	if checksum == oldFile.Checksum {
+		if !oldFile.Error.Valid {
+			return nil
+		}
+
+		oldFile.Error = null.String{}
+		_, err = oldFile.Update(ctx, d.DB, boil.Whitelist(models.FileColumns.Error))
+		if err != nil {
+			return fmt.Errorf("error updating file %d in db: %w", oldFile.ID, err)
+		}
+		slog.Info("config file is valid again", "file", file.Path)
+		return nil
+	}
+
+	// The services of the file are replaced when the file is newer than them
+	// so the time must move forward even if the edit kept the old mtime.
+	// Without the monotonic clock reading, which the DB would store as part of the time
+	lastModified := file.ModTime()
+	if !lastModified.After(oldFile.LastModified) {
+		lastModified = time.Now().Round(0)
 	}
 
 	oldFile.Content = content
+	oldFile.Checksum = checksum
+	oldFile.Source = file.Root
+	oldFile.Format = configFormat(file.Path)
 	oldFile.IsConfigured = false
-	oldFile.LastModified = file.ModTime()
+	oldFile.LastModified = lastModified
+	oldFile.Error = null.String{}
 
 	_, err = oldFile.Update(ctx, d.DB, boil.Infer())
 	if err != nil {
 		return fmt.Errorf("error updating file %d in db: %w", oldFile.ID, err)
 	}
-	log.Printf("UPDATED: %s", file.Path)
+	slog.Info("updated file", "file", file.Path)
+	metrics.ConfigFileChanges.Inc(file.Root, "updated")
 	return nil
 }
 
-func getFileContent(path string) (internal.ServiceMap, error) {
-	var configs map[string]internal.Service
-	if _, err := toml.DecodeFile(path, &configs); err != nil {
-		err = fmt.Errorf("could not decode file: %w", err)
-		return nil, err
+// markInvalid saves why the file cannot be used. See markFileInvalid
+func (d DirectoryWatcher) markInvalid(ctx context.Context, file *models.File, reason error) error {
+	changed, err := markFileInvalid(ctx, d.DB, file, reason)
+	if err != nil || !changed {
+		return err
+	}
+
+	metrics.ConfigFileErrors.Inc()
+	err = fmt.Errorf("invalid config file %q: %w", file.Path, reason)
+	d.Monitor.CaptureException(err, fileTags(file.Path))
+	return nil
+}
+
+// getFileContent reads and checks the services in a config file.
+// Unknown keys are an error unless CONFIG_UNKNOWN_KEYS is warn
+func (d DirectoryWatcher) getFileContent(path string) (internal.ServiceMap, string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read file: %w", err)
+	}
+
+	sum := sha256.Sum256(raw)
+	checksum := hex.EncodeToString(sum[:])
+
+	configs, unknown, err := decodeServices(raw, configFormat(path))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(unknown) > 0 {
+		if strings.ToLower(d.Settings.CONFIG_UNKNOWN_KEYS) != "warn" {
+			return nil, "", fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+		}
+		slog.Warn("unknown keys in config file", "file", path, "keys", unknown)
+	}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := configs[name].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("service %q: %w", name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, "", err
 	}
 
-	return configs, nil
+	return configs, checksum, nil
 }
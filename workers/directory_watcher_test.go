@@ -0,0 +1,180 @@
+package workers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+)
+
+func TestDebounceDelay(t *testing.T) {
+	settings := internal.Settings{
+		CONFIG_WATCH_DEBOUNCE:  500 * time.Millisecond,
+		CONFIG_WATCH_MAX_DELAY: 5 * time.Second,
+	}
+	first := time.Now()
+
+	tests := []struct {
+		name  string
+		since time.Duration
+		want  time.Duration
+	}{
+		{name: "first change", since: 0, want: 500 * time.Millisecond},
+		{name: "changes keep coming", since: 3 * time.Second, want: 500 * time.Millisecond},
+		{name: "close to the max delay", since: 4800 * time.Millisecond, want: 200 * time.Millisecond},
+		{name: "past the max delay", since: 6 * time.Second, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := debounceDelay(settings, first, first.Add(tt.since))
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeConfigFile saves a config file with one http service
+func writeConfigFile(t *testing.T, path, service string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := "[" + service + "]\ndomains = [\"" + service + ".com\"]\n\n[[" + service + ".upstream]]\naddress = \"" + service + ":80\"\n"
+	err = os.WriteFile(path, []byte(config), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testFilePaths returns the paths of the files saved from the root
+func testFilePaths(t *testing.T, d DirectoryWatcher, root string) []string {
+	t.Helper()
+
+	files, err := models.Files(models.FileWhere.Source.EQ(root)).All(context.Background(), d.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.Error.Valid {
+			t.Errorf("%s: %s", f.Path, f.Error.String)
+		}
+		paths = append(paths, f.Path)
+	}
+	slices.Sort(paths)
+
+	return paths
+}
+
+func TestWalkSymlinkedDirectories(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	shared := t.TempDir()
+
+	writeConfigFile(t, filepath.Join(root, "a.toml"), "a")
+	writeConfigFile(t, filepath.Join(shared, "b.toml"), "b")
+	writeConfigFile(t, filepath.Join(shared, "nested", "c.toml"), "c")
+	writeConfigFile(t, filepath.Join(root, ".hidden", "d.toml"), "d")
+
+	links := map[string]string{
+		filepath.Join(root, "shared"):  shared,
+		filepath.Join(root, ".shared"): shared, // hidden like the directory it points to
+		filepath.Join(shared, "loop"):  root,   // not walked again
+		filepath.Join(root, "e.toml"):  filepath.Join(shared, "b.toml"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := DirectoryWatcher{DB: testDB(t), Monitor: newTestMonitor(t)}
+	err := d.walkRoot(ctx, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(root, "a.toml"),
+		filepath.Join(root, "e.toml"),
+		filepath.Join(root, "shared", "b.toml"),
+		filepath.Join(root, "shared", "nested", "c.toml"),
+	}
+	if got := testFilePaths(t, d, root); !slices.Equal(got, want) {
+		t.Errorf("got files\n%q\nwant\n%q", got, want)
+	}
+
+	// Files removed from the linked directory are removed
+	err = os.Remove(filepath.Join(shared, "nested", "c.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.walkRoot(ctx, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = want[:3]
+	if got := testFilePaths(t, d, root); !slices.Equal(got, want) {
+		t.Errorf("got files after a removal\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestWalkSymlinkedRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	root := filepath.Join(t.TempDir(), "config")
+
+	writeConfigFile(t, filepath.Join(dir, "a.toml"), "a")
+	writeConfigFile(t, filepath.Join(dir, "sub", "b.toml"), "b")
+	err := os.Symlink(dir, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := DirectoryWatcher{DB: testDB(t), Monitor: newTestMonitor(t)}
+	err = d.walkRoot(ctx, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are kept under the root on every walk
+	want := []string{
+		filepath.Join(root, "a.toml"),
+		filepath.Join(root, "sub", "b.toml"),
+	}
+	for i := 1; i <= 2; i++ {
+		if i > 1 {
+			err = d.walkRoot(ctx, root, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := testFilePaths(t, d, root); !slices.Equal(got, want) {
+			t.Errorf("walk %d: got files\n%q\nwant\n%q", i, got, want)
+		}
+	}
+
+	// A missing root is an error, so that its files are not removed
+	err = os.Remove(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.walkRoot(ctx, root, nil)
+	if err == nil {
+		t.Error("got no error for a missing root")
+	}
+	if got := testFilePaths(t, d, root); !slices.Equal(got, want) {
+		t.Errorf("got files after the root was removed\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,335 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/janus/monitor"
+	"github.com/stephenafamo/kronika"
+	"github.com/stephenafamo/warden/docker"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+)
+
+// Used as the source of the files created from containers
+const dockerSource = "docker"
+
+// DockerDiscoverer creates services from the labels of running containers.
+// Each container is stored as a file so it goes through the same
+// steps as services from config files
+type DockerDiscoverer struct {
+	DB       *sql.DB
+	Monitor  monitor.Monitor
+	Settings internal.Settings
+	Client   *docker.Client
+}
+
+func (d DockerDiscoverer) Play(ctx context.Context) error {
+	for range kronika.Every(ctx, time.Now(), d.Settings.CONFIG_RELOAD_TIME) {
+		err := d.SyncContainers(context.Background()) // use new context
+		if err != nil {
+			err = fmt.Errorf("error syncing docker containers: %w", err)
+			d.Monitor.CaptureException(err, nil)
+		}
+	}
+
+	return nil
+}
+
+func (d DockerDiscoverer) SyncContainers(ctx context.Context) error {
+	prefix := d.Settings.DOCKER_LABEL_PREFIX
+
+	containers, err := d.Client.Containers(ctx, prefix+".enable=true")
+	if err != nil {
+		// Do not delete anything since we don't know which containers are gone
+		return fmt.Errorf("could not get containers: %w", err)
+	}
+
+	paths := make([]string, 0, len(containers))
+	for _, container := range containers {
+		path := "docker://" + container.ID
+
+		// Added before translating so a temporary error (e.g. the container
+		// is not yet attached to the network) does not remove the service
+		paths = append(paths, path)
+
+		name, service, err := containerService(prefix, d.Settings.DOCKER_NETWORK, container)
+		if err == nil {
+			err = service.Validate()
+		}
+		if err != nil {
+			// The labels are checked again on every sync, so it is only reported when it changes
+			err = d.markInvalid(ctx, path, container, err)
+			if err != nil {
+				d.Monitor.CaptureException(err, fileTags(path))
+			}
+			continue
+		}
+
+		err = d.syncContainer(ctx, path, name, service, time.Unix(container.Created, 0))
+		if err != nil {
+			err = fmt.Errorf("could not sync container %q: %w", container.ID, err)
+			d.Monitor.CaptureException(err, fileTags(path))
+			continue
+		}
+	}
+
+	query := models.Files(models.FileWhere.Source.EQ(dockerSource))
+	if len(paths) > 0 {
+		query = models.Files(
+			models.FileWhere.Source.EQ(dockerSource),
+			models.FileWhere.Path.NIN(paths),
+		)
+	}
+
+	stopped, err := query.All(ctx, d.DB)
+	if err != nil {
+		return fmt.Errorf("error getting stopped containers: %w", err)
+	}
+
+	err = removeFiles(ctx, d.DB, stopped)
+	if err != nil {
+		return fmt.Errorf("error deleting stopped containers: %w", err)
+	}
+
+	return nil
+}
+
+func (d DockerDiscoverer) syncContainer(ctx context.Context, path, name string, service internal.Service, created time.Time) error {
+	content := internal.ServiceMap{name: service}
+
+	// e.g. the container was restarted and got a new IP, the file is not changed
+	_, err := syncVirtualFile(ctx, d.DB, dockerSource, path, name, content, created)
+	return err
+}
+
+// markInvalid saves why the service of the container cannot be used.
+// Like config files, the service from the last valid labels is kept. See markFileInvalid
+func (d DockerDiscoverer) markInvalid(ctx context.Context, path string, c docker.Container, reason error) error {
+	file, err := models.Files(models.FileWhere.Path.EQ(path)).One(ctx, d.DB)
+	if errors.Is(err, sql.ErrNoRows) {
+		file = &models.File{
+			Name:         c.ID,
+			Path:         path,
+			Source:       dockerSource,
+			LastModified: time.Unix(c.Created, 0),
+		}
+	} else if err != nil {
+		return fmt.Errorf("error getting %q from DB: %w", path, err)
+	}
+
+	changed, err := markFileInvalid(ctx, d.DB, file, reason)
+	if err != nil || !changed {
+		return err
+	}
+
+	err = fmt.Errorf("invalid labels on container %q: %w", c.ID, reason)
+	d.Monitor.CaptureException(err, fileTags(path))
+	return nil
+}
+
+// containerService builds a service from the labels of the container.
+// With the default "warden" prefix, the labels are:
+//
+//	warden.enable=true
+//	warden.name=my-service        # Default is the container name
+//	warden.type=http              # http, tcp or udp
+//	warden.domains=a.com,b.com
+//	warden.port=8080              # The port of the container to proxy to
+//	warden.ports=5000,6000-6010   # tcp and udp: more ports to listen on
+//	warden.network=my-network     # The network to get the container IP from
+//	warden.location=/             # Default is "/" if warden.port is set
+//	warden.ssl=true
+//	warden.sslsource=letsencrypt
+//	warden.httpsonly=true
+//	warden.webhook=https://example.com/hook
+//	warden.webhooksecret=my-secret
+//	warden.locations.<key>.match=/api
+//	warden.locations.<key>.port=9000  # Default is warden.port
+func containerService(prefix, network string, c docker.Container) (string, internal.Service, error) {
+	var service internal.Service
+	var err error
+
+	label := func(key string) string {
+		return strings.TrimSpace(c.Labels[prefix+"."+key])
+	}
+
+	name := label("name")
+	if name == "" && len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	if name == "" {
+		name = c.ID
+	}
+
+	if n := label("network"); n != "" {
+		network = n
+	}
+
+	ip, err := containerIP(c, network)
+	if err != nil {
+		return "", service, err
+	}
+
+	service.Type = label("type")
+	service.Location = label("location")
+	service.SslSource = label("sslsource")
+	service.Certificate = label("certificate")
+	service.Webhook = label("webhook")
+	service.WebhookSecret = label("webhooksecret")
+
+	for _, domain := range strings.Split(label("domains"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			service.Domains = append(service.Domains, domain)
+		}
+	}
+
+	if service.Ssl, err = boolLabel(label("ssl")); err != nil {
+		return "", service, fmt.Errorf("invalid ssl label: %w", err)
+	}
+	if service.HttpsOnly, err = boolLabel(label("httpsonly")); err != nil {
+		return "", service, fmt.Errorf("invalid httpsonly label: %w", err)
+	}
+	if service.Ssl && service.SslSource == "" {
+		service.SslSource = "letsencrypt"
+	}
+
+	switch strings.ToLower(service.Type) {
+	case "tcp", "udp":
+		// The proxy listens on the same port as the container
+		port := label("port")
+		if port == "" {
+			return "", service, fmt.Errorf("no port label for container")
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return "", service, fmt.Errorf("invalid port label %q: %w", port, err)
+		}
+		service.Port = uint(p)
+		service.Upstream = []internal.UpstreamServer{{
+			Address: net.JoinHostPort(ip, port),
+		}}
+
+		for _, ports := range strings.Split(label("ports"), ",") {
+			if ports = strings.TrimSpace(ports); ports != "" {
+				service.Ports = append(service.Ports, ports)
+			}
+		}
+
+		return name, service, nil
+	}
+
+	port := label("port")
+	if port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", service, fmt.Errorf("invalid port label %q: %w", port, err)
+		}
+		service.Upstream = []internal.UpstreamServer{{
+			Address: net.JoinHostPort(ip, port),
+		}}
+		// The top level upstream is only used with a location
+		if service.Location == "" {
+			service.Location = "/"
+		}
+	}
+
+	locations, err := locationLabels(prefix, c.Labels, ip, port)
+	if err != nil {
+		return "", service, err
+	}
+	service.Locations = locations
+
+	if len(service.Upstream) == 0 && len(service.Locations) == 0 {
+		return "", service, fmt.Errorf("no port label for container")
+	}
+
+	return name, service, nil
+}
+
+func locationLabels(prefix string, labels map[string]string, ip, defaultPort string) ([]internal.Location, error) {
+	locPrefix := prefix + ".locations."
+
+	keys := map[string]bool{}
+	for label := range labels {
+		if !strings.HasPrefix(label, locPrefix) {
+			continue
+		}
+		key, _, ok := strings.Cut(strings.TrimPrefix(label, locPrefix), ".")
+		if ok {
+			keys[key] = true
+		}
+	}
+
+	// Sort so that the generated config does not change between syncs
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	locations := make([]internal.Location, 0, len(sorted))
+	for _, key := range sorted {
+		match := strings.TrimSpace(labels[locPrefix+key+".match"])
+		if match == "" {
+			return nil, fmt.Errorf("no match label for location %q", key)
+		}
+
+		port := strings.TrimSpace(labels[locPrefix+key+".port"])
+		if port == "" {
+			port = defaultPort
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port %q for location %q", port, key)
+		}
+
+		locations = append(locations, internal.Location{
+			Match: match,
+			Upstream: []internal.UpstreamServer{{
+				Address: net.JoinHostPort(ip, port),
+			}},
+		})
+	}
+
+	return locations, nil
+}
+
+func containerIP(c docker.Container, network string) (string, error) {
+	networks := c.NetworkSettings.Networks
+
+	if network != "" {
+		n, ok := networks[network]
+		if !ok || n.IPAddress == "" {
+			return "", fmt.Errorf("container has no IP on network %q", network)
+		}
+		return n.IPAddress, nil
+	}
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ip := networks[name].IPAddress; ip != "" {
+			return ip, nil
+		}
+	}
+
+	return "", fmt.Errorf("container has no IP address")
+}
+
+func boolLabel(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(value)
+}
@@ -0,0 +1,201 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stephenafamo/warden/docker"
+	"github.com/stephenafamo/warden/internal"
+)
+
+// fakeDocker serves the containers on a unix socket like the docker daemon
+func fakeDocker(t *testing.T, containers string) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var filters map[string][]string
+		err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		if err != nil {
+			t.Errorf("invalid filters %q: %v", r.URL.Query().Get("filters"), err)
+		}
+		want := map[string][]string{"label": {"warden.enable=true"}, "status": {"running"}}
+		if !reflect.DeepEqual(filters, want) {
+			t.Errorf("got filters %v, want %v", filters, want)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(containers))
+	}))
+	srv.Listener = listener
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return "unix://" + socket
+}
+
+func TestDockerContainerServices(t *testing.T) {
+	host := fakeDocker(t, `[
+		{
+			"Id": "web1",
+			"Names": ["/web"],
+			"Created": 1700000000,
+			"Labels": {
+				"warden.enable": "true",
+				"warden.domains": "a.com, b.com",
+				"warden.port": "8080",
+				"warden.ssl": "true"
+			},
+			"NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.2"}}}
+		},
+		{
+			"Id": "api1",
+			"Names": ["/api-container"],
+			"Labels": {
+				"warden.enable": "true",
+				"warden.name": "api",
+				"warden.network": "backend",
+				"warden.domains": "api.com",
+				"warden.port": "8080",
+				"warden.locations.v2.match": "/v2",
+				"warden.locations.v2.port": "9000"
+			},
+			"NetworkSettings": {"Networks": {
+				"bridge": {"IPAddress": "172.17.0.3"},
+				"backend": {"IPAddress": "10.0.0.3"}
+			}}
+		},
+		{
+			"Id": "noport",
+			"Labels": {"warden.enable": "true", "warden.domains": "c.com"},
+			"NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.4"}}}
+		},
+		{
+			"Id": "baddomain",
+			"Labels": {"warden.enable": "true", "warden.domains": "d.com;", "warden.port": "80"},
+			"NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.5"}}}
+		},
+		{
+			"Id": "db1",
+			"Names": ["/db"],
+			"Labels": {"warden.enable": "true", "warden.type": "tcp", "warden.port": "5432", "warden.ports": "6000-6010, 7000"},
+			"NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.6"}}}
+		},
+		{
+			"Id": "dns1",
+			"Names": ["/dns"],
+			"Labels": {"warden.enable": "true", "warden.type": "udp", "warden.port": "53"},
+			"NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.7"}}}
+		},
+		{
+			"Id": "streamnoport",
+			"Labels": {"warden.enable": "true", "warden.type": "tcp", "warden.ports": "7000"},
+			"NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.8"}}}
+		}
+	]`)
+
+	client, err := docker.NewClient(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := client.Containers(context.Background(), "warden.enable=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 7 {
+		t.Fatalf("got %d containers, want 7", len(containers))
+	}
+
+	tests := []struct {
+		name    string
+		service internal.Service
+		invalid bool
+	}{
+		{
+			name: "web",
+			service: internal.Service{
+				Domains:   []string{"a.com", "b.com"},
+				Location:  "/",
+				Upstream:  []internal.UpstreamServer{{Address: "172.17.0.2:8080"}},
+				Locations: []internal.Location{},
+				Ssl:       true,
+				SslSource: "letsencrypt",
+			},
+		},
+		{
+			name: "api",
+			service: internal.Service{
+				Domains:  []string{"api.com"},
+				Location: "/",
+				Upstream: []internal.UpstreamServer{{Address: "10.0.0.3:8080"}},
+				Locations: []internal.Location{{
+					Match:    "/v2",
+					Upstream: []internal.UpstreamServer{{Address: "10.0.0.3:9000"}},
+				}},
+			},
+		},
+		{name: "noport", invalid: true},
+		{name: "baddomain", invalid: true},
+		{
+			name: "db",
+			service: internal.Service{
+				Type:     "tcp",
+				Port:     5432,
+				Ports:    []string{"6000-6010", "7000"},
+				Upstream: []internal.UpstreamServer{{Address: "172.17.0.6:5432"}},
+			},
+		},
+		{
+			name: "dns",
+			service: internal.Service{
+				Type:     "udp",
+				Port:     53,
+				Upstream: []internal.UpstreamServer{{Address: "172.17.0.7:53"}},
+			},
+		},
+		{name: "streamnoport", invalid: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, service, err := containerService("warden", "", containers[i])
+			if err == nil {
+				err = service.Validate()
+			}
+
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("got service %+v, want an error", service)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name != tt.name {
+				t.Errorf("got name %q, want %q", name, tt.name)
+			}
+			if !reflect.DeepEqual(service, tt.service) {
+				t.Errorf("got service\n%+v\nwant\n%+v", service, tt.service)
+			}
+		})
+	}
+}
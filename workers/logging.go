@@ -0,0 +1,40 @@
+package workers
+
+import (
+	"log/slog"
+	"strconv"
+
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+)
+
+// serviceTags is the context of the service. It is added to the logs
+// of the service, and sent with its errors as tags
+func serviceTags(s *models.Service) map[string]string {
+	tags := map[string]string{
+		"service": s.Name,
+		"state":   s.State,
+	}
+
+	// Not set until it is saved
+	if s.ID != 0 {
+		tags["service_id"] = strconv.FormatInt(s.ID, 10)
+	}
+
+	if s.R != nil && s.R.File != nil {
+		tags["file"] = s.R.File.Path
+		tags["unique"] = uniqueName(s)
+	}
+
+	return tags
+}
+
+// serviceLogger returns a logger with the context of the service
+func serviceLogger(s *models.Service) *slog.Logger {
+	return slog.With(internal.TagAttrs(serviceTags(s))...)
+}
+
+// fileTags is the context of a config file
+func fileTags(path string) map[string]string {
+	return map[string]string{"file": path}
+}
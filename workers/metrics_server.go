@@ -0,0 +1,149 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stephenafamo/janus/monitor"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/metrics"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// MetricsServer serves the metrics in the Prometheus text format on /metrics
+type MetricsServer struct {
+	DB       *sql.DB
+	Monitor  monitor.Monitor
+	Settings internal.Settings
+}
+
+// The gauges from the DB are reset and set on every scrape.
+// This keeps concurrent scrapes from seeing them half set
+var scrapeMu sync.Mutex
+
+func (m MetricsServer) Play(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", m.serveMetrics)
+
+	server := &http.Server{
+		Addr:              m.Settings.METRICS_ADDR,
+		Handler:           m.Monitor.Middleware(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			err = fmt.Errorf("could not shut down metrics server: %w", err)
+			m.Monitor.CaptureException(err, nil)
+		}
+	}()
+
+	slog.Info("metrics server listening", "addr", m.Settings.METRICS_ADDR)
+
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("metrics server failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m MetricsServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+
+	err := m.setStateMetrics(r.Context())
+	if err != nil {
+		err = fmt.Errorf("could not get metrics from the state DB: %w", err)
+		m.Monitor.CaptureException(err, nil)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metrics.Handler().ServeHTTP(w, r)
+}
+
+// setStateMetrics sets the gauges that come from the state DB
+func (m MetricsServer) setStateMetrics(ctx context.Context) error {
+	services, err := models.Services(
+		qm.Load(models.ServiceRels.File),
+	).All(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("could not get services: %w", err)
+	}
+
+	checks, err := models.UpstreamChecks(
+		qm.Where(`"id" IN (SELECT MAX("id") FROM "upstream_checks" GROUP BY "service_id", "address")`),
+		qm.Load(models.UpstreamCheckRels.Service+"."+models.ServiceRels.File),
+	).All(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("could not get upstream checks: %w", err)
+	}
+
+	// Every state is set so that the series do not disappear at 0
+	states := map[string]int{
+		internal.StateNotConfigured:    0,
+		internal.StateToConfigureHttps: 0,
+		internal.StateToDisableHttp:    0,
+		internal.StateConfigured:       0,
+		internal.StateRefreshUpstreams: 0,
+		internal.StateFailed:           0,
+	}
+
+	metrics.CertificateExpiry.Reset()
+	for _, s := range services {
+		states[s.State]++
+
+		if s.CertNotAfter.Valid {
+			metrics.CertificateExpiry.Set(float64(s.CertNotAfter.Time.Unix()), s.Name, serviceFilePath(s))
+		}
+	}
+
+	metrics.Services.Reset()
+	for state, count := range states {
+		metrics.Services.Set(float64(count), state)
+	}
+
+	metrics.UpstreamHealthy.Reset()
+	for _, check := range checks {
+		if check.R == nil || check.R.Service == nil {
+			continue
+		}
+
+		// Only the upstreams of configured services are still checked
+		switch check.R.Service.State {
+		case internal.StateConfigured, internal.StateRefreshUpstreams:
+		default:
+			continue
+		}
+
+		healthy := 0.0
+		if check.IsHealthy {
+			healthy = 1
+		}
+		metrics.UpstreamHealthy.Set(healthy, check.R.Service.Name, serviceFilePath(check.R.Service), check.Address)
+	}
+
+	return nil
+}
+
+func serviceFilePath(s *models.Service) string {
+	if s.R == nil || s.R.File == nil {
+		return ""
+	}
+
+	return s.R.File.Path
+}
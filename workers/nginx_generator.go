@@ -6,23 +6,24 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"net/http"
-	"net/url"
-	"os"
+	"log/slog"
+	"math/rand/v2"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/stephenafamo/janus/monitor"
 	"github.com/stephenafamo/kronika"
+	"github.com/stephenafamo/warden/health"
 	"github.com/stephenafamo/warden/internal"
 	"github.com/stephenafamo/warden/letsencrypt"
+	"github.com/stephenafamo/warden/localca"
+	"github.com/stephenafamo/warden/metrics"
 	"github.com/stephenafamo/warden/models"
 	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
@@ -34,6 +35,10 @@ type NginxGenerator struct {
 	Monitor   monitor.Monitor
 	Settings  internal.Settings
 	Templates *template.Template
+
+	// NginxCommand builds the nginx commands to test and reload the configs.
+	// nginx from the PATH is used if it is not set
+	NginxCommand func(args ...string) *exec.Cmd
 }
 
 func (n NginxGenerator) Play(ctx context.Context) error {
@@ -49,29 +54,50 @@ func (n NginxGenerator) Play(ctx context.Context) error {
 }
 
 func (n NginxGenerator) GenerateNginxConfig(ctx context.Context) error {
-	err := n.deleteStaleConfigs(ctx)
+	err := runStage(ctx, "cleanup", n.deleteStaleConfigs)
 	if err != nil {
 		return fmt.Errorf("could not clean up stale configs: %w", err)
 	}
 
-	err = n.generateBaseConfigs(ctx)
+	err = runStage(ctx, "base", n.generateBaseConfigs)
 	if err != nil {
 		return fmt.Errorf("could not generate base configs: %w", err)
 	}
 
-	err = n.generateHttpsConfigs(ctx)
+	err = runStage(ctx, "https", n.generateHttpsConfigs)
 	if err != nil {
 		return fmt.Errorf("could not generate https configs: %w", err)
 	}
 
-	err = n.generateNoHttpConfigs(ctx)
+	err = runStage(ctx, "no_http", n.generateNoHttpConfigs)
 	if err != nil {
 		return fmt.Errorf("could not generate no-http configs: %w", err)
 	}
 
+	err = runStage(ctx, "upstream_refresh", n.generateUpstreamRefreshes)
+	if err != nil {
+		return fmt.Errorf("could not refresh upstreams: %w", err)
+	}
+
 	return nil
 }
 
+// runStage records how long a stage of the generation took, and if it failed
+func runStage(ctx context.Context, stage string, generate func(context.Context) error) error {
+	start := time.Now()
+	err := generate(ctx)
+
+	duration := time.Since(start)
+	slog.Debug("generated configs", "stage", stage, "duration", duration)
+
+	metrics.ConfigGenerationDuration.Observe(duration.Seconds(), stage)
+	if err != nil {
+		metrics.ConfigGenerationFailures.Inc(stage)
+	}
+
+	return err
+}
+
 func (n NginxGenerator) deleteStaleConfigs(ctx context.Context) error {
 	nginxFiles, err := models.NginxConfigs(
 		models.NginxConfigWhere.ServiceID.IsNull(),
@@ -116,15 +142,17 @@ func (n NginxGenerator) generateBaseConfigs(ctx context.Context) error {
 		return nil
 	}
 
+	stage := newConfigStage()
+
 	wg.Add(len(services))
 	for _, service := range services {
-		go n.generateBaseConfig(ctx, service, &wg)
+		go n.generateBaseConfig(ctx, service, stage, &wg)
 	}
 	wg.Wait()
 
-	err = n.reloadNginx()
+	err = n.applyStage(ctx, stage)
 	if err != nil {
-		return fmt.Errorf("could not reload nginx: %w", err)
+		return fmt.Errorf("could not apply base configs: %w", err)
 	}
 
 	return nil
@@ -135,10 +163,8 @@ func (n NginxGenerator) generateHttpsConfigs(ctx context.Context) error {
 		models.ServiceWhere.State.EQ(internal.StateToConfigureHttps),
 		qm.Or2(
 			qm.Expr(
+				models.ServiceWhere.State.EQ(internal.StateConfigured),
 				models.ServiceWhere.IsSSL.EQ(true),
-				models.ServiceWhere.HTTPSConfigured.LT(
-					null.TimeFrom(time.Now().Add(-n.Settings.HTTPS_VALIDITY)),
-				),
 			),
 		),
 		qm.Load(models.ServiceRels.File),
@@ -151,14 +177,26 @@ func (n NginxGenerator) generateHttpsConfigs(ctx context.Context) error {
 		return nil
 	}
 
+	// Shared certificates first, so that the services using them
+	// pick up a new certificate in the same run
+	sort.SliceStable(services, func(i, j int) bool {
+		return services[i].Content.IsCertificate() && !services[j].Content.IsCertificate()
+	})
+
+	stage := newConfigStage()
+
 	for _, service := range services {
+		if service.State == internal.StateConfigured && !n.needsHttpsRenewal(ctx, service) {
+			continue
+		}
+
 		// Can only ask for one certificate at a time. Must be sequential
-		n.generateHttpsConfig(ctx, service)
+		n.generateHttpsConfig(ctx, service, stage)
 	}
 
-	err = n.reloadNginx()
+	err = n.applyStage(ctx, stage)
 	if err != nil {
-		return fmt.Errorf("could not reload nginx: %w", err)
+		return fmt.Errorf("could not apply https configs: %w", err)
 	}
 
 	return nil
@@ -179,149 +217,367 @@ func (n NginxGenerator) generateNoHttpConfigs(ctx context.Context) error {
 		return nil
 	}
 
+	stage := newConfigStage()
+
 	wg.Add(len(services))
 	for _, service := range services {
-		go n.generateNoHttpConfig(ctx, service, &wg)
+		go n.generateNoHttpConfig(ctx, service, stage, &wg)
 	}
 	wg.Wait()
 
-	err = n.reloadNginx()
+	err = n.applyStage(ctx, stage)
 	if err != nil {
-		return fmt.Errorf("could not reload nginx: %w", err)
+		return fmt.Errorf("could not apply https only configs: %w", err)
 	}
 
 	return nil
 }
 
-func (n NginxGenerator) generateBaseConfig(ctx context.Context, s *models.Service, wg *sync.WaitGroup) {
+func (n NginxGenerator) generateUpstreamRefreshes(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	services, err := models.Services(
+		models.ServiceWhere.State.EQ(internal.StateRefreshUpstreams),
+		qm.Load(models.ServiceRels.File),
+		qm.Load(models.ServiceRels.NginxConfigs),
+	).All(ctx, n.DB)
+	if err != nil {
+		return fmt.Errorf("could not get services to refresh upstreams: %w", err)
+	}
+
+	if len(services) == 0 {
+		return nil
+	}
+
+	stage := newConfigStage()
+
+	wg.Add(len(services))
+	for _, service := range services {
+		go n.generateUpstreamRefresh(ctx, service, stage, &wg)
+	}
+	wg.Wait()
+
+	err = n.applyStage(ctx, stage)
+	if err != nil {
+		return fmt.Errorf("could not apply refreshed upstreams: %w", err)
+	}
+
+	return nil
+}
+
+func (n NginxGenerator) generateBaseConfig(ctx context.Context, s *models.Service, stage *configStage, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	var err error
-	var b bytes.Buffer
+	start := time.Now()
 
 	config, err := n.getFullConfig(s)
 	if err != nil {
 		err = fmt.Errorf("could not get full config: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	configDirectory := ""
-	fileType := ""
-	var configContents []byte
+	// The nginx config files for the service and their contents
+	var ngfs []*models.NginxConfig
+	configContents := map[string][]byte{}
 
-	switch strings.ToLower(config.Type) {
-	case "tcp", "udp", "stream":
-		fileType = "stream"
-		configDirectory = filepath.Join(n.Settings.CONFIG_OUTPUT_DIR, "streams")
-		err = n.Templates.ExecuteTemplate(&b, "streams", config)
+	addConfig := func(fileType, directory, template string) error {
+		var b bytes.Buffer
+		err := n.Templates.ExecuteTemplate(&b, template, config)
 		if err != nil {
-			err = fmt.Errorf("error generating stream base config for %q in %q: %w", s.Name, s.R.File.Path, err)
-			n.Monitor.CaptureException(err, nil)
-			return
+			return fmt.Errorf("error generating %s config for %q in %q: %w", template, s.Name, s.R.File.Path, err)
 		}
-		configContents = b.Bytes()
-	case "http":
-		fileType = "http"
-		configDirectory = filepath.Join(n.Settings.CONFIG_OUTPUT_DIR, "http")
-		err = n.Templates.ExecuteTemplate(&b, "httpBase", config)
+
+		ngf := &models.NginxConfig{
+			Type:         fileType,
+			Path:         filepath.Join(n.Settings.CONFIG_OUTPUT_DIR, directory, config.Unique+".conf"),
+			LastModified: s.LastModified,
+		}
+		ngfs = append(ngfs, ngf)
+		configContents[ngf.Path] = b.Bytes()
+		return nil
+	}
+
+	bases, err := baseTemplates(config)
+	if err != nil {
+		err = fmt.Errorf("%w for %q in %q", err, s.Name, s.R.File.Path)
+	}
+	if err == nil && config.IsCertificate() {
+		// Nothing is proxied, the certificate is obtained with the https configs
+		err = config.Service.Validate()
 		if err != nil {
-			err = fmt.Errorf("error generating http base config for %q in %q: %w", s.Name, s.R.File.Path, err)
-			n.Monitor.CaptureException(err, nil)
-			return
+			err = fmt.Errorf("invalid certificate %q in %q: %w", s.Name, s.R.File.Path, err)
 		}
-		configContents = b.Bytes()
-	default:
-		err = fmt.Errorf("Unknown config type for %q in %q", s.Name, s.R.File.Path)
-		n.Monitor.CaptureException(err, nil)
+	}
+	for _, base := range bases {
+		if err != nil {
+			break
+		}
+		err = addConfig(base.fileType, base.directory, base.template)
+	}
+	if err != nil {
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	ok, unreachableUpstream := n.pingUpstreams(config)
+	err = checkUpstreams(ctx, config.Service)
+	if err != nil {
+		serviceLogger(s).Warn("cannot reach upstream", "error", err)
+		sendServiceEvent(ctx, n.DB, n.Monitor, s, UnreachableUpstream)
+		return
+	}
 
-	if !ok {
-		log.Printf("Cannot reach upstream %q for %q in %q", unreachableUpstream, s.Name, s.R.File.Path)
-		n.sendServiceEvent(s, UnreachableUpstream)
+	// The configs of a reconfigured service are only replaced
+	// once the new ones have been generated
+	oldNgfs, err := s.NginxConfigs().All(ctx, n.DB)
+	if err != nil {
+		err = fmt.Errorf("could not get current nginx configs: %w", err)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	ngf := &models.NginxConfig{
-		Type:         fileType,
-		Path:         filepath.Join(configDirectory, config.Unique+".conf"),
-		LastModified: s.LastModified,
+	// The https config is kept until the https stage replaces it
+	// so that the site is not served over plain http in the meantime
+	needsHttps := strings.ToLower(config.Type) == "http" && config.Ssl || config.IsCertificate()
+	var replaced models.NginxConfigSlice
+	for _, ngf := range oldNgfs {
+		if needsHttps && ngf.Type == "https" {
+			continue
+		}
+		replaced = append(replaced, ngf)
 	}
 
 	// Start transaction
 	tx, err := n.DB.BeginTx(ctx, nil)
 	if err != nil {
 		err = fmt.Errorf("could not begin transaction: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 	defer func() {
 		if err == nil {
 			if commitErr := tx.Commit(); commitErr != nil {
-				n.Monitor.CaptureException(fmt.Errorf("could not commit transaction: %w", commitErr), nil)
-				n.deleteFile(ngf.Path)
+				n.Monitor.CaptureException(fmt.Errorf("could not commit transaction: %w", commitErr), serviceTags(s))
+				n.restoreFiles(stage, ngfs)
+				n.restoreFiles(stage, replaced)
 				return
 			}
-			log.Printf("CONFIGURED BASE FOR: %s", s.Name)
+			serviceLogger(s).Info(
+				"configured base",
+				"from_state", internal.StateNotConfigured,
+				"duration", time.Since(start),
+			)
+			sendServiceEvent(ctx, n.DB, n.Monitor, s, ServiceConfigured)
 		} else {
 			if rollBkErr := tx.Rollback(); rollBkErr != nil {
-				n.Monitor.CaptureException(fmt.Errorf("could not rollback transaction: %w", rollBkErr), nil)
-				n.deleteFile(ngf.Path)
-				return
+				n.Monitor.CaptureException(fmt.Errorf("could not rollback transaction: %w", rollBkErr), serviceTags(s))
 			}
+			// Some of the files may have been written before the error
+			n.restoreFiles(stage, ngfs)
+			n.restoreFiles(stage, replaced)
 		}
 	}()
 
-	err = s.AddNginxConfigs(ctx, tx, true, ngf)
+	_, err = replaced.DeleteAll(ctx, tx)
+	if err != nil {
+		err = fmt.Errorf("could not delete current nginx configs from DB: %w", err)
+		n.Monitor.CaptureException(err, serviceTags(s))
+		return
+	}
+
+	err = s.AddNginxConfigs(ctx, tx, true, ngfs...)
 	if err != nil {
 		err = fmt.Errorf("could not add nginx config to service in DB: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
 	s.State = internal.StateConfigured
-	if strings.ToLower(config.Type) == "http" && config.Ssl {
+	if needsHttps {
 		s.State = internal.StateToConfigureHttps
 	}
 
 	_, err = s.Update(ctx, tx, boil.Infer())
 	if err != nil {
 		err = fmt.Errorf("could not update service in DB: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	// Add the nginx config file and rollback the transaction if there's an error
-	err = os.WriteFile(ngf.Path, configContents, 0o644)
-	if err != nil {
-		err = fmt.Errorf("error writing nginx config file for %q to %q: %w", s.Name, ngf.Path, err)
-		n.Monitor.CaptureException(err, nil)
-		return
+	// Add the nginx config files and rollback the transaction if there's an error
+	for _, ngf := range ngfs {
+		err = stage.write(ngf.Path, configContents[ngf.Path], s)
+		if err != nil {
+			err = fmt.Errorf("error writing nginx config file for %q to %q: %w", s.Name, ngf.Path, err)
+			n.Monitor.CaptureException(err, serviceTags(s))
+			return
+		}
+	}
+
+	// Remove the files that were not generated again
+	for _, ngf := range replaced {
+		if _, ok := configContents[ngf.Path]; ok {
+			continue
+		}
+
+		err = stage.remove(ngf.Path, s)
+		if err != nil {
+			err = fmt.Errorf("error removing old nginx config file for %q at %q: %w", s.Name, ngf.Path, err)
+			n.Monitor.CaptureException(err, serviceTags(s))
+			return
+		}
 	}
 }
 
-func (n NginxGenerator) generateHttpsConfig(ctx context.Context, s *models.Service) {
+// baseTemplate is a nginx config file generated when a service is first configured
+type baseTemplate struct {
+	fileType  string // The type of the nginx config in the DB
+	directory string // The directory in CONFIG_OUTPUT_DIR
+	template  string
+}
+
+// baseTemplates are the files generated for the base config of the service
+func baseTemplates(config internal.Config) ([]baseTemplate, error) {
+	switch strings.ToLower(config.Type) {
+	case "tcp", "udp", "stream":
+		return []baseTemplate{{"stream", "streams", "streams"}}, nil
+	case "http":
+		return []baseTemplate{{"http", "http", "httpBase"}}, nil
+	case "tls-passthrough":
+		// The upstream is in the stream context and the SNI map
+		// sends connections for the domains to it
+		return []baseTemplate{
+			{"stream", "streams", "sniUpstream"},
+			{"sni", "sni", "sniMap"},
+		}, nil
+	case "certificate":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Unknown config type")
+	}
+}
+
+func (n NginxGenerator) generateHttpsConfig(ctx context.Context, s *models.Service, stage *configStage) {
 	var err error
 	var b bytes.Buffer
 
+	start := time.Now()
+	fromState := s.State
+
 	config, err := n.getFullConfig(s)
 	if err != nil {
 		err = fmt.Errorf("could not get full config: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	if config.SslSource != "manual" {
-		err = n.setSslCertificatePath(ctx, &config)
+	// Set if the certificate could not be obtained and one from the local CA is used
+	var fallback bool
+
+	switch {
+	case config.Certificate != "":
+		// A missing or failed certificate is not looked for again on every run,
+		// the same as when getting a certificate fails. See certificateFailed
+		if s.CertRetryAt.Valid && time.Now().Before(s.CertRetryAt.Time) {
+			if !n.useFallbackCertificate(s, &config) {
+				return
+			}
+			fallback = true
+			break
+		}
+
+		var cert *models.Service
+		cert, err = sharedCertificate(ctx, n.DB, config.Certificate)
+		if err != nil {
+			err = fmt.Errorf("could not get certificate for %q: %w", s.Name, err)
+			sendServiceEvent(ctx, n.DB, n.Monitor, s, SSLCertGenerationFail.withDetail(err.Error()))
+			n.Monitor.CaptureException(err, serviceTags(s))
+			n.certificateFailed(ctx, s, err)
+		}
+		if err != nil || cert == nil {
+			// If it has not been obtained yet, we try again on the next run
+			if !n.useFallbackCertificate(s, &config) {
+				return
+			}
+			fallback = true
+			break
+		}
+
+		config.CertPath = cert.CertPath.String
+		config.KeyPath = cert.KeyPath.String
+
+		s.CertFailures = 0
+		s.CertRetryAt = null.Time{}
+		s.LastError = null.String{}
+
+	case config.SslSource != "manual":
+		// Wait before trying again after a failure. See certificateFailed
+		if s.CertRetryAt.Valid && time.Now().Before(s.CertRetryAt.Time) {
+			if !n.useFallbackCertificate(s, &config) {
+				return
+			}
+			fallback = true
+			break
+		}
+
+		// A renewal is forced when we are within CERT_RENEWAL_WINDOW
+		// even if the certificate is still valid
+		renew := s.State == internal.StateConfigured && s.CertNotAfter.Valid && !s.CertFallback
+		renew = renew || s.RenewRequested
+		if renew {
+			serviceLogger(s).Info("renewing certificate", "expires", s.CertNotAfter.Time)
+		}
+
+		// Shared certificates are named after the service
+		// so that they are easy to find
+		var name string
+		if config.IsCertificate() {
+			name = s.Name
+		}
+
+		err = n.setSslCertificatePath(ctx, &config, name, renew)
 		if err != nil {
 			err = fmt.Errorf("could set SSL cert paths: %w", err)
-			n.sendServiceEvent(s, SSLCertGenerationFail)
-			n.Monitor.CaptureException(err, nil)
-			return
+			sendServiceEvent(ctx, n.DB, n.Monitor, s, SSLCertGenerationFail)
+			n.Monitor.CaptureException(err, serviceTags(s))
+			n.certificateFailed(ctx, s, err)
+
+			if !n.useFallbackCertificate(s, &config) {
+				return
+			}
+			fallback = true
+			break
+		}
+
+		s.AcmeDirectory = null.String{}
+		if config.SslSource == "letsencrypt" {
+			s.AcmeDirectory = null.StringFrom(letsencrypt.DirectoryURL(n.Settings, config))
 		}
+
+		s.CertFailures = 0
+		s.CertRetryAt = null.Time{}
+		s.LastError = null.String{}
+		s.RenewRequested = false
+	}
+
+	wasFallback := s.CertFallback
+	s.CertFallback = fallback
+
+	info, certErr := internal.ReadCertificateInfo(config.CertPath)
+	if certErr != nil {
+		// Not fatal. We fall back to renewing after HTTPS_VALIDITY
+		certErr = fmt.Errorf("could not read certificate of %q: %w", s.Name, certErr)
+		n.Monitor.CaptureException(certErr, serviceTags(s))
+	}
+	setCertificateInfo(s, info, certErr == nil)
+	s.CertPath = null.StringFrom(config.CertPath)
+	s.KeyPath = null.StringFrom(config.KeyPath)
+	s.KeyType = null.NewString(info.KeyType, certErr == nil)
+
+	if config.IsCertificate() {
+		n.saveCertificate(ctx, s)
+		return
 	}
 
 	configDirectory := filepath.Join(n.Settings.CONFIG_OUTPUT_DIR, "http")
@@ -330,7 +586,7 @@ func (n NginxGenerator) generateHttpsConfig(ctx context.Context, s *models.Servi
 	err = n.Templates.ExecuteTemplate(&b, "https", config)
 	if err != nil {
 		err = fmt.Errorf("error generating https config for %q in %q: %w", s.Name, s.R.File.Path, err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 	configContents := b.Bytes()
@@ -339,7 +595,7 @@ func (n NginxGenerator) generateHttpsConfig(ctx context.Context, s *models.Servi
 	_, err = models.NginxConfigs(models.NginxConfigWhere.Path.EQ(configPath)).DeleteAll(ctx, n.DB)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		err = fmt.Errorf("could not delete old nginx http config at %q: %w", configPath, err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
@@ -353,21 +609,33 @@ func (n NginxGenerator) generateHttpsConfig(ctx context.Context, s *models.Servi
 	tx, err := n.DB.BeginTx(ctx, nil)
 	if err != nil {
 		err = fmt.Errorf("could not begin transaction: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 	defer func() {
 		if err == nil {
 			if commitErr := tx.Commit(); commitErr != nil {
-				n.Monitor.CaptureException(fmt.Errorf("could not commit transaction: %w", commitErr), nil)
-				n.deleteFile(ngf.Path)
+				n.Monitor.CaptureException(fmt.Errorf("could not commit transaction: %w", commitErr), serviceTags(s))
+				n.restoreFile(stage, ngf.Path)
 				return
 			}
-			log.Printf("CONFIGURED HTTPS FOR: %s", s.Name)
+			serviceLogger(s).Info(
+				"configured https",
+				"from_state", fromState,
+				"fallback", fallback,
+				"duration", time.Since(start),
+			)
+
+			switch {
+			case fromState == internal.StateConfigured && !fallback && !wasFallback:
+				sendServiceEvent(ctx, n.DB, n.Monitor, s, CertificateRenewed)
+			case fromState != internal.StateConfigured || (wasFallback && !fallback):
+				sendServiceEvent(ctx, n.DB, n.Monitor, s, HttpsConfigured)
+			}
 		} else {
 			if rollBkErr := tx.Rollback(); rollBkErr != nil {
-				n.Monitor.CaptureException(fmt.Errorf("could not rollback transaction: %w", rollBkErr), nil)
-				n.deleteFile(ngf.Path)
+				n.Monitor.CaptureException(fmt.Errorf("could not rollback transaction: %w", rollBkErr), serviceTags(s))
+				n.restoreFile(stage, ngf.Path)
 				return
 			}
 		}
@@ -376,39 +644,62 @@ func (n NginxGenerator) generateHttpsConfig(ctx context.Context, s *models.Servi
 	err = s.AddNginxConfigs(ctx, tx, true, ngf)
 	if err != nil {
 		err = fmt.Errorf("could not add nginx config to service in DB: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	if s.State != internal.StateConfigured {
+	// A renewal only updates the time so that it does not undo a state change
+	// made by another worker (e.g. the upstream monitor) while getting the certificate
+	columns := boil.Whitelist(
+		models.ServiceColumns.HTTPSConfigured,
+		models.ServiceColumns.CertNotAfter,
+		models.ServiceColumns.CertIssuer,
+		models.ServiceColumns.CertSans,
+		models.ServiceColumns.CertFingerprint,
+		models.ServiceColumns.CertPath,
+		models.ServiceColumns.KeyPath,
+		models.ServiceColumns.AcmeDirectory,
+		models.ServiceColumns.KeyType,
+		models.ServiceColumns.CertFallback,
+		models.ServiceColumns.CertFailures,
+		models.ServiceColumns.CertRetryAt,
+		models.ServiceColumns.LastError,
+		models.ServiceColumns.RenewRequested,
+	)
+
+	// http is only disabled once the service has its real certificate,
+	// so it is done when it replaces the fallback certificate
+	if s.State != internal.StateConfigured || (wasFallback && !fallback) {
+		columns = boil.Infer()
+
 		// If the https regenration was triggered by the validity, don't change the state
 		// E.g. if a https generation was triggered by the config.Validity, then it will already
 		// have state as Configured.
 		// If we change the state, we needlessly do the httpToHttps redirect generation
 		s.State = internal.StateConfigured
-		if config.HttpsOnly {
+		if config.HttpsOnly && !fallback {
 			s.State = internal.StateToDisableHttp
 		}
 	}
 
 	s.HTTPSConfigured = null.TimeFrom(time.Now())
 
-	_, err = s.Update(ctx, tx, boil.Infer())
+	_, err = s.Update(ctx, tx, columns)
 	if err != nil {
 		err = fmt.Errorf("could not update service in DB: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	err = os.WriteFile(ngf.Path, configContents, 0o644)
+	err = stage.write(ngf.Path, configContents, s)
 	if err != nil {
 		err = fmt.Errorf("error writing nginx config file for %q to %q: %w", s.Name, ngf.Path, err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 }
 
-func (n NginxGenerator) generateNoHttpConfig(ctx context.Context, s *models.Service, wg *sync.WaitGroup) {
+func (n NginxGenerator) generateNoHttpConfig(ctx context.Context, s *models.Service, stage *configStage, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	var err error
@@ -417,69 +708,323 @@ func (n NginxGenerator) generateNoHttpConfig(ctx context.Context, s *models.Serv
 	config, err := n.getFullConfig(s)
 	if err != nil {
 		err = fmt.Errorf("could not get full config: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
 	ngf, err := s.NginxConfigs(models.NginxConfigWhere.Type.EQ("http")).One(ctx, n.DB)
 	if err != nil {
 		err = fmt.Errorf("could not get base http config for service %q: %w", s.Name, err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
+		return
+	}
+
+	// The upstream blocks are in this file, so servers that are down must stay down
+	err = n.markDownUpstreams(ctx, s, &config)
+	if err != nil {
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
 	err = n.Templates.ExecuteTemplate(&b, "httptoHttps", config)
 	if err != nil {
 		err = fmt.Errorf("error generating https only config for %q in %q: %w", s.Name, s.R.File.Path, err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 	configContents := b.Bytes()
 
 	// We are not creating a transaction since we're only running one db query
-	// We will write the file first, and delete it if there's an error while updating the service
-	err = os.WriteFile(ngf.Path, configContents, 0o644)
+	// We will write the file first, and restore it if there's an error while updating the service
+	err = stage.write(ngf.Path, configContents, s)
 	if err != nil {
 		err = fmt.Errorf("error writing nginx config file for %q to %q: %w", s.Name, ngf.Path, err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
 	s.State = internal.StateConfigured
 	_, err = s.Update(ctx, n.DB, boil.Infer())
 	if err != nil {
-		n.deleteFile(ngf.Path)
+		n.restoreFile(stage, ngf.Path)
 		err = fmt.Errorf("could not update service in DB: %w", err)
-		n.Monitor.CaptureException(err, nil)
+		n.Monitor.CaptureException(err, serviceTags(s))
 		return
 	}
 
-	log.Printf("CONFIGURED HTTPS ONLY FOR: %s", s.Name)
+	serviceLogger(s).Info("configured https only", "from_state", internal.StateToDisableHttp)
+	sendServiceEvent(ctx, n.DB, n.Monitor, s, HttpsOnlyEnabled)
 }
 
-func (n NginxGenerator) pingUpstreams(config internal.Config) (bool, string) {
-	for _, u := range config.Upstream {
-		host := strings.Split(u.Address, ":")[0]
+// generateUpstreamRefresh rewrites the files with the upstream blocks of the service
+// so that servers that failed their last health check are marked as down
+func (n NginxGenerator) generateUpstreamRefresh(ctx context.Context, s *models.Service, stage *configStage, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	var err error
+
+	config, err := n.getFullConfig(s)
+	if err != nil {
+		err = fmt.Errorf("could not get full config: %w", err)
+		n.Monitor.CaptureException(err, serviceTags(s))
+		return
+	}
+
+	err = n.markDownUpstreams(ctx, s, &config)
+	if err != nil {
+		n.Monitor.CaptureException(err, serviceTags(s))
+		return
+	}
 
-		log.Printf("PINGING %q", host)
+	for _, ngf := range s.R.NginxConfigs {
+		template := upstreamTemplate(s, config, ngf.Type)
+		if template == "" {
+			continue
+		}
 
-		cmd := exec.Command("ping", "-c", "1", host)
-		err := cmd.Run()
+		var b bytes.Buffer
+		err = n.Templates.ExecuteTemplate(&b, template, config)
 		if err != nil {
-			return false, host
+			err = fmt.Errorf("error generating %s config for %q in %q: %w", template, s.Name, s.R.File.Path, err)
+			n.Monitor.CaptureException(err, serviceTags(s))
+			n.restoreFiles(stage, s.R.NginxConfigs)
+			return
 		}
+
+		err = stage.write(ngf.Path, b.Bytes(), s)
+		if err != nil {
+			err = fmt.Errorf("error writing nginx config file for %q to %q: %w", s.Name, ngf.Path, err)
+			n.Monitor.CaptureException(err, serviceTags(s))
+			n.restoreFiles(stage, s.R.NginxConfigs)
+			return
+		}
+	}
+
+	s.State = internal.StateConfigured
+	_, err = s.Update(ctx, n.DB, boil.Infer())
+	if err != nil {
+		n.restoreFiles(stage, s.R.NginxConfigs)
+		err = fmt.Errorf("could not update service in DB: %w", err)
+		n.Monitor.CaptureException(err, serviceTags(s))
+		return
+	}
+
+	serviceLogger(s).Info("refreshed upstreams", "from_state", internal.StateRefreshUpstreams)
+}
+
+// markDownUpstreams sets the servers that failed their last health check as down
+func (n NginxGenerator) markDownUpstreams(ctx context.Context, s *models.Service, config *internal.Config) error {
+	latest, err := latestUpstreamChecks(ctx, n.DB, s.ID)
+	if err != nil {
+		return fmt.Errorf("could not get upstream checks for %q: %w", s.Name, err)
+	}
+
+	markDownUpstreams(&config.Service, latest)
+	return nil
+}
+
+// upstreamTemplate is the template that was used for the nginx config file of the given type
+// It is empty if the file has no upstream blocks
+func upstreamTemplate(s *models.Service, config internal.Config, fileType string) string {
+	switch fileType {
+	case "http":
+		if config.Ssl && config.HttpsOnly && s.HTTPSConfigured.Valid && !s.CertFallback {
+			return "httptoHttps"
+		}
+		return "httpBase"
+	case "stream":
+		if strings.ToLower(config.Type) == "tls-passthrough" {
+			return "sniUpstream"
+		}
+		return "streams"
+	default:
+		return ""
+	}
+}
+
+// checkUpstreams checks every upstream server used by the service
+func checkUpstreams(ctx context.Context, service internal.Service) error {
+	for _, u := range serviceUpstreams(service) {
+		slog.Debug("checking upstream", "address", u.Address)
+
+		err := health.Check(ctx, u)
+		if err != nil {
+			return fmt.Errorf("%q: %w", u.Address, err)
+		}
+	}
+
+	return nil
+}
+
+// needsHttpsRenewal checks if the certificate of a configured https service is due for renewal
+func (n NginxGenerator) needsHttpsRenewal(ctx context.Context, s *models.Service) bool {
+	// Keep trying to get the real certificate
+	if s.CertFallback || s.RenewRequested {
+		return true
+	}
+
+	// The shared certificate is renewed on its own. The config only
+	// needs to be regenerated (and nginx reloaded) once it changes
+	if s.Content.Certificate != "" {
+		cert, err := sharedCertificate(ctx, n.DB, s.Content.Certificate)
+		if err != nil || cert == nil {
+			return false
+		}
+		return cert.CertFingerprint.String != s.CertFingerprint.String
+	}
+
+	// We cannot renew manual certificates, but the file may have been replaced
+	if s.Content.SslSource == "manual" {
+		info, err := internal.ReadCertificateInfo(s.Content.CertPath)
+		if err != nil {
+			return false
+		}
+		return info.Fingerprint != s.CertFingerprint.String
+	}
+
+	// The ACME server or the key type was changed in the config
+	if s.Content.SslSource == "letsencrypt" {
+		config := internal.Config{Service: s.Content}
+		if s.AcmeDirectory.Valid && s.AcmeDirectory.String != letsencrypt.DirectoryURL(n.Settings, config) {
+			return true
+		}
+		if s.KeyType.Valid && s.KeyType.String != letsencrypt.KeyType(n.Settings, config) {
+			return true
+		}
+	}
+
+	// Local certificates are renewed at half of their validity. See localca.GetCertificate
+	if s.Content.SslSource == "selfsigned" || s.Content.SslSource == "internal-ca" {
+		config := internal.Config{Service: s.Content}
+		if s.KeyType.Valid && s.KeyType.String != letsencrypt.KeyType(n.Settings, config) {
+			return true
+		}
+		if s.CertNotAfter.Valid {
+			return time.Until(s.CertNotAfter.Time) < n.Settings.LOCAL_CERT_VALIDITY/2
+		}
+	}
+
+	if s.CertNotAfter.Valid {
+		return time.Now().Add(n.Settings.CERT_RENEWAL_WINDOW).After(s.CertNotAfter.Time)
 	}
 
-	return true, ""
+	// The certificate could not be read when it was configured
+	return s.HTTPSConfigured.Time.Before(time.Now().Add(-n.Settings.HTTPS_VALIDITY))
 }
 
-func (n NginxGenerator) setSslCertificatePath(ctx context.Context, config *internal.Config) error {
+// saveCertificate saves the details of a shared certificate once it is obtained.
+// It has no nginx config, so there is nothing else to do
+func (n NginxGenerator) saveCertificate(ctx context.Context, s *models.Service) {
+	// A renewal only updates the certificate columns. See generateHttpsConfig
+	columns := boil.Whitelist(
+		models.ServiceColumns.HTTPSConfigured,
+		models.ServiceColumns.CertNotAfter,
+		models.ServiceColumns.CertIssuer,
+		models.ServiceColumns.CertSans,
+		models.ServiceColumns.CertFingerprint,
+		models.ServiceColumns.CertPath,
+		models.ServiceColumns.KeyPath,
+		models.ServiceColumns.AcmeDirectory,
+		models.ServiceColumns.KeyType,
+		models.ServiceColumns.CertFallback,
+		models.ServiceColumns.CertFailures,
+		models.ServiceColumns.CertRetryAt,
+		models.ServiceColumns.LastError,
+		models.ServiceColumns.RenewRequested,
+	)
+	fromState := s.State
+	if s.State != internal.StateConfigured {
+		columns = boil.Infer()
+		s.State = internal.StateConfigured
+	}
+
+	s.HTTPSConfigured = null.TimeFrom(time.Now())
+
+	_, err := s.Update(ctx, n.DB, columns)
+	if err != nil {
+		err = fmt.Errorf("could not update certificate %q in DB: %w", s.Name, err)
+		n.Monitor.CaptureException(err, serviceTags(s))
+		return
+	}
+
+	serviceLogger(s).Info("configured certificate", "from_state", fromState)
+
+	event := HttpsConfigured
+	if fromState == internal.StateConfigured {
+		event = CertificateRenewed
+	}
+	sendServiceEvent(ctx, n.DB, n.Monitor, s, event)
+}
+
+// sharedCertificate gets the certificate service with the name.
+// It is nil if the certificate has not been obtained yet
+func sharedCertificate(ctx context.Context, exec boil.ContextExecutor, name string) (*models.Service, error) {
+	services, err := models.Services(
+		models.ServiceWhere.Name.EQ(name),
+		models.ServiceWhere.State.NEQ(internal.StateFailed),
+		qm.OrderBy(models.ServiceColumns.ID),
+	).All(ctx, exec)
+	if err != nil {
+		return nil, fmt.Errorf("could not get services named %q: %w", name, err)
+	}
+
+	for _, s := range services {
+		if !s.Content.IsCertificate() {
+			continue
+		}
+
+		if s.State != internal.StateConfigured || !s.CertPath.Valid {
+			return nil, nil
+		}
+
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("no certificate named %q", name)
+}
+
+func setCertificateInfo(s *models.Service, info internal.CertificateInfo, ok bool) {
+	if !ok {
+		s.CertNotAfter = null.Time{}
+		s.CertIssuer = null.String{}
+		s.CertSans = null.String{}
+		s.CertFingerprint = null.String{}
+		return
+	}
+
+	s.CertNotAfter = null.TimeFrom(info.NotAfter)
+	s.CertIssuer = null.StringFrom(info.Issuer)
+	s.CertSans = null.StringFrom(strings.Join(info.SANs, ","))
+	s.CertFingerprint = null.StringFrom(info.Fingerprint)
+}
+
+func (n NginxGenerator) setSslCertificatePath(ctx context.Context, config *internal.Config, name string, renew bool) error {
 	switch config.SslSource {
 	case "manual":
 		return nil
 
 	case "letsencrypt":
-		CertPath, KeyPath, err := letsencrypt.GetCertificate(ctx, n.Settings, *config)
+		CertPath, KeyPath, err := letsencrypt.GetCertificate(ctx, n.Settings, name, *config, renew)
+		config.CertPath = CertPath
+		config.KeyPath = KeyPath
+		return err
+
+	case "selfsigned", "internal-ca":
+		if len(config.Domains) == 0 {
+			return fmt.Errorf("no domains to get a certificate for")
+		}
+		if name == "" {
+			name = config.Domains[0]
+		}
+
+		CertPath, KeyPath, _, err := localca.GetCertificate(n.Settings, localca.Request{
+			Name:       name,
+			Domains:    config.Domains,
+			KeyType:    letsencrypt.KeyType(n.Settings, *config),
+			SelfSigned: config.SslSource == "selfsigned",
+			Validity:   n.Settings.LOCAL_CERT_VALIDITY,
+			Renew:      renew,
+		})
 		config.CertPath = CertPath
 		config.KeyPath = KeyPath
 		return err
@@ -489,6 +1034,97 @@ func (n NginxGenerator) setSslCertificatePath(ctx context.Context, config *inter
 	}
 }
 
+// certificateFailed saves the failure, and when to try again so that the
+// ACME server is not asked for the certificate on every run. Rate limits are respected
+func (n NginxGenerator) certificateFailed(ctx context.Context, s *models.Service, reason error) {
+	s.CertFailures++
+	delay := certRetryDelay(n.Settings, s.CertFailures)
+
+	if wait, ok := letsencrypt.RateLimit(reason); ok {
+		// If the server did not say how long, we wait as long as we can
+		if wait <= 0 {
+			wait = n.Settings.CERT_RETRY_MAX
+		}
+		delay = max(delay, wait)
+	}
+
+	s.CertRetryAt = null.TimeFrom(time.Now().Add(delay))
+	s.LastError = null.StringFrom(reason.Error())
+
+	serviceLogger(s).Warn(
+		"could not get certificate",
+		"failures", s.CertFailures,
+		"retry_at", s.CertRetryAt.Time,
+		"error", reason,
+	)
+
+	_, err := s.Update(ctx, n.DB, boil.Whitelist(
+		models.ServiceColumns.CertFailures,
+		models.ServiceColumns.CertRetryAt,
+		models.ServiceColumns.LastError,
+	))
+	if err != nil {
+		err = fmt.Errorf("could not save certificate failure of %q: %w", s.Name, err)
+		n.Monitor.CaptureException(err, serviceTags(s))
+	}
+}
+
+// certRetryDelay doubles with every failure from CERT_RETRY_MIN up to CERT_RETRY_MAX
+func certRetryDelay(settings internal.Settings, failures int64) time.Duration {
+	return backoff(settings.CERT_RETRY_MIN, settings.CERT_RETRY_MAX, failures)
+}
+
+// backoff doubles with every failure from minDelay up to maxDelay.
+// Jitter is added so that things that failed together do not all retry together
+func backoff(minDelay, maxDelay time.Duration, failures int64) time.Duration {
+	delay := minDelay
+	for i := int64(1); i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	// Between half and all of the delay
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// useFallbackCertificate sets the config to use a short-lived certificate from the
+// local CA while the service cannot get its own, so that it can still be reached over https.
+// It is false if the service should not be reconfigured
+func (n NginxGenerator) useFallbackCertificate(s *models.Service, config *internal.Config) bool {
+	if !n.Settings.SSL_FALLBACK || config.IsCertificate() {
+		return false
+	}
+
+	// A service that has its certificate keeps using it until it is renewed
+	if s.State == internal.StateConfigured && !s.CertFallback {
+		return false
+	}
+
+	certPath, keyPath, changed, err := localca.GetCertificate(n.Settings, localca.Request{
+		Name:     "fallback-" + strconv.FormatInt(s.ID, 10),
+		Domains:  config.Domains,
+		KeyType:  letsencrypt.KeyType(n.Settings, *config),
+		Validity: n.Settings.FALLBACK_CERT_VALIDITY,
+	})
+	if err != nil {
+		err = fmt.Errorf("could not get fallback certificate for %q: %w", s.Name, err)
+		n.Monitor.CaptureException(err, serviceTags(s))
+		return false
+	}
+
+	// The config already uses this certificate
+	if !changed && s.State == internal.StateConfigured && s.CertFallback {
+		return false
+	}
+
+	serviceLogger(s).Warn("using fallback certificate")
+	config.CertPath = certPath
+	config.KeyPath = keyPath
+	s.AcmeDirectory = null.String{}
+
+	return true
+}
+
 func (n NginxGenerator) getFullConfig(s *models.Service) (internal.Config, error) {
 	var config internal.Config
 	service := s.Content
@@ -503,20 +1139,112 @@ func (n NginxGenerator) getFullConfig(s *models.Service) (internal.Config, error
 
 	config = internal.Config{
 		Service: service,
-		Unique:  s.Name + "-" + s.R.File.Name + "-" + strconv.FormatInt(s.ID, 10),
+		Unique:  uniqueName(s),
 	}
 
 	return config, nil
 }
 
+// uniqueName is used in the names of the nginx config files and upstreams of the service
+func uniqueName(s *models.Service) string {
+	return s.Name + "-" + s.R.File.Name + "-" + strconv.FormatInt(s.ID, 10)
+}
+
+// applyStage tests the staged configs with nginx before reloading.
+// Any file nginx rejects is rolled back to its last known good contents
+// and the service that generated it is marked as failed.
+func (n NginxGenerator) applyStage(ctx context.Context, stage *configStage) error {
+	if stage.empty() {
+		return nil
+	}
+
+	for {
+		output, err := n.testNginx()
+		if err == nil {
+			break
+		}
+
+		path, found := offendingFile(output)
+		service, staged := stage.owner(path)
+		if !found || !staged {
+			// We cannot tell which service broke the config
+			// so we roll back everything in this pass
+			services := stage.services()
+			if restoreErr := stage.restoreAll(); restoreErr != nil {
+				return fmt.Errorf("could not roll back configs: %w", restoreErr)
+			}
+			for _, s := range services {
+				n.markFailed(ctx, s, err)
+			}
+
+			return err
+		}
+
+		serviceLogger(service).Warn("nginx rejected config", "path", path)
+		if restoreErr := stage.restoreOwner(service); restoreErr != nil {
+			return fmt.Errorf("could not roll back configs for %q: %w", service.Name, restoreErr)
+		}
+		n.markFailed(ctx, service, err)
+	}
+
+	err := n.reloadNginx()
+	metrics.NginxReloads.Inc(metrics.Result(err))
+	if err != nil {
+		for _, s := range stage.services() {
+			sendServiceEvent(ctx, n.DB, n.Monitor, s, ReloadFailed.withDetail(err.Error()))
+		}
+		return fmt.Errorf("could not reload nginx: %w", err)
+	}
+
+	return nil
+}
+
+// markFailed sets the state of the service to failed so that it is not
+// picked up again until its config file changes
+func (n NginxGenerator) markFailed(ctx context.Context, s *models.Service, reason error) {
+	metrics.RejectedConfigs.Inc()
+
+	fromState := s.State
+	s.State = internal.StateFailed
+	s.LastError = null.StringFrom(reason.Error())
+	serviceLogger(s).Error("service failed", "from_state", fromState, "error", reason)
+
+	_, err := s.Update(ctx, n.DB, boil.Infer())
+	if err != nil {
+		err = fmt.Errorf("could not mark service %q as failed: %w", s.Name, err)
+		n.Monitor.CaptureException(err, serviceTags(s))
+	}
+
+	sendServiceEvent(ctx, n.DB, n.Monitor, s, InvalidConfig)
+}
+
+func (n NginxGenerator) testNginx() ([]byte, error) {
+	if n.Settings.TESTING {
+		return nil, nil
+	}
+
+	cmd := n.nginx("-t")
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf(
+			"NGINX config test failed: %s: %s",
+			err,
+			output,
+		)
+	}
+
+	return output, nil
+}
+
 func (n NginxGenerator) reloadNginx() error {
-	log.Println("Reloading NGINX")
+	slog.Info("reloading nginx")
 
 	if n.Settings.TESTING {
 		return nil
 	}
 
-	cmd := exec.Command("nginx", "-s", "reload")
+	cmd := n.nginx("-s", "reload")
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -530,52 +1258,25 @@ func (n NginxGenerator) reloadNginx() error {
 	return nil
 }
 
-func (n NginxGenerator) deleteFile(path string) {
-	// Cleanup nginx config file
-	err := os.RemoveAll(path)
-	if err != nil {
-		err = fmt.Errorf("could not cleanup nginx conf file after failed query: %w", err)
-		n.Monitor.CaptureException(err, nil)
+func (n NginxGenerator) nginx(args ...string) *exec.Cmd {
+	if n.NginxCommand != nil {
+		return n.NginxCommand(args...)
 	}
-}
 
-type serviceEvent struct {
-	Code int    `json:"code"`
-	Msg  string `json:"message"`
+	return exec.Command("nginx", args...)
 }
 
-var (
-	UnreachableUpstream = serviceEvent{
-		Code: 404,
-		Msg:  "could not reach upstream",
-	}
-	SSLCertGenerationFail = serviceEvent{
-		Code: 500,
-		Msg:  "ssl certificate generation failed",
-	}
-)
-
-func (n NginxGenerator) sendServiceEvent(service *models.Service, event serviceEvent) {
-	if service.Content.Webhook == "" {
-		return
+func (n NginxGenerator) restoreFiles(stage *configStage, ngfs []*models.NginxConfig) {
+	for _, ngf := range ngfs {
+		n.restoreFile(stage, ngf.Path)
 	}
-	var b bytes.Buffer
-	err := toml.NewEncoder(&b).Encode(service.Content)
-	if err != nil {
-		err = fmt.Errorf("could not encode service config: %w", err)
-		n.Monitor.CaptureException(err, nil)
-	}
-
-	values := url.Values{}
-	values.Set("id", service.Name)
-	values.Set("data", b.String())
-	values.Set("event_code", strconv.Itoa(event.Code))
-	values.Set("event_msg", event.Msg)
+}
 
-	client := http.Client{Timeout: 5 * time.Second}
-	_, err = client.PostForm(service.Content.Webhook, values)
+func (n NginxGenerator) restoreFile(stage *configStage, path string) {
+	// Cleanup nginx config file
+	err := stage.restore(path)
 	if err != nil {
-		err = fmt.Errorf("error sending event to webhook: %w", err)
+		err = fmt.Errorf("could not cleanup nginx conf file after failed query: %w", err)
 		n.Monitor.CaptureException(err, nil)
 	}
 }
@@ -0,0 +1,189 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// fakeNginx tests the .conf files in the directory like "nginx -t".
+// A file with "invalid" is rejected with its path in the output
+// and a file with "unparsable" is rejected without it
+type fakeNginx struct {
+	dir string
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeNginx) command(args ...string) *exec.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, strings.Join(args, " "))
+	if !slices.Equal(args, []string{"-t"}) {
+		return exec.Command("true")
+	}
+
+	script := `for f in "$1"/*.conf; do
+	if grep -q invalid "$f"; then
+		echo "nginx: [emerg] unknown directive \"invalid\" in $f:1"
+		exit 1
+	fi
+	if grep -q unparsable "$f"; then
+		echo "nginx: [emerg] something went wrong"
+		exit 1
+	fi
+done
+echo "nginx: configuration file test is successful"`
+
+	return exec.Command("sh", "-c", script, "nginx", f.dir)
+}
+
+func (f *fakeNginx) reloads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reloads := 0
+	for _, call := range f.calls {
+		if call == "-s reload" {
+			reloads++
+		}
+	}
+
+	return reloads
+}
+
+// addTestService saves a configured service in the file
+func addTestService(t *testing.T, db *sql.DB, path, name string, content internal.Service) *models.Service {
+	t.Helper()
+
+	file := saveTestFile(t, db, path, internal.ServiceMap{name: content})
+
+	s := &models.Service{
+		FileID:  null.Int64From(file.ID),
+		Name:    name,
+		Content: content,
+		State:   internal.StateConfigured,
+	}
+	err := s.Insert(context.Background(), db, boil.Infer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// stageTestFile writes the contents to a file of the service that had the old contents
+func stageTestFile(t *testing.T, stage *configStage, path, old, contents string, s *models.Service) {
+	t.Helper()
+
+	if old != "<missing>" {
+		if err := os.WriteFile(path, []byte(old), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stage.write(path, []byte(contents), s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyStage(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents map[string]string // the new contents of the files of each service
+		wantErr  bool
+		failed   []string
+		files    map[string]string // the contents of each file after the stage is applied
+	}{
+		{
+			name:     "valid",
+			contents: map[string]string{"a": "new", "b": "new"},
+			files:    map[string]string{"a.conf": "new", "a-https.conf": "new", "b.conf": "new", "b-https.conf": "new"},
+		},
+		{
+			name:     "rejected file",
+			contents: map[string]string{"a": "new", "b": "invalid"},
+			failed:   []string{"b"},
+			files:    map[string]string{"a.conf": "new", "a-https.conf": "new", "b.conf": "old", "b-https.conf": "<missing>"},
+		},
+		{
+			name:     "every file rejected",
+			contents: map[string]string{"a": "invalid", "b": "invalid"},
+			failed:   []string{"a", "b"},
+			files:    map[string]string{"a.conf": "old", "a-https.conf": "<missing>", "b.conf": "old", "b-https.conf": "<missing>"},
+		},
+		{
+			name:     "no file in the output",
+			contents: map[string]string{"a": "new", "b": "unparsable"},
+			wantErr:  true,
+			failed:   []string{"a", "b"},
+			files:    map[string]string{"a.conf": "old", "a-https.conf": "<missing>", "b.conf": "old", "b-https.conf": "<missing>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			nginx := &fakeNginx{dir: dir}
+			n := NginxGenerator{DB: testDB(t), Monitor: newTestMonitor(t), NginxCommand: nginx.command}
+
+			// Each service had a config and gets a new https config
+			stage := newConfigStage()
+			for _, name := range []string{"a", "b"} {
+				s := addTestService(t, n.DB, "/config/"+name+".toml", name, internal.Service{Domains: []string{name + ".com"}})
+				stageTestFile(t, stage, filepath.Join(dir, name+".conf"), "old", tt.contents[name], s)
+				stageTestFile(t, stage, filepath.Join(dir, name+"-https.conf"), "<missing>", tt.contents[name], s)
+			}
+
+			err := n.applyStage(ctx, stage)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			wantReloads := 1
+			if tt.wantErr {
+				wantReloads = 0
+			}
+			if got := nginx.reloads(); got != wantReloads {
+				t.Errorf("got %d reloads, want %d", got, wantReloads)
+			}
+
+			for file, want := range tt.files {
+				if got := readTestFile(t, filepath.Join(dir, file)); got != want {
+					t.Errorf("%s: got %q, want %q", file, got, want)
+				}
+			}
+
+			var failed []string
+			for key, s := range testServices(t, n.DB) {
+				if s.State != internal.StateFailed {
+					if s.LastError.Valid {
+						t.Errorf("%s: got error %q for a service that did not fail", key, s.LastError.String)
+					}
+					continue
+				}
+				failed = append(failed, s.Name)
+				if !strings.Contains(s.LastError.String, "NGINX config test failed") {
+					t.Errorf("%s: got error %q", key, s.LastError.String)
+				}
+			}
+			slices.Sort(failed)
+			if !slices.Equal(failed, tt.failed) {
+				t.Errorf("got failed services %q, want %q", failed, tt.failed)
+			}
+		})
+	}
+}
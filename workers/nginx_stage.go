@@ -0,0 +1,192 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/stephenafamo/warden/models"
+)
+
+// configStage keeps track of the nginx config files written during a
+// generation pass, along with what they contained before, so that
+// the pass can be rolled back if nginx rejects the result
+type configStage struct {
+	mu       sync.Mutex
+	previous map[string]stagedFile
+	owners   map[string]*models.Service
+}
+
+type stagedFile struct {
+	contents []byte
+	existed  bool
+}
+
+func newConfigStage() *configStage {
+	return &configStage{
+		previous: make(map[string]stagedFile),
+		owners:   make(map[string]*models.Service),
+	}
+}
+
+// write saves the contents to the path, remembering the previous contents
+// the first time the path is written to in this stage
+func (c *configStage) write(path string, contents []byte, owner *models.Service) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.previous[path]; !ok {
+		old, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			c.previous[path] = stagedFile{contents: old, existed: true}
+		case errors.Is(err, os.ErrNotExist):
+			c.previous[path] = stagedFile{existed: false}
+		default:
+			return fmt.Errorf("could not read current contents of %q: %w", path, err)
+		}
+	}
+
+	c.owners[path] = owner
+
+	return os.WriteFile(path, contents, 0o644)
+}
+
+// remove deletes the path, remembering its contents the first time
+// the path is staged so that it is put back if the stage is rolled back
+func (c *configStage) remove(path string, owner *models.Service) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.previous[path]; !ok {
+		old, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			c.previous[path] = stagedFile{contents: old, existed: true}
+		case errors.Is(err, os.ErrNotExist):
+			return nil
+		default:
+			return fmt.Errorf("could not read current contents of %q: %w", path, err)
+		}
+	}
+
+	c.owners[path] = owner
+
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// restore puts back the contents the path had before it was first staged
+func (c *configStage) restore(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.restoreLocked(path)
+}
+
+// restoreAll rolls back every staged file
+func (c *configStage) restoreAll() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for path := range c.previous {
+		errs = append(errs, c.restoreLocked(path))
+	}
+
+	return errors.Join(errs...)
+}
+
+// restoreOwner rolls back every staged file written by the service
+func (c *configStage) restoreOwner(s *models.Service) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for path, owner := range c.owners {
+		if owner.ID == s.ID {
+			errs = append(errs, c.restoreLocked(path))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *configStage) restoreLocked(path string) error {
+	prev, ok := c.previous[path]
+	if !ok {
+		return nil
+	}
+
+	delete(c.previous, path)
+	delete(c.owners, path)
+
+	if !prev.existed {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove staged file %q: %w", path, err)
+		}
+		return nil
+	}
+
+	err := os.WriteFile(path, prev.contents, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not restore staged file %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// owner returns the service that wrote the staged path, if any
+func (c *configStage) owner(path string) (*models.Service, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.owners[path]
+	return s, ok
+}
+
+// services returns every service that has a file in the stage
+func (c *configStage) services() []*models.Service {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[int64]bool, len(c.owners))
+	services := make([]*models.Service, 0, len(c.owners))
+	for _, s := range c.owners {
+		if seen[s.ID] {
+			continue
+		}
+		seen[s.ID] = true
+		services = append(services, s)
+	}
+
+	return services
+}
+
+func (c *configStage) empty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.previous) == 0
+}
+
+// nginx reports errors as "... in /path/to/file.conf:12"
+var nginxErrorFile = regexp.MustCompile(` in (\S+):\d+`)
+
+// offendingFile returns the config file nginx complained about in
+// the output of "nginx -t"
+func offendingFile(output []byte) (string, bool) {
+	match := nginxErrorFile.FindSubmatch(output)
+	if match == nil {
+		return "", false
+	}
+
+	return string(match[1]), true
+}
@@ -0,0 +1,137 @@
+package workers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stephenafamo/warden/models"
+)
+
+// readTestFile returns the contents of the file, or "<missing>" if it does not exist
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(contents)
+}
+
+func TestConfigStage(t *testing.T) {
+	dir := t.TempDir()
+	a := &models.Service{ID: 1, Name: "a"}
+	b := &models.Service{ID: 2, Name: "b"}
+
+	existing := filepath.Join(dir, "existing.conf")
+	removed := filepath.Join(dir, "removed.conf")
+	added := filepath.Join(dir, "added.conf")
+	other := filepath.Join(dir, "other.conf")
+	for _, path := range []string{existing, removed} {
+		if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stage := newConfigStage()
+	if !stage.empty() {
+		t.Fatal("a new stage is not empty")
+	}
+
+	// The contents from before the first write are kept
+	for _, contents := range []string{"new", "newer"} {
+		if err := stage.write(existing, []byte(contents), a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stage.write(added, []byte("new"), a); err != nil {
+		t.Fatal(err)
+	}
+	if err := stage.remove(removed, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := stage.write(other, []byte("new"), b); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing a file that does not exist is not staged
+	if err := stage.remove(filepath.Join(dir, "missing.conf"), b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stage.owner(filepath.Join(dir, "missing.conf")); ok {
+		t.Error("a missing file that was removed is staged")
+	}
+
+	if s, ok := stage.owner(removed); !ok || s.ID != a.ID {
+		t.Errorf("got owner %v for the removed file, want %q", s, a.Name)
+	}
+	if got := len(stage.services()); got != 2 {
+		t.Errorf("got %d services, want 2", got)
+	}
+
+	if err := stage.restoreOwner(a); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		existing: "old",
+		removed:  "old",
+		added:    "<missing>",
+		other:    "new",
+	}
+	for path, contents := range want {
+		if got := readTestFile(t, path); got != contents {
+			t.Errorf("%s: got %q after restoring the owner, want %q", filepath.Base(path), got, contents)
+		}
+	}
+	if _, ok := stage.owner(existing); ok {
+		t.Error("a restored file is still staged")
+	}
+
+	if err := stage.restoreAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, other); got != "<missing>" {
+		t.Errorf("got %q after restoring all, want the file to be removed", got)
+	}
+	if !stage.empty() {
+		t.Error("the stage is not empty after restoring all")
+	}
+}
+
+func TestOffendingFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+		found  bool
+	}{
+		{
+			name:   "unknown directive",
+			output: "nginx: [emerg] unknown directive \"invalid\" in /etc/nginx/conf.d/web-a-1.conf:12\nnginx: configuration file /etc/nginx/nginx.conf test failed\n",
+			want:   "/etc/nginx/conf.d/web-a-1.conf",
+			found:  true,
+		},
+		{
+			name:   "missing certificate",
+			output: "nginx: [emerg] cannot load certificate \"/certs/a.pem\": BIO_new_file() failed\nnginx: configuration file /etc/nginx/nginx.conf test failed\n",
+		},
+		{
+			name: "no output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := offendingFile([]byte(tt.output))
+			if got != tt.want || found != tt.found {
+				t.Errorf("got %q, %v, want %q, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// RenderedService has the nginx config files of a service as they would be generated
+type RenderedService struct {
+	Name    string
+	File    string // The config file the service is in
+	Unique  string
+	Configs []RenderedConfig
+	// The ID of the service in the names of the files was taken from the files
+	// in CONFIG_OUTPUT_DIR, since there is no state DB. See existingServiceID
+	IDGuessed bool
+	// Files in CONFIG_OUTPUT_DIR for the service that would not be generated
+	// e.g. the https config of a service that no longer uses ssl
+	Stale []string
+}
+
+// RenderedConfig is a single nginx config file
+type RenderedConfig struct {
+	Path     string // Where it is written in CONFIG_OUTPUT_DIR
+	Template string
+	Content  []byte
+}
+
+// RenderConfigs generates the nginx configs of the services in the config files
+// without writing them, getting certificates or checking the upstreams.
+// They are the configs of the services once they are fully configured.
+// Certificates that are not manual have placeholder paths.
+//
+// The names of the files have the ID of the service in the state DB, so the service
+// is looked up by the path of its file and its name. It is 0 for a new service.
+// If db is nil, the ID is guessed from the files already in CONFIG_OUTPUT_DIR.
+func RenderConfigs(ctx context.Context, db *sql.DB, settings internal.Settings, templates *template.Template, paths []string) ([]RenderedService, error) {
+	files, err := configFilePaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	n := NginxGenerator{DB: db, Settings: settings, Templates: templates}
+
+	var rendered []RenderedService
+	for _, path := range files {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %q: %w", path, err)
+		}
+
+		services, _, err := decodeServices(raw, givenFileFormat(path))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", path, err)
+		}
+
+		file := &models.File{
+			Path: path,
+			Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		}
+
+		names := make([]string, 0, len(services))
+		for name := range services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			r, err := n.renderService(ctx, file, name, services[name])
+			if err != nil {
+				return nil, fmt.Errorf("could not render %q in %q: %w", name, path, err)
+			}
+
+			rendered = append(rendered, r)
+		}
+	}
+
+	return rendered, nil
+}
+
+func (n NginxGenerator) renderService(ctx context.Context, file *models.File, name string, service internal.Service) (RenderedService, error) {
+	id, guessed, err := n.renderedServiceID(ctx, file, name)
+	if err != nil {
+		return RenderedService{}, err
+	}
+
+	s := &models.Service{
+		ID:      id,
+		Name:    name,
+		Content: service,
+	}
+	s.R = s.R.NewStruct()
+	s.R.File = file
+
+	config, err := n.getFullConfig(s)
+	if err != nil {
+		return RenderedService{}, err
+	}
+
+	bases, err := baseTemplates(config)
+	if err != nil {
+		return RenderedService{}, err
+	}
+
+	r := RenderedService{
+		Name:      name,
+		File:      file.Path,
+		Unique:    config.Unique,
+		IDGuessed: guessed,
+	}
+
+	add := func(directory, name, template string) error {
+		var b bytes.Buffer
+		err := n.Templates.ExecuteTemplate(&b, template, config)
+		if err != nil {
+			return fmt.Errorf("error generating %s config: %w", template, err)
+		}
+
+		r.Configs = append(r.Configs, RenderedConfig{
+			Path:     filepath.Join(n.Settings.CONFIG_OUTPUT_DIR, directory, name),
+			Template: template,
+			Content:  b.Bytes(),
+		})
+		return nil
+	}
+
+	https := config.Ssl && strings.ToLower(config.Type) == "http"
+	if https && (config.Certificate != "" || config.SslSource != "manual") {
+		config.CertPath = filepath.Join("/placeholder", config.Unique, "fullchain.pem")
+		config.KeyPath = filepath.Join("/placeholder", config.Unique, "privkey.pem")
+	}
+
+	for _, base := range bases {
+		template := base.template
+		// Replaced once https is configured. See generateNoHttpConfig
+		if https && config.HttpsOnly && template == "httpBase" {
+			template = "httptoHttps"
+		}
+
+		err = add(base.directory, config.Unique+".conf", template)
+		if err != nil {
+			return RenderedService{}, err
+		}
+	}
+
+	if https {
+		err = add("http", config.Unique+".SSL.conf", "https")
+		if err != nil {
+			return RenderedService{}, err
+		}
+	}
+
+	generated := map[string]bool{}
+	for _, c := range r.Configs {
+		generated[c.Path] = true
+	}
+	for _, dir := range outputDirs {
+		for _, name := range []string{config.Unique + ".conf", config.Unique + ".SSL.conf"} {
+			path := filepath.Join(n.Settings.CONFIG_OUTPUT_DIR, dir, name)
+			if _, err := os.Stat(path); err == nil && !generated[path] {
+				r.Stale = append(r.Stale, path)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// renderedServiceID gets the ID of the service with the name in the file from the state DB.
+// Without the state DB, it is guessed from the files in CONFIG_OUTPUT_DIR.
+// The guess is true in that case
+func (n NginxGenerator) renderedServiceID(ctx context.Context, file *models.File, name string) (int64, bool, error) {
+	if n.DB == nil {
+		id := existingServiceID(n.Settings.CONFIG_OUTPUT_DIR, name, file.Name)
+		return id, id != 0, nil
+	}
+
+	// Files are saved with the paths from CONFIG_DIR, which are absolute
+	path, err := filepath.Abs(file.Path)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not get the absolute path of %q: %w", file.Path, err)
+	}
+
+	s, err := models.Services(
+		qm.InnerJoin(fmt.Sprintf(
+			"%s on %s.%s = %s.%s",
+			models.TableNames.Files,
+			models.TableNames.Files,
+			models.FileColumns.ID,
+			models.TableNames.Services,
+			models.ServiceColumns.FileID,
+		)),
+		models.FileWhere.Path.EQ(path),
+		models.ServiceWhere.Name.EQ(name),
+	).One(ctx, n.DB)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("could not get service %q in %q: %w", name, path, err)
+	}
+
+	return s.ID, false, nil
+}
+
+// existingServiceID gets the ID of the service from the names of its
+// files in CONFIG_OUTPUT_DIR. See uniqueName. It is 0 if there are none.
+// The names only have the name of the config file, not its directory, so
+// it can be the ID of a service with the same name in another CONFIG_DIR root
+func existingServiceID(outputDir, name, fileName string) int64 {
+	prefix := name + "-" + fileName + "-"
+
+	for _, dir := range outputDirs {
+		entries, err := os.ReadDir(filepath.Join(outputDir, dir))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			rest, ok := strings.CutPrefix(entry.Name(), prefix)
+			if !ok {
+				continue
+			}
+
+			id, err := strconv.ParseInt(strings.SplitN(rest, ".", 2)[0], 10, 64)
+			if err == nil {
+				return id
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,119 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+func TestRenderConfigs(t *testing.T) {
+	templates, err := internal.GetTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Services with the same name in files with the same name in different roots
+	x, y := t.TempDir(), t.TempDir()
+	writeConfigFile(t, filepath.Join(x, "a.toml"), "web")
+	writeConfigFile(t, filepath.Join(y, "a.toml"), "web")
+	writeConfigFile(t, filepath.Join(x, "b.toml"), "new")
+
+	db := testDB(t)
+	content := internal.Service{Domains: []string{"web.com"}}
+	fromY := addTestService(t, db, filepath.Join(y, "a.toml"), "web", content)
+	fromX := addTestService(t, db, filepath.Join(x, "a.toml"), "web", content)
+
+	if fromY.ID != 1 || fromX.ID != 2 {
+		t.Fatalf("got IDs %d and %d, want 1 and 2", fromY.ID, fromX.ID)
+	}
+
+	settings := internal.Settings{CONFIG_OUTPUT_DIR: t.TempDir()}
+	err = os.MkdirAll(filepath.Join(settings.CONFIG_OUTPUT_DIR, "http"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := []string{
+		"web-a-1.conf",
+		"web-a-2.conf",
+		"web-a-2.SSL.conf", // the service no longer uses ssl
+	}
+	for _, name := range existing {
+		err := os.WriteFile(filepath.Join(settings.CONFIG_OUTPUT_DIR, "http", name), []byte("old"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type result struct {
+		unique  string
+		guessed bool
+		stale   []string
+	}
+
+	tests := []struct {
+		name string
+		db   *sql.DB
+		want map[string]result // by file and service
+	}{
+		{
+			name: "state DB",
+			db:   db,
+			want: map[string]result{
+				filepath.Join(x, "a.toml") + "/web": {unique: "web-a-2", stale: []string{"web-a-2.SSL.conf"}},
+				filepath.Join(y, "a.toml") + "/web": {unique: "web-a-1"},
+				filepath.Join(x, "b.toml") + "/new": {unique: "new-b-0"},
+			},
+		},
+		{
+			// The first file with the name is used for both services
+			name: "guessed",
+			want: map[string]result{
+				filepath.Join(x, "a.toml") + "/web": {unique: "web-a-1", guessed: true},
+				filepath.Join(y, "a.toml") + "/web": {unique: "web-a-1", guessed: true},
+				filepath.Join(x, "b.toml") + "/new": {unique: "new-b-0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := RenderConfigs(context.Background(), tt.db, settings, templates, []string{x, y})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rendered) != len(tt.want) {
+				t.Fatalf("got %d services, want %d", len(rendered), len(tt.want))
+			}
+
+			for _, r := range rendered {
+				key := r.File + "/" + r.Name
+				want, ok := tt.want[key]
+				if !ok {
+					t.Errorf("unexpected service %s", key)
+					continue
+				}
+
+				var stale []string
+				for _, path := range r.Stale {
+					stale = append(stale, filepath.Base(path))
+				}
+				if r.Unique != want.unique || r.IDGuessed != want.guessed || !slices.Equal(stale, want.stale) {
+					t.Errorf("%s: got %s, guessed %v, stale %q, want %s, guessed %v, stale %q",
+						key, r.Unique, r.IDGuessed, stale, want.unique, want.guessed, want.stale)
+				}
+
+				wantPath := filepath.Join(settings.CONFIG_OUTPUT_DIR, "http", want.unique+".conf")
+				if len(r.Configs) != 1 || r.Configs[0].Path != wantPath {
+					t.Errorf("%s: got configs %v, want %s", key, r.Configs, wantPath)
+				} else if len(r.Configs[0].Content) == 0 {
+					t.Errorf("%s: the config is empty", key)
+				}
+			}
+		})
+	}
+}
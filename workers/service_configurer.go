@@ -4,14 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/stephenafamo/janus/monitor"
 	"github.com/stephenafamo/kronika"
 	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/metrics"
 	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 )
@@ -67,11 +71,20 @@ func (s ServiceConfigurer) setFileServices(ctx context.Context) error {
 			models.TableNames.Files,
 			models.FileColumns.LastModified,
 		)),
+		qm.Load(models.ServiceRels.File),
 	).All(ctx, s.DB)
 	if err != nil {
 		return fmt.Errorf("could not get services to delete: %w", err)
 	}
 
+	for _, service := range servicesToDelete {
+		// The others were replaced by the new services of the file
+		if _, ok := service.R.File.Content[service.Name]; ok {
+			continue
+		}
+		sendServiceEvent(ctx, s.DB, s.Monitor, service, ServiceRemoved)
+	}
+
 	if len(servicesToDelete) > 0 {
 		_, err = servicesToDelete.DeleteAll(ctx, s.DB)
 		if err != nil {
@@ -82,6 +95,10 @@ func (s ServiceConfigurer) setFileServices(ctx context.Context) error {
 	return nil
 }
 
+// Services of different files are added concurrently. This makes sure
+// two services cannot claim the same port at the same time
+var portClaims sync.Mutex
+
 func (s ServiceConfigurer) createFileServices(ctx context.Context, file *models.File, wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -92,7 +109,7 @@ func (s ServiceConfigurer) createFileServices(ctx context.Context, file *models.
 		service := &models.Service{
 			Name:         key,
 			Content:      config,
-			IsSSL:        config.Ssl,
+			IsSSL:        config.Ssl || config.IsCertificate(),
 			State:        internal.StateNotConfigured,
 			LastModified: file.LastModified,
 		}
@@ -100,22 +117,152 @@ func (s ServiceConfigurer) createFileServices(ctx context.Context, file *models.
 		services = append(services, service)
 	}
 
+	portClaims.Lock()
+	defer portClaims.Unlock()
+
+	err := s.checkPorts(ctx, file, services)
+	if err != nil {
+		metrics.FileConfigurations.Inc(metrics.Result(err))
+		err = fmt.Errorf("could not check ports of file services: %w", err)
+		s.Monitor.CaptureException(err, fileTags(file.Path))
+		return
+	}
+
 	// Just add a new relationship. setFileServices cleans the old ones
 	if err := file.AddServices(ctx, s.DB, true, services...); err != nil {
+		metrics.FileConfigurations.Inc(metrics.Result(err))
 		err = fmt.Errorf("could not add file services: %w", err)
-		s.Monitor.CaptureException(err, nil)
+		s.Monitor.CaptureException(err, fileTags(file.Path))
 		return
 	}
 
-	log.Printf("ADDED SERVICES FOR: %s\n", file.Path)
+	slog.Debug("added services", "file", file.Path, "services", len(services))
 
 	// Mark the file as configured in the DB
+	// Only the column is updated so that a newer error from the watcher is kept
 	file.IsConfigured = true
-	if _, err := file.Update(ctx, s.DB, boil.Infer()); err != nil {
+	if _, err := file.Update(ctx, s.DB, boil.Whitelist(models.FileColumns.IsConfigured)); err != nil {
+		metrics.FileConfigurations.Inc(metrics.Result(err))
 		err = fmt.Errorf("could not update file: %w", err)
-		s.Monitor.CaptureException(err, nil)
+		s.Monitor.CaptureException(err, fileTags(file.Path))
 		return
 	}
 
-	log.Printf("RECONFIGURED SERVICES FOR: %s \n", file.Path)
+	metrics.FileConfigurations.Inc(metrics.Result(nil))
+
+	slog.Info("reconfigured services", "file", file.Path, "services", len(services))
+}
+
+// checkPorts marks stream services that cannot listen on their ports as failed.
+// The ports may be invalid, reserved by the proxy, or already claimed by another service.
+func (s ServiceConfigurer) checkPorts(ctx context.Context, file *models.File, services models.ServiceSlice) error {
+	// Services of other files that are still active
+	// The old services of this file will be replaced so they are excluded,
+	// and so are the old services of other files that changed in the same run
+	// since setFileServices deletes them. e.g. a service moved to another file
+	existing, err := models.Services(
+		qm.Load(models.ServiceRels.File),
+		qm.InnerJoin(fmt.Sprintf(
+			"%s on %s.%s = %s.%s",
+			models.TableNames.Files,
+			models.TableNames.Files,
+			models.FileColumns.ID,
+			models.TableNames.Services,
+			models.ServiceColumns.FileID,
+		)),
+		qm.Where(fmt.Sprintf(
+			"%s.%s >= %s.%s",
+			models.TableNames.Services,
+			models.ServiceColumns.LastModified,
+			models.TableNames.Files,
+			models.FileColumns.LastModified,
+		)),
+		models.ServiceWhere.FileID.NEQ(null.Int64From(file.ID)),
+		models.ServiceWhere.State.NEQ(internal.StateFailed),
+	).All(ctx, s.DB)
+	if err != nil {
+		return fmt.Errorf("could not get existing services: %w", err)
+	}
+
+	type claim struct {
+		ports   internal.PortRange
+		service string
+		path    string
+	}
+
+	var claims []claim
+	for _, r := range internal.ReservedPorts {
+		claims = append(claims, claim{ports: r, service: "the proxy"})
+	}
+	for _, service := range existing {
+		if !isStream(service.Content) {
+			continue
+		}
+		ranges, err := service.Content.PortRanges()
+		if err != nil {
+			continue
+		}
+		path := ""
+		if service.R != nil && service.R.File != nil {
+			path = service.R.File.Path
+		}
+		for _, r := range ranges {
+			claims = append(claims, claim{ports: r, service: service.Name, path: path})
+		}
+	}
+
+	// Sorted so that the same service always wins within a file
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	for _, service := range services {
+		if !isStream(service.Content) {
+			continue
+		}
+
+		ranges, err := service.Content.PortRanges()
+		if err == nil && service.Content.AcceptProxyProtocol && service.Content.StreamProtocol() == "udp" {
+			err = fmt.Errorf("the PROXY protocol cannot be accepted over UDP")
+		}
+
+	rangeLoop:
+		for _, r := range ranges {
+			for _, c := range claims {
+				if !r.Overlaps(c.ports) {
+					continue
+				}
+				err = fmt.Errorf("port %s is already claimed by %q", r, c.service)
+				if c.path != "" {
+					err = fmt.Errorf("port %s is already claimed by %q in %q", r, c.service, c.path)
+				}
+				break rangeLoop
+			}
+		}
+
+		if err != nil {
+			err = fmt.Errorf("cannot configure service %q in %q: %w", service.Name, file.Path, err)
+			tags := serviceTags(service)
+			tags["file"] = file.Path
+			s.Monitor.CaptureException(err, tags)
+			service.State = internal.StateFailed
+			service.LastError = null.StringFrom(err.Error())
+			continue
+		}
+
+		for _, r := range ranges {
+			claims = append(claims, claim{ports: r, service: service.Name, path: file.Path})
+		}
+	}
+
+	return nil
+}
+
+func isStream(service internal.Service) bool {
+	switch strings.ToLower(service.Type) {
+	case "tcp", "udp", "stream":
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,178 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/janus/monitor"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// testMonitor logs what is captured and keeps the exceptions
+type testMonitor struct {
+	t          *testing.T
+	mu         *sync.Mutex
+	exceptions *[]error
+}
+
+func newTestMonitor(t *testing.T) testMonitor {
+	return testMonitor{t: t, mu: &sync.Mutex{}, exceptions: &[]error{}}
+}
+
+func (m testMonitor) Middleware(h http.Handler) http.Handler { return h }
+
+func (m testMonitor) StartSpan(ctx context.Context, name string) (context.Context, monitor.Span) {
+	return ctx, nil
+}
+
+func (m testMonitor) CaptureMessage(msg string, tags map[string]string) {
+	m.t.Logf("message: %s %v", msg, tags)
+}
+
+func (m testMonitor) CaptureException(err error, tags map[string]string) {
+	m.t.Logf("exception: %v %v", err, tags)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*m.exceptions = append(*m.exceptions, err)
+}
+
+func (m testMonitor) Recover(ctx context.Context, cause interface{}) {}
+
+func (m testMonitor) Flush(timeout time.Duration) {}
+
+// testDB returns a migrated state DB that is removed after the test
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := internal.OpenDB(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = internal.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// saveTestFile adds the file, or changes its services if it exists,
+// the same way the directory watcher does
+func saveTestFile(t *testing.T, db *sql.DB, path string, content internal.ServiceMap) *models.File {
+	t.Helper()
+	ctx := context.Background()
+
+	// Without the monotonic clock reading, which is not stored
+	now := time.Now().Round(0)
+
+	file, err := models.Files(models.FileWhere.Path.EQ(path)).One(ctx, db)
+	if err != nil {
+		file = &models.File{
+			Path:         path,
+			Name:         filepath.Base(path),
+			Content:      content,
+			Checksum:     path,
+			Source:       filepath.Dir(path),
+			LastModified: now,
+		}
+		if err := file.Insert(ctx, db, boil.Infer()); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	file.Content = content
+	file.IsConfigured = false
+	file.LastModified = now
+	if _, err := file.Update(ctx, db, boil.Infer()); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// testServices returns the state of each service by name
+func testServices(t *testing.T, db *sql.DB) map[string]*models.Service {
+	t.Helper()
+
+	services, err := models.Services(
+		qm.Load(models.ServiceRels.File),
+	).All(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]*models.Service, len(services))
+	for _, s := range services {
+		byName[s.R.File.Name+"/"+s.Name] = s
+	}
+
+	return byName
+}
+
+func TestCheckPortsMovedService(t *testing.T) {
+	ctx := context.Background()
+
+	stream := func(port uint) internal.Service {
+		return internal.Service{
+			Type:     "tcp",
+			Port:     port,
+			Upstream: []internal.UpstreamServer{{Address: "db:5432"}},
+		}
+	}
+
+	// Each file can be handled first
+	for i := 0; i < 10; i++ {
+		db := testDB(t)
+		s := ServiceConfigurer{DB: db, Monitor: newTestMonitor(t)}
+
+		saveTestFile(t, db, "/config/a.toml", internal.ServiceMap{"db": stream(5432)})
+		if err := s.setFileServices(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// The service is moved to another file in the same run
+		// and the other file also claims a port that is still used
+		saveTestFile(t, db, "/config/a.toml", internal.ServiceMap{"cache": stream(6379)})
+		saveTestFile(t, db, "/config/b.toml", internal.ServiceMap{"db": stream(5432)})
+		saveTestFile(t, db, "/config/c.toml", internal.ServiceMap{"other": stream(6379)})
+		if err := s.setFileServices(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		services := testServices(t, db)
+		if len(services) != 3 {
+			t.Fatalf("got %d services, want 3", len(services))
+		}
+		if _, ok := services["a.toml/db"]; ok {
+			t.Error("the old service was not removed")
+		}
+		if s := services["b.toml/db"]; s == nil {
+			t.Error("the moved service was not added")
+		} else if s.State != internal.StateNotConfigured {
+			t.Errorf("got state %q for the moved service: %s", s.State, s.LastError.String)
+		}
+
+		// Only one of the services with the same port is configured
+		failed := 0
+		for _, name := range []string{"a.toml/cache", "c.toml/other"} {
+			if services[name].State == internal.StateFailed {
+				failed++
+			}
+		}
+		if failed != 1 {
+			t.Errorf("got %d failed services for port 6379, want 1", failed)
+		}
+	}
+}
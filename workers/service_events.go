@@ -0,0 +1,222 @@
+package workers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stephenafamo/janus/monitor"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+type serviceEvent struct {
+	Type string `json:"event"`
+	Code int    `json:"code"`
+	Msg  string `json:"message"`
+
+	// The event is about a condition that is checked on every run.
+	// It is not sent again while it is the last event of the service
+	ongoing bool
+}
+
+var (
+	UnreachableUpstream = serviceEvent{
+		Type: "upstream_unreachable",
+		Code: 404,
+		Msg:  "could not reach upstream",
+
+		ongoing: true,
+	}
+	SSLCertGenerationFail = serviceEvent{
+		Type: "certificate_failed",
+		Code: 500,
+		Msg:  "ssl certificate generation failed",
+	}
+	InvalidConfig = serviceEvent{
+		Type: "config_rejected",
+		Code: 422,
+		Msg:  "generated config was rejected by nginx",
+	}
+	UpstreamDown = serviceEvent{
+		Type: "upstream_down",
+		Code: 503,
+		Msg:  "upstream failed its health check and was taken out of rotation",
+	}
+	UpstreamRecovered = serviceEvent{
+		Type: "upstream_recovered",
+		Code: 200,
+		Msg:  "upstream passed its health check and was put back in rotation",
+	}
+	ServiceConfigured = serviceEvent{
+		Type: "configured",
+		Code: 200,
+		Msg:  "service was configured",
+	}
+	HttpsConfigured = serviceEvent{
+		Type: "https_configured",
+		Code: 200,
+		Msg:  "https was configured",
+	}
+	HttpsOnlyEnabled = serviceEvent{
+		Type: "https_only_enabled",
+		Code: 200,
+		Msg:  "http requests are now redirected to https",
+	}
+	CertificateRenewed = serviceEvent{
+		Type: "certificate_renewed",
+		Code: 200,
+		Msg:  "ssl certificate was renewed",
+	}
+	ServiceRemoved = serviceEvent{
+		Type: "service_removed",
+		Code: 410,
+		Msg:  "service was removed",
+	}
+	ReloadFailed = serviceEvent{
+		Type: "reload_failed",
+		Code: 500,
+		Msg:  "nginx could not be reloaded",
+	}
+)
+
+// withDetail adds more information about this particular event to the message
+func (e serviceEvent) withDetail(detail string) serviceEvent {
+	e.Msg = e.Msg + ": " + detail
+	return e
+}
+
+// webhookPayload is the JSON body sent to the webhook
+type webhookPayload struct {
+	serviceEvent
+	Time    time.Time      `json:"time"`
+	Service webhookService `json:"service"`
+}
+
+type webhookService struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	File    string   `json:"file,omitempty"`
+	Type    string   `json:"type"`
+	Domains []string `json:"domains"`
+	State   string   `json:"state"`
+}
+
+// sendServiceEvent queues the event to be sent to the webhook of the service.
+// See WebhookSender
+func sendServiceEvent(ctx context.Context, db *sql.DB, mon monitor.Monitor, service *models.Service, event serviceEvent) {
+	err := queueServiceEvent(ctx, db, service, event)
+	if err != nil {
+		mon.CaptureException(err, serviceTags(service))
+	}
+}
+
+func queueServiceEvent(ctx context.Context, exec boil.ContextExecutor, service *models.Service, event serviceEvent) error {
+	if service.Content.Webhook == "" {
+		return nil
+	}
+
+	if event.ongoing {
+		last, err := models.WebhookDeliveries(
+			models.WebhookDeliveryWhere.ServiceID.EQ(null.Int64From(service.ID)),
+			qm.OrderBy(models.WebhookDeliveryColumns.ID+" DESC"),
+		).One(ctx, exec)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("could not get the last event of %q: %w", service.Name, err)
+		}
+		if err == nil && last.Event == event.Type {
+			return nil
+		}
+	}
+
+	now := time.Now()
+
+	payload := webhookPayload{
+		serviceEvent: event,
+		Time:         now,
+		Service: webhookService{
+			ID:      service.ID,
+			Name:    service.Name,
+			Type:    service.Content.Type,
+			Domains: service.Content.Domains,
+			State:   service.State,
+		},
+	}
+	if payload.Service.Type == "" {
+		payload.Service.Type = "http"
+	}
+	if payload.Service.Domains == nil {
+		payload.Service.Domains = []string{}
+	}
+	if service.R != nil && service.R.File != nil {
+		payload.Service.File = service.R.File.Path
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not encode %s event for %q: %w", event.Type, service.Name, err)
+	}
+
+	delivery := &models.WebhookDelivery{
+		ServiceID:     null.Int64From(service.ID),
+		ServiceName:   service.Name,
+		URL:           service.Content.Webhook,
+		Event:         event.Type,
+		Payload:       string(body),
+		State:         deliveryPending,
+		NextAttemptAt: null.TimeFrom(now),
+		CreatedAt:     now,
+	}
+
+	// The secret is not kept, so the delivery can still be sent
+	// if the service is removed before it is delivered
+	if service.Content.WebhookSecret != "" {
+		delivery.Signature = null.StringFrom(signPayload(service.Content.WebhookSecret, body))
+	}
+
+	err = delivery.Insert(ctx, exec, boil.Infer())
+	if err != nil {
+		return fmt.Errorf("could not queue %s event for %q: %w", event.Type, service.Name, err)
+	}
+
+	return nil
+}
+
+// signPayload is the value of the X-Warden-Signature header.
+// It is the hex encoded HMAC-SHA256 of the body with the secret of the service
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// queueServicesRemoved queues the removed event for every service of the files.
+// It is called before the files are deleted, since their services are deleted with them
+func queueServicesRemoved(ctx context.Context, exec boil.ContextExecutor, files models.FileSlice) error {
+	for _, f := range files {
+		services, err := f.Services().All(ctx, exec)
+		if err != nil {
+			return fmt.Errorf("could not get services of %q: %w", f.Path, err)
+		}
+
+		for _, s := range services {
+			s.R = s.R.NewStruct()
+			s.R.File = f
+
+			err = queueServiceEvent(ctx, exec, s, ServiceRemoved)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package workers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/metrics"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// The directories in CONFIG_OUTPUT_DIR where the nginx config files are generated
+var outputDirs = []string{"http", "streams", "sni"}
+
+// ReconcileState makes the stored state match what is on disk before the workers start.
+// This matters when the state is persisted, since things may have changed while we were down
+//   - Files from sources that are no longer used are removed
+//   - Services whose nginx config files are missing are configured again
+//   - Generated files that are not in the DB are removed
+func ReconcileState(ctx context.Context, db *sql.DB, settings internal.Settings) error {
+	var sources []string
+	for _, root := range filepath.SplitList(settings.CONFIG_DIR) {
+		if root != "" {
+			sources = append(sources, root)
+		}
+	}
+	if settings.DOCKER_DISCOVERY {
+		sources = append(sources, dockerSource)
+	}
+	// Kept even if the admin API is disabled, so they are not lost
+	sources = append(sources, apiSource)
+
+	oldFiles, err := models.Files(models.FileWhere.Source.NIN(sources)).All(ctx, db)
+	if err != nil {
+		return fmt.Errorf("could not get files from old sources: %w", err)
+	}
+
+	err = removeFiles(ctx, db, oldFiles)
+	if err != nil {
+		return fmt.Errorf("could not delete files from old sources: %w", err)
+	}
+
+	err = resetMissingConfigs(ctx, db)
+	if err != nil {
+		return fmt.Errorf("could not reset services with missing configs: %w", err)
+	}
+
+	err = removeUntrackedConfigs(ctx, db, settings.CONFIG_OUTPUT_DIR)
+	if err != nil {
+		return fmt.Errorf("could not remove untracked configs: %w", err)
+	}
+
+	return nil
+}
+
+// resetMissingConfigs sets services back to not configured if any of their
+// nginx config files is missing. e.g. CONFIG_OUTPUT_DIR is not persisted
+func resetMissingConfigs(ctx context.Context, db *sql.DB) error {
+	ngfs, err := models.NginxConfigs(
+		models.NginxConfigWhere.ServiceID.IsNotNull(),
+	).All(ctx, db)
+	if err != nil {
+		return fmt.Errorf("could not get nginx configs: %w", err)
+	}
+
+	missing := map[int64]bool{}
+	for _, ngf := range ngfs {
+		_, err := os.Stat(ngf.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			missing[ngf.ServiceID.Int64] = true
+		}
+	}
+
+	for id := range missing {
+		service, err := models.FindService(ctx, db, id)
+		if err != nil {
+			return fmt.Errorf("could not get service %d: %w", id, err)
+		}
+
+		// Failed services are only retried when their file changes
+		if service.State == internal.StateFailed {
+			continue
+		}
+
+		err = reconfigureService(ctx, db, service)
+		if err != nil {
+			return err
+		}
+
+		serviceLogger(service).Info("missing config, reconfiguring")
+	}
+
+	return nil
+}
+
+// reconfigureService sets the service back to not configured so that its
+// nginx config files are generated again. The current files are kept
+// until the new ones are generated, see generateBaseConfig
+func reconfigureService(ctx context.Context, db *sql.DB, service *models.Service) error {
+	service.State = internal.StateNotConfigured
+	_, err := service.Update(ctx, db, boil.Infer())
+	if err != nil {
+		return fmt.Errorf("could not reset %q: %w", service.Name, err)
+	}
+
+	return nil
+}
+
+// removeUntrackedConfigs deletes generated files that are not in the DB
+// With an in-memory DB, this removes every previously generated file
+func removeUntrackedConfigs(ctx context.Context, db *sql.DB, outputDir string) error {
+	ngfs, err := models.NginxConfigs().All(ctx, db)
+	if err != nil {
+		return fmt.Errorf("could not get nginx configs: %w", err)
+	}
+
+	tracked := make(map[string]bool, len(ngfs))
+	for _, ngf := range ngfs {
+		tracked[ngf.Path] = true
+	}
+
+	for _, dir := range outputDirs {
+		dir = filepath.Join(outputDir, dir)
+
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read %q: %w", dir, err)
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if tracked[path] {
+				continue
+			}
+
+			err = os.RemoveAll(path)
+			if err != nil {
+				return fmt.Errorf("could not delete %q: %w", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeFiles deletes the files. Their services are deleted with them,
+// so the webhooks of the services are told that they were removed
+func removeFiles(ctx context.Context, db *sql.DB, files models.FileSlice) (err error) {
+	if len(files) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = queueServicesRemoved(ctx, tx, files)
+	if err != nil {
+		return err
+	}
+
+	_, err = files.DeleteAll(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("could not delete files: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	for _, f := range files {
+		slog.Info("removed file", "file", f.Path)
+	}
+
+	return nil
+}
+
+// syncVirtualFile adds or updates a file that is not on disk, e.g. for a docker container.
+// It is only updated if the content changed. modified is only used if it is added
+func syncVirtualFile(ctx context.Context, db *sql.DB, source, path, name string, content internal.ServiceMap, modified time.Time) (changed bool, err error) {
+	encoded, err := content.Value()
+	if err != nil {
+		return false, fmt.Errorf("could not encode services: %w", err)
+	}
+	sum := sha256.Sum256(encoded.([]byte))
+	checksum := hex.EncodeToString(sum[:])
+
+	oldFile, err := models.Files(models.FileWhere.Path.EQ(path)).One(ctx, db)
+	if errors.Is(err, sql.ErrNoRows) {
+		fModel := models.File{
+			Name:         name,
+			Path:         path,
+			Content:      content,
+			Checksum:     checksum,
+			Source:       source,
+			LastModified: modified,
+			IsConfigured: false,
+		}
+
+		err = fModel.Insert(ctx, db, boil.Infer())
+		if err != nil {
+			return false, fmt.Errorf("error inserting %q in db: %w", path, err)
+		}
+
+		slog.Info("added file", "file", path)
+		metrics.ConfigFileChanges.Inc(source, "added")
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting %q from DB: %w", path, err)
+	}
+
+	if checksum == oldFile.Checksum {
+		if !oldFile.Error.Valid {
+			return false, nil
+		}
+
+		// Changed back after an error
+		oldFile.Error = null.String{}
+		_, err = oldFile.Update(ctx, db, boil.Whitelist(models.FileColumns.Error))
+		if err != nil {
+			return false, fmt.Errorf("error updating %q in db: %w", path, err)
+		}
+		return false, nil
+	}
+
+	oldFile.Name = name
+	oldFile.Content = content
+	oldFile.Checksum = checksum
+	oldFile.IsConfigured = false
+	// Without the monotonic clock reading, which the DB would store as part of
+	// the time and make the services of the file look older than it
+	oldFile.LastModified = time.Now().Round(0)
+	oldFile.Error = null.String{}
+
+	_, err = oldFile.Update(ctx, db, boil.Infer())
+	if err != nil {
+		return false, fmt.Errorf("error updating %q in db: %w", path, err)
+	}
+
+	slog.Info("updated file", "file", path)
+	metrics.ConfigFileChanges.Inc(source, "updated")
+	return true, nil
+}
+
+// markFileInvalid saves why the file cannot be used. The content is not changed,
+// so the services from the last time it was valid are kept.
+// A file that is not in the DB yet is added without services.
+// It returns false if the file already had the same error,
+// so that it is only reported when the error changes
+func markFileInvalid(ctx context.Context, db *sql.DB, file *models.File, reason error) (bool, error) {
+	if file.Error.Valid && file.Error.String == reason.Error() {
+		return false, nil
+	}
+
+	file.Error = null.StringFrom(reason.Error())
+
+	var err error
+	if file.ID == 0 {
+		file.Content = internal.ServiceMap{}
+		file.IsConfigured = true // there is nothing to configure
+		err = file.Insert(ctx, db, boil.Infer())
+	} else {
+		_, err = file.Update(ctx, db, boil.Whitelist(models.FileColumns.Error))
+	}
+	if err != nil {
+		return false, fmt.Errorf("error saving the error of %q in db: %w", file.Path, err)
+	}
+
+	return true, nil
+}
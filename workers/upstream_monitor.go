@@ -0,0 +1,215 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephenafamo/janus/monitor"
+	"github.com/stephenafamo/kronika"
+	"github.com/stephenafamo/warden/health"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/metrics"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// UpstreamMonitor periodically checks the upstream servers of configured services.
+// When a server fails or recovers, the service is set to have its upstreams
+// refreshed so that the server is marked as down or put back in rotation
+type UpstreamMonitor struct {
+	DB       *sql.DB
+	Monitor  monitor.Monitor
+	Settings internal.Settings
+}
+
+func (u UpstreamMonitor) Play(ctx context.Context) error {
+	for range kronika.Every(ctx, time.Now(), u.Settings.HEALTH_CHECK_INTERVAL) {
+		err := u.CheckUpstreams(context.Background()) // use new context
+		if err != nil {
+			err = fmt.Errorf("error checking upstreams: %w", err)
+			u.Monitor.CaptureException(err, nil)
+		}
+	}
+
+	return nil
+}
+
+func (u UpstreamMonitor) CheckUpstreams(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	services, err := models.Services(
+		models.ServiceWhere.State.IN([]string{
+			internal.StateConfigured,
+			internal.StateRefreshUpstreams,
+		}),
+		qm.Load(models.ServiceRels.File),
+	).All(ctx, u.DB)
+	if err != nil {
+		return fmt.Errorf("could not get configured services: %w", err)
+	}
+
+	wg.Add(len(services))
+	for _, service := range services {
+		go u.checkService(ctx, service, &wg)
+	}
+	wg.Wait()
+
+	_, err = models.UpstreamChecks(
+		models.UpstreamCheckWhere.CheckedAt.LT(time.Now().Add(-u.Settings.HEALTH_CHECK_HISTORY)),
+	).DeleteAll(ctx, u.DB)
+	if err != nil {
+		return fmt.Errorf("could not delete old upstream checks: %w", err)
+	}
+
+	return nil
+}
+
+func (u UpstreamMonitor) checkService(ctx context.Context, s *models.Service, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	latest, err := latestUpstreamChecks(ctx, u.DB, s.ID)
+	if err != nil {
+		err = fmt.Errorf("could not get last upstream checks for %q: %w", s.Name, err)
+		u.Monitor.CaptureException(err, serviceTags(s))
+		return
+	}
+
+	changed := false
+	checked := map[string]bool{}
+
+	for _, upstream := range serviceUpstreams(s.Content) {
+		// The same server may be used in more than one location
+		if checked[upstream.Address] {
+			continue
+		}
+		checked[upstream.Address] = true
+
+		checkErr := health.Check(ctx, upstream)
+		metrics.UpstreamChecks.Inc(metrics.Result(checkErr))
+
+		check := &models.UpstreamCheck{
+			Address:   upstream.Address,
+			IsHealthy: checkErr == nil,
+			CheckedAt: time.Now(),
+		}
+		if checkErr != nil {
+			check.Error = null.StringFrom(checkErr.Error())
+		}
+
+		err = s.AddUpstreamChecks(ctx, u.DB, true, check)
+		if err != nil {
+			err = fmt.Errorf("could not save upstream check for %q: %w", s.Name, err)
+			u.Monitor.CaptureException(err, serviceTags(s))
+			return
+		}
+
+		// Servers are in rotation until a check fails
+		wasHealthy := true
+		if last, ok := latest[upstream.Address]; ok {
+			wasHealthy = last.IsHealthy
+		}
+		if wasHealthy == check.IsHealthy {
+			continue
+		}
+
+		changed = true
+		if check.IsHealthy {
+			serviceLogger(s).Info("upstream recovered", "address", upstream.Address)
+			sendServiceEvent(ctx, u.DB, u.Monitor, s, UpstreamRecovered.withDetail(upstream.Address))
+		} else {
+			serviceLogger(s).Warn("upstream down", "address", upstream.Address, "error", checkErr)
+			sendServiceEvent(ctx, u.DB, u.Monitor, s, UpstreamDown.withDetail(upstream.Address))
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	// Only update the state if nothing else changed it while we were checking
+	_, err = models.Services(
+		models.ServiceWhere.ID.EQ(s.ID),
+		models.ServiceWhere.State.EQ(internal.StateConfigured),
+	).UpdateAll(ctx, u.DB, models.M{
+		models.ServiceColumns.State: internal.StateRefreshUpstreams,
+	})
+	if err != nil {
+		err = fmt.Errorf("could not set %q to refresh its upstreams: %w", s.Name, err)
+		u.Monitor.CaptureException(err, serviceTags(s))
+	}
+}
+
+// latestUpstreamChecks returns the last check of each upstream address of the service
+func latestUpstreamChecks(ctx context.Context, exec boil.ContextExecutor, serviceID int64) (map[string]*models.UpstreamCheck, error) {
+	checks, err := models.UpstreamChecks(
+		models.UpstreamCheckWhere.ServiceID.EQ(null.Int64From(serviceID)),
+		qm.Where(`"id" IN (SELECT MAX("id") FROM "upstream_checks" WHERE "service_id" = ? GROUP BY "address")`, serviceID),
+	).All(ctx, exec)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*models.UpstreamCheck, len(checks))
+	for _, check := range checks {
+		latest[check.Address] = check
+	}
+
+	return latest, nil
+}
+
+// serviceUpstreams returns every upstream server used in the config of the service
+func serviceUpstreams(service internal.Service) []internal.UpstreamServer {
+	var upstreams []internal.UpstreamServer
+
+	// The top level upstream is only used for the top level location in HTTP configs
+	// which defaults to "/" if there are no other locations
+	isHttp := service.Type == "" || strings.ToLower(service.Type) == "http"
+	if !isHttp || service.Location != "" || len(service.Locations) == 0 {
+		upstreams = append(upstreams, service.Upstream...)
+	}
+	for _, l := range service.Locations {
+		upstreams = append(upstreams, l.Upstream...)
+	}
+
+	// A TCP connection cannot tell if a UDP server is up,
+	// so UDP servers are not checked unless asked to
+	if strings.ToLower(service.Type) == "udp" {
+		for i, u := range upstreams {
+			if u.HealthCheck.Type == "" {
+				upstreams[i].HealthCheck.Type = "none"
+			}
+		}
+	}
+
+	return upstreams
+}
+
+// markDownUpstreams sets every upstream server whose last check failed as down
+func markDownUpstreams(service *internal.Service, latest map[string]*models.UpstreamCheck) {
+	mark := func(servers []internal.UpstreamServer) []internal.UpstreamServer {
+		// Copy so that the servers of the stored service are not changed
+		marked := make([]internal.UpstreamServer, len(servers))
+		for i, server := range servers {
+			if last, ok := latest[server.Address]; ok && !last.IsHealthy {
+				server.Down = true
+			}
+			marked[i] = server
+		}
+		return marked
+	}
+
+	service.Upstream = mark(service.Upstream)
+
+	locations := make([]internal.Location, len(service.Locations))
+	for i, l := range service.Locations {
+		l.Upstream = mark(l.Upstream)
+		locations[i] = l
+	}
+	service.Locations = locations
+}
@@ -0,0 +1,105 @@
+package workers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+)
+
+// closedAddress returns an address that nothing listens on
+func closedAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	return address
+}
+
+func TestServiceUpstreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		service internal.Service
+		want    []string // the health check type of each upstream server
+	}{
+		{
+			name: "http without locations",
+			service: internal.Service{
+				Upstream: []internal.UpstreamServer{{Address: "a:80"}},
+			},
+			want: []string{""},
+		},
+		{
+			name: "http top level upstream is not used with locations",
+			service: internal.Service{
+				Upstream:  []internal.UpstreamServer{{Address: "a:80"}},
+				Locations: []internal.Location{{Match: "/b", Upstream: []internal.UpstreamServer{{Address: "b:80"}}}},
+			},
+			want: []string{""},
+		},
+		{
+			name: "tcp is checked",
+			service: internal.Service{
+				Type:     "tcp",
+				Upstream: []internal.UpstreamServer{{Address: "a:53"}},
+			},
+			want: []string{""},
+		},
+		{
+			name: "udp is not checked by default",
+			service: internal.Service{
+				Type: "UDP",
+				Upstream: []internal.UpstreamServer{
+					{Address: "a:53"},
+					{Address: "b:53", HealthCheck: internal.HealthCheck{Type: "tcp"}},
+				},
+			},
+			want: []string{"none", "tcp"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreams := serviceUpstreams(tt.service)
+			if len(upstreams) != len(tt.want) {
+				t.Fatalf("got %d upstreams, want %d", len(upstreams), len(tt.want))
+			}
+			for i, u := range upstreams {
+				if u.HealthCheck.Type != tt.want[i] {
+					t.Errorf("%s: got health check %q, want %q", u.Address, u.HealthCheck.Type, tt.want[i])
+				}
+			}
+
+			// The servers of the service are not changed
+			for _, u := range tt.service.Upstream {
+				if u.HealthCheck.Type == "none" {
+					t.Errorf("%s: the health check of the service was changed", u.Address)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckUpstreamsUDP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upstream := []internal.UpstreamServer{{Address: closedAddress(t)}}
+
+	// A UDP service without a health check is configured
+	// even though nothing accepts TCP connections on its address
+	err := checkUpstreams(ctx, internal.Service{Type: "udp", Port: 53, Upstream: upstream})
+	if err != nil {
+		t.Errorf("got error for a udp service: %v", err)
+	}
+
+	err = checkUpstreams(ctx, internal.Service{Type: "tcp", Port: 53, Upstream: upstream})
+	if err == nil {
+		t.Error("got no error for a tcp service")
+	}
+}
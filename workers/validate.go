@@ -0,0 +1,297 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stephenafamo/warden/internal"
+)
+
+// Diagnostic is a problem found in a config file
+type Diagnostic struct {
+	Path    string
+	Line    int    // 0 if it is not known
+	Service string // empty if it is about the whole file
+	Message string
+	Warning bool // the file can still be used
+}
+
+// String is in the path:line: message format that editors and CI tools understand
+func (d Diagnostic) String() string {
+	location := d.Path
+	if d.Line > 0 {
+		location = fmt.Sprintf("%s:%d", d.Path, d.Line)
+	}
+
+	msg := d.Message
+	if d.Warning {
+		msg = "warning: " + msg
+	}
+
+	if d.Service == "" {
+		return location + ": " + msg
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, d.Service, msg)
+}
+
+// ValidateConfigFiles checks the config files without configuring anything.
+// Directories are walked the same way as CONFIG_DIR.
+// Files are decoded like the directory watcher does, and every service is validated.
+// Domains that are used by more than one service are also reported.
+// Unknown keys are warnings if CONFIG_UNKNOWN_KEYS is warn
+func ValidateConfigFiles(settings internal.Settings, paths []string) ([]Diagnostic, error) {
+	files, err := configFilePaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var diagnostics []Diagnostic
+	domains := domainClaims{}
+
+	for _, path := range files {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			diagnostics = append(diagnostics, Diagnostic{Path: path, Message: err.Error()})
+			continue
+		}
+
+		services, unknown, err := decodeServices(raw, givenFileFormat(path))
+		if err != nil {
+			diagnostics = append(diagnostics, decodeDiagnostic(path, err))
+			continue
+		}
+
+		for _, key := range unknown {
+			diagnostics = append(diagnostics, Diagnostic{
+				Path:    path,
+				Line:    keyLine(raw, key),
+				Message: fmt.Sprintf("unknown key %q", key),
+				Warning: strings.ToLower(settings.CONFIG_UNKNOWN_KEYS) == "warn",
+			})
+		}
+
+		lines := make(map[string]int, len(services))
+		names := make([]string, 0, len(services))
+		for name := range services {
+			lines[name] = serviceLine(raw, name)
+			names = append(names, name)
+		}
+		// In the order they are in the file
+		sort.Slice(names, func(i, j int) bool {
+			if lines[names[i]] != lines[names[j]] {
+				return lines[names[i]] < lines[names[j]]
+			}
+			return names[i] < names[j]
+		})
+
+		for _, name := range names {
+			service := services[name]
+			line := lines[name]
+
+			for _, err := range splitErrors(service.Validate()) {
+				diagnostics = append(diagnostics, Diagnostic{
+					Path:    path,
+					Line:    line,
+					Service: name,
+					Message: err.Error(),
+				})
+			}
+
+			diagnostics = append(diagnostics, domains.claim(path, line, name, service)...)
+		}
+	}
+
+	// Unknown keys are found before the services are checked
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Path != diagnostics[j].Path {
+			return diagnostics[i].Path < diagnostics[j].Path
+		}
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+
+	return diagnostics, nil
+}
+
+// configFilePaths returns the paths that are files, and the config files
+// in the paths that are directories. They are walked the same way as CONFIG_DIR
+func configFilePaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		var found []FilePathAndInfo
+		err = walkFiles(path, DirectoryWatcher{}.setFilesInfo(path, &[]string{}, &found))
+		if err != nil {
+			return nil, fmt.Errorf("error walking %q: %w", path, err)
+		}
+		for _, f := range found {
+			files = append(files, f.Path)
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// The decoder adds the line to the message of errors with the values
+// e.g. toml: line 2 (last key "x.domains"): incompatible types
+// YAML and JSON syntax errors have the line in the same way
+var decodeLineErr = regexp.MustCompile(`(?:toml|yaml|json): line (\d+)(?: \(last key "([^"]*)"\))?: (.*)$`)
+
+// decodeDiagnostic gets the line of the error if the decoder knows it
+func decodeDiagnostic(path string, err error) Diagnostic {
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) && parseErr.Message != "" {
+		return Diagnostic{Path: path, Line: parseErr.Position.Line, Message: parseErr.Message}
+	}
+
+	match := decodeLineErr.FindStringSubmatch(err.Error())
+	if match == nil {
+		return Diagnostic{Path: path, Message: err.Error()}
+	}
+
+	line, _ := strconv.Atoi(match[1])
+	msg := match[3]
+	if match[2] != "" {
+		msg = match[2] + ": " + msg
+	}
+
+	return Diagnostic{Path: path, Line: line, Message: msg}
+}
+
+// splitErrors returns each error joined with errors.Join separately
+func splitErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, splitErrors(e)...)
+	}
+
+	return errs
+}
+
+// serviceLine finds the line where the service is defined.
+// It is the first table for the service, e.g. [name] or [[name.locations]],
+// or the first key if it is defined with dotted or inline keys,
+// or the key of the service in YAML and JSON files. 0 if not found
+func serviceLine(raw []byte, name string) int {
+	key := `(?:` + regexp.QuoteMeta(name) + `|"` + regexp.QuoteMeta(name) + `"|'` + regexp.QuoteMeta(name) + `')`
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`^\s*\[\[?\s*` + key + `\s*[\].]`),
+		regexp.MustCompile(`^\s*` + key + `\s*[.=:]`),
+	}
+
+	lines := strings.Split(string(raw), "\n")
+	for _, pattern := range patterns {
+		for i, line := range lines {
+			if pattern.MatchString(line) {
+				return i + 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// keyLine finds the line where the last part of a dotted key is set.
+// It starts looking from the line of the service. 0 if not found
+func keyLine(raw []byte, key string) int {
+	parts := strings.Split(key, ".")
+
+	// The name of the service can also have dots. e.g. ["api.v2"]
+	start, n := 0, 0
+	for n < len(parts) && start == 0 {
+		n++
+		start = serviceLine(raw, strings.Join(parts[:n], "."))
+	}
+	if n == len(parts) || start == 0 {
+		return start
+	}
+
+	last := regexp.QuoteMeta(parts[len(parts)-1])
+	pattern := regexp.MustCompile(`(?:^\s*\[\[?[^\]]*|^\s*(?:-\s*)?|[.{,]\s*)(?:` + last + `|"` + last + `"|'` + last + `')\s*[\].=:]`)
+
+	lines := strings.Split(string(raw), "\n")
+	for i := start - 1; i < len(lines); i++ {
+		if pattern.MatchString(lines[i]) {
+			return i + 1
+		}
+	}
+
+	return start
+}
+
+type domainClaim struct {
+	path    string
+	line    int
+	service string
+}
+
+// domainClaims are the domains of the services seen so far.
+// nginx only uses the first server block for a domain, and the first service
+// in the SNI map, so a domain cannot be used by two services of the same kind
+type domainClaims map[string]domainClaim
+
+func (c domainClaims) claim(path string, line int, name string, service internal.Service) []Diagnostic {
+	var kind string
+	switch strings.ToLower(service.Type) {
+	case "", "http":
+		kind = "http"
+	case "tls-passthrough":
+		kind = "sni"
+	default:
+		// Certificate services share their domains with the services that use them
+		return nil
+	}
+
+	var diagnostics []Diagnostic
+	for _, domain := range service.Domains {
+		key := kind + " " + strings.ToLower(domain)
+
+		other, ok := c[key]
+		if ok && other.path == path && other.service == name {
+			// Listed twice in the same service
+			continue
+		}
+		if !ok {
+			c[key] = domainClaim{path: path, line: line, service: name}
+			continue
+		}
+
+		location := other.path
+		if other.line > 0 {
+			location = fmt.Sprintf("%s:%d", other.path, other.line)
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Path:    path,
+			Line:    line,
+			Service: name,
+			Message: fmt.Sprintf("domain %q is also used by %q in %s", domain, other.service, location),
+		})
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,156 @@
+package workers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{
+			name: "no unknown keys",
+			raw: `[web]
+domains = ["a.com"]
+httpsonly = true
+SSLSOURCE = "letsencrypt"
+upstreamOptions = {anything = "is allowed"}
+
+[[web.upstream]]
+address = "a:80"
+healthCheck = {type = "http", timeout = "5s"}
+`,
+		},
+		{
+			name: "typo in a service",
+			raw:  "[web]\ndomains = [\"a.com\"]\nhttpsOnyl = true\n",
+			want: []string{"web.httpsOnyl"},
+		},
+		{
+			name: "typos in nested arrays of tables",
+			raw: `[web]
+domains = ["a.com"]
+
+[[web.locations]]
+match = "/a"
+
+[[web.locations]]
+matc = "/b"
+
+[[web.locations.upstream]]
+address = "b:80"
+[web.locations.upstream.healthCheck]
+pth = "/"
+`,
+			want: []string{"web.locations.matc", "web.locations.upstream.healthCheck.pth"},
+		},
+		{
+			name: "same typo in more than one table",
+			raw: `[[web.upstream]]
+adress = "a:80"
+
+[[web.upstream]]
+adress = "b:80"
+`,
+			want: []string{"web.upstream.adress"},
+		},
+		{
+			name: "quoted keys",
+			raw: `["web.site"]
+"domians" = ["a.com"]
+'ssl source' = "letsencrypt"
+`,
+			want: []string{"web.site.domians", "web.site.ssl source"},
+		},
+		{
+			name: "dotted and inline keys",
+			raw:  "web.domains = [\"a.com\"]\nweb.upstream = [{address = \"a:80\", weight = 2}]\n",
+			want: []string{"web.upstream.weight"},
+		},
+		{
+			name: "fields that are not decoded",
+			raw:  "[[web.upstream]]\naddress = \"a:80\"\ndown = true\n",
+			want: []string{"web.upstream.down"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, unknown, err := decodeServices([]byte(tt.raw), "toml")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(unknown, tt.want) {
+				t.Errorf("got unknown keys %q, want %q", unknown, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceLine(t *testing.T) {
+	raw := []byte(`# The services
+[web]
+domains = ["a.com"]
+httpsOnyl = true
+
+[[web.locations]]
+match = "/a"
+
+[[web.locations]]
+matc = "/b"
+
+["api.v2"]
+domains = ["b.com"]
+
+[[ 'tables' .upstream]]
+address = "c:80"
+
+dotted.domains = ["d.com"]
+inline = {domains = ["e.com"], sslSourc = "x"}
+`)
+
+	services := []struct {
+		name string
+		want int
+	}{
+		{name: "web", want: 2},
+		{name: "api.v2", want: 12},
+		{name: "tables", want: 15},
+		{name: "dotted", want: 18},
+		{name: "inline", want: 19},
+		{name: "missing", want: 0},
+		{name: "api", want: 0},
+	}
+
+	for _, tt := range services {
+		if got := serviceLine(raw, tt.name); got != tt.want {
+			t.Errorf("serviceLine(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	keys := []struct {
+		key  string
+		want int
+	}{
+		{key: "web", want: 2},
+		{key: "web.httpsOnyl", want: 4},
+		{key: "web.locations.matc", want: 10},
+		{key: "web.locations", want: 6},
+		{key: "tables.upstream.address", want: 16},
+		{key: "api.v2.domains", want: 13},
+		{key: "inline.sslSourc", want: 19},
+		// The line of the service if the key cannot be found
+		{key: "web.nowhere", want: 2},
+		{key: "missing.domains", want: 0},
+	}
+
+	for _, tt := range keys {
+		if got := keyLine(raw, tt.key); got != tt.want {
+			t.Errorf("keyLine(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stephenafamo/janus/monitor"
+	"github.com/stephenafamo/kronika"
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/metrics"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// The states of a webhook delivery
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// How often to check for deliveries that are due
+const webhookSendInterval = time.Second
+
+// WebhookSender sends the queued service events to their webhooks.
+// Failed deliveries are retried with backoff until WEBHOOK_MAX_ATTEMPTS
+type WebhookSender struct {
+	DB       *sql.DB
+	Monitor  monitor.Monitor
+	Settings internal.Settings
+}
+
+func (w WebhookSender) Play(ctx context.Context) error {
+	for range kronika.Every(ctx, time.Now(), webhookSendInterval) {
+		err := w.SendWebhooks(context.Background()) // use new context
+		if err != nil {
+			err = fmt.Errorf("error sending webhooks: %w", err)
+			w.Monitor.CaptureException(err, nil)
+		}
+	}
+
+	return nil
+}
+
+func (w WebhookSender) SendWebhooks(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	deliveries, err := models.WebhookDeliveries(
+		models.WebhookDeliveryWhere.State.EQ(deliveryPending),
+		qm.OrderBy(models.WebhookDeliveryColumns.ID),
+	).All(ctx, w.DB)
+	if err != nil {
+		return fmt.Errorf("could not get pending deliveries: %w", err)
+	}
+
+	// The events of a service are sent in the order they happened.
+	// By ID, since services in different files can have the same name
+	byService := map[int64]models.WebhookDeliverySlice{}
+	for _, d := range deliveries {
+		byService[d.ServiceID.Int64] = append(byService[d.ServiceID.Int64], d)
+	}
+
+	wg.Add(len(byService))
+	for _, serviceDeliveries := range byService {
+		go w.sendServiceDeliveries(ctx, serviceDeliveries, &wg)
+	}
+	wg.Wait()
+
+	_, err = models.WebhookDeliveries(
+		models.WebhookDeliveryWhere.State.NEQ(deliveryPending),
+		models.WebhookDeliveryWhere.CreatedAt.LT(time.Now().Add(-w.Settings.WEBHOOK_HISTORY)),
+	).DeleteAll(ctx, w.DB)
+	if err != nil {
+		return fmt.Errorf("could not delete old deliveries: %w", err)
+	}
+
+	return nil
+}
+
+// sendServiceDeliveries sends the deliveries in order. If one is waiting to be
+// retried, the ones after it wait too
+func (w WebhookSender) sendServiceDeliveries(ctx context.Context, deliveries models.WebhookDeliverySlice, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for _, d := range deliveries {
+		if d.NextAttemptAt.Valid && time.Now().Before(d.NextAttemptAt.Time) {
+			return
+		}
+
+		if !w.deliver(ctx, d) {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt to send the event and saves the result.
+// It is false if the delivery is to be retried
+func (w WebhookSender) deliver(ctx context.Context, d *models.WebhookDelivery) bool {
+	logger := slog.With(
+		"service", d.ServiceName,
+		"event", d.Event,
+		"delivery", d.ID,
+	)
+
+	code, err := w.post(ctx, d)
+	metrics.WebhookDeliveries.Inc(metrics.Result(err))
+
+	d.Attempts++
+	d.ResponseCode = null.NewInt64(int64(code), code != 0)
+
+	switch {
+	case err == nil:
+		d.State = deliveryDelivered
+		d.DeliveredAt = null.TimeFrom(time.Now())
+		d.NextAttemptAt = null.Time{}
+		d.LastError = null.String{}
+		logger.Debug("delivered webhook", "attempts", d.Attempts)
+
+	case d.Attempts >= w.Settings.WEBHOOK_MAX_ATTEMPTS:
+		d.State = deliveryFailed
+		d.NextAttemptAt = null.Time{}
+		d.LastError = null.StringFrom(err.Error())
+		logger.Error("could not deliver webhook", "attempts", d.Attempts, "error", err)
+
+	default:
+		delay := backoff(w.Settings.WEBHOOK_RETRY_MIN, w.Settings.WEBHOOK_RETRY_MAX, d.Attempts)
+		d.NextAttemptAt = null.TimeFrom(time.Now().Add(delay))
+		d.LastError = null.StringFrom(err.Error())
+		logger.Warn(
+			"webhook delivery failed",
+			"attempts", d.Attempts,
+			"retry_at", d.NextAttemptAt.Time,
+			"error", err,
+		)
+	}
+
+	_, err = d.Update(ctx, w.DB, boil.Infer())
+	if err != nil {
+		err = fmt.Errorf("could not save delivery %d of %q: %w", d.ID, d.ServiceName, err)
+		w.Monitor.CaptureException(err, map[string]string{"service": d.ServiceName})
+		return false
+	}
+
+	return d.State != deliveryPending
+}
+
+// post sends the payload. The code is 0 if there was no response
+func (w WebhookSender) post(ctx context.Context, d *models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.Settings.WEBHOOK_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "warden")
+	req.Header.Set("X-Warden-Event", d.Event)
+	req.Header.Set("X-Warden-Delivery", strconv.FormatInt(d.ID, 10))
+	if d.Signature.Valid {
+		req.Header.Set("X-Warden-Signature", d.Signature.String)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error sending event to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,286 @@
+package workers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/warden/internal"
+	"github.com/stephenafamo/warden/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// testWebhook records the events it receives
+type testWebhook struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	events []receivedEvent
+	status func(e receivedEvent) int // the response to each event
+}
+
+type receivedEvent struct {
+	ServiceID int64
+	Event     string
+	Body      []byte
+	Signature string
+}
+
+func newTestWebhook(t *testing.T) *testWebhook {
+	w := &testWebhook{status: func(receivedEvent) int { return http.StatusOK }}
+
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		var payload webhookPayload
+		err = json.Unmarshal(body, &payload)
+		if err != nil {
+			t.Errorf("invalid payload %s: %v", body, err)
+		}
+		if event := r.Header.Get("X-Warden-Event"); event != payload.Type {
+			t.Errorf("got event header %q for a %q event", event, payload.Type)
+		}
+
+		e := receivedEvent{
+			ServiceID: payload.Service.ID,
+			Event:     payload.Type,
+			Body:      body,
+			Signature: r.Header.Get("X-Warden-Signature"),
+		}
+
+		w.mu.Lock()
+		w.events = append(w.events, e)
+		status := w.status(e)
+		w.mu.Unlock()
+
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(w.Close)
+
+	return w
+}
+
+// received returns the events each service received, in order
+func (w *testWebhook) received() map[int64][]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	received := map[int64][]string{}
+	for _, e := range w.events {
+		received[e.ServiceID] = append(received[e.ServiceID], e.Event)
+	}
+
+	return received
+}
+
+func testWebhookSender(t *testing.T) WebhookSender {
+	return WebhookSender{
+		DB:      testDB(t),
+		Monitor: newTestMonitor(t),
+		Settings: internal.Settings{
+			WEBHOOK_TIMEOUT:      5 * time.Second,
+			WEBHOOK_MAX_ATTEMPTS: 2,
+			WEBHOOK_RETRY_MIN:    time.Hour,
+			WEBHOOK_RETRY_MAX:    time.Hour,
+			WEBHOOK_HISTORY:      time.Hour,
+		},
+	}
+}
+
+// queueTestEvents queues the events for the service in order
+func queueTestEvents(t *testing.T, w WebhookSender, s *models.Service, events ...serviceEvent) {
+	t.Helper()
+
+	for _, event := range events {
+		err := queueServiceEvent(context.Background(), w.DB, s, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sendTestWebhooks sends the deliveries that are due
+func sendTestWebhooks(t *testing.T, w WebhookSender) {
+	t.Helper()
+
+	err := w.SendWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeDeliveriesDue sets the pending deliveries to be retried now
+func makeDeliveriesDue(t *testing.T, w WebhookSender) {
+	t.Helper()
+
+	_, err := models.WebhookDeliveries(
+		models.WebhookDeliveryWhere.State.EQ(deliveryPending),
+	).UpdateAll(context.Background(), w.DB, models.M{
+		models.WebhookDeliveryColumns.NextAttemptAt: null.TimeFrom(time.Now().Add(-time.Second).Round(0)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testDeliveries(t *testing.T, w WebhookSender) models.WebhookDeliverySlice {
+	t.Helper()
+
+	deliveries, err := models.WebhookDeliveries(
+		qm.OrderBy(models.WebhookDeliveryColumns.ID),
+	).All(context.Background(), w.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return deliveries
+}
+
+func TestSendWebhooksSignature(t *testing.T) {
+	w := testWebhookSender(t)
+	hook := newTestWebhook(t)
+
+	signed := addTestService(t, w.DB, "/config/signed.toml", "signed", internal.Service{
+		Webhook:       hook.URL,
+		WebhookSecret: "secret",
+	})
+	unsigned := addTestService(t, w.DB, "/config/unsigned.toml", "unsigned", internal.Service{
+		Webhook: hook.URL,
+	})
+	queueTestEvents(t, w, signed, ServiceConfigured)
+	queueTestEvents(t, w, unsigned, ServiceConfigured)
+
+	sendTestWebhooks(t, w)
+
+	if len(hook.events) != 2 {
+		t.Fatalf("got %d events, want 2", len(hook.events))
+	}
+	for _, e := range hook.events {
+		want := ""
+		if e.ServiceID == signed.ID {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write(e.Body)
+			want = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		}
+		if e.Signature != want {
+			t.Errorf("service %d: got signature %q, want %q", e.ServiceID, e.Signature, want)
+		}
+	}
+
+	for _, d := range testDeliveries(t, w) {
+		if d.State != deliveryDelivered || d.Attempts != 1 || d.ResponseCode.Int64 != http.StatusOK {
+			t.Errorf("%s: got state %q after %d attempts with code %d", d.ServiceName, d.State, d.Attempts, d.ResponseCode.Int64)
+		}
+	}
+}
+
+func TestSendWebhooksRetries(t *testing.T) {
+	w := testWebhookSender(t)
+	hook := newTestWebhook(t)
+	hook.status = func(receivedEvent) int { return http.StatusInternalServerError }
+
+	s := addTestService(t, w.DB, "/config/web.toml", "web", internal.Service{Webhook: hook.URL})
+	queueTestEvents(t, w, s, ServiceConfigured)
+
+	sendTestWebhooks(t, w)
+
+	d := testDeliveries(t, w)[0]
+	if d.State != deliveryPending || d.Attempts != 1 || d.ResponseCode.Int64 != http.StatusInternalServerError {
+		t.Fatalf("got state %q after %d attempts with code %d", d.State, d.Attempts, d.ResponseCode.Int64)
+	}
+	if !d.LastError.Valid {
+		t.Error("the error of the failed attempt was not saved")
+	}
+	if wait := time.Until(d.NextAttemptAt.Time); wait < 29*time.Minute || wait > time.Hour {
+		t.Errorf("got retry in %s, want between 30m and 1h", wait)
+	}
+
+	// Not sent again until it is due
+	sendTestWebhooks(t, w)
+	if len(hook.events) != 1 {
+		t.Fatalf("got %d events before the retry was due, want 1", len(hook.events))
+	}
+
+	makeDeliveriesDue(t, w)
+	sendTestWebhooks(t, w)
+
+	d = testDeliveries(t, w)[0]
+	if d.State != deliveryFailed || d.Attempts != 2 {
+		t.Errorf("got state %q after %d attempts, want %q after 2", d.State, d.Attempts, deliveryFailed)
+	}
+	if d.NextAttemptAt.Valid {
+		t.Error("a failed delivery is to be retried")
+	}
+
+	makeDeliveriesDue(t, w)
+	sendTestWebhooks(t, w)
+	if len(hook.events) != 2 {
+		t.Errorf("got %d events after the last attempt, want 2", len(hook.events))
+	}
+}
+
+func TestSendWebhooksOrder(t *testing.T) {
+	w := testWebhookSender(t)
+	hook := newTestWebhook(t)
+
+	// Services in different files can have the same name
+	a := addTestService(t, w.DB, "/config/a.toml", "web", internal.Service{Webhook: hook.URL})
+	b := addTestService(t, w.DB, "/config/b.toml", "web", internal.Service{Webhook: hook.URL})
+
+	// The first event of a fails once
+	failed := false
+	hook.status = func(e receivedEvent) int {
+		if e.ServiceID == a.ID && !failed {
+			failed = true
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	}
+
+	queueTestEvents(t, w, a, ServiceConfigured, HttpsConfigured)
+	queueTestEvents(t, w, b, ServiceConfigured, HttpsConfigured)
+
+	// The events of a wait for the retry but not those of b
+	sendTestWebhooks(t, w)
+
+	want := map[int64][]string{
+		a.ID: {ServiceConfigured.Type},
+		b.ID: {ServiceConfigured.Type, HttpsConfigured.Type},
+	}
+	if got := hook.received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	// Removing the service does not change the order
+	_, err := a.Delete(context.Background(), w.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeDeliveriesDue(t, w)
+	sendTestWebhooks(t, w)
+
+	want[a.ID] = []string{ServiceConfigured.Type, ServiceConfigured.Type, HttpsConfigured.Type}
+	if got := hook.received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v after the retry, want %v", got, want)
+	}
+
+	for _, d := range testDeliveries(t, w) {
+		if d.State != deliveryDelivered {
+			t.Errorf("%s of service %d: got state %q", d.Event, d.ServiceID.Int64, d.State)
+		}
+	}
+}